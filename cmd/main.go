@@ -16,10 +16,57 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	cfg := internal.LoadConfig()
-	storage := internal.NewStorage()
+	storage := internal.NewStorage(cfg)
+	snapshots := internal.NewSnapshotter(cfg, storage)
 	cachesrv := internal.NewCacheService(storage, cfg.DefaultTTL)
+	cachesrv.UseSnapshotter(snapshots)
+	functions := internal.NewFunctionStore(cfg)
+	cachesrv.UseFunctionStore(functions)
 	worker := internal.NewCleanupWorker(cfg, storage)
 
+	var aof *internal.AOF
+	if cfg.AppendOnly {
+		aof = internal.NewAOF(cfg, storage)
+	}
+
+	// The library file goes first, as it takes precedence over the
+	// libraries of a snapshot.
+	libraries, err := functions.Load()
+	if err != nil {
+		log.Fatal("Function libraries load error:", err)
+	}
+	if libraries > 0 {
+		fmt.Printf("Loaded %d function libraries from %s\n", libraries, functions.Path())
+	}
+
+	if aof != nil && aof.Exists() {
+		applied, err := aof.Replay(cachesrv)
+		if err != nil {
+			log.Fatal("AOF replay error:", err)
+		}
+		fmt.Printf("Replayed %d commands from %s\n", applied, aof.Path())
+	} else {
+		loaded, err := snapshots.Load()
+		if err != nil {
+			log.Fatal("Snapshot load error:", err)
+		}
+		fmt.Printf("Loaded %d keys from %s\n", loaded, snapshots.Path())
+	}
+
+	if aof != nil {
+		fresh := !aof.Exists()
+		if err := aof.Open(); err != nil {
+			log.Fatal("AOF open error:", err)
+		}
+		cachesrv.UseAOF(aof)
+		go aof.Run(ctx)
+
+		// A new log starts from the dataset loaded from the snapshot.
+		if fresh {
+			aof.BackgroundRewrite()
+		}
+	}
+
 	respServer := resp2.NewRESP2Server(cfg, cachesrv, ctx)
 	httpServer := http_s.NewHttpServer(cfg, cachesrv, ctx)
 
@@ -39,9 +86,28 @@ func main() {
 	}()
 
 	go worker.Run(ctx)
+	go snapshots.Run(ctx)
 	fmt.Printf("Default TTL: %v, Cleanup interval: %v\n", cfg.DefaultTTL, cfg.CleanupInterval)
+	if cfg.MaxMemory > 0 {
+		fmt.Printf("Max memory: %d bytes, Eviction policy: %s\n", cfg.MaxMemory, cfg.EvictionPolicy)
+	}
 
 	<-sigChan
 	cancel()
 	respServer.Shutdown()
+
+	if len(cfg.SaveRules) > 0 {
+		if err := snapshots.Save(); err != nil {
+			fmt.Printf("Snapshot save error on shutdown: %v\n", err)
+		}
+	}
+
+	if aof != nil {
+		if err := aof.Close(); err != nil {
+			fmt.Printf("AOF close error: %v\n", err)
+		}
+	}
+
+	expired := worker.Stats()
+	fmt.Printf("Expired %d keys in %d cycles (%d over budget)\n", expired.ExpiredKeys, expired.Cycles, expired.TimedOutCycles)
 }
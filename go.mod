@@ -2,4 +2,7 @@ module cago
 
 go 1.25.5
 
-require github.com/go-chi/chi/v5 v5.2.3
+require (
+	github.com/go-chi/chi/v5 v5.2.3
+	github.com/yuin/gopher-lua v1.1.1
+)
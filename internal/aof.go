@@ -0,0 +1,472 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"
+	FsyncEverySec FsyncPolicy = "everysec"
+	FsyncNo       FsyncPolicy = "no"
+)
+
+var (
+	ErrRewriteInProgress = errors.New("background append only file rewriting already in progress")
+	ErrAOFCorrupt        = errors.New("append only file is corrupt")
+)
+
+func ParseFsyncPolicy(name string) (FsyncPolicy, bool) {
+	switch policy := FsyncPolicy(name); policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return policy, true
+	}
+	return "", false
+}
+
+// AOF is an append-only log of every write, stored as RESP arrays of bulk
+// strings so it can be inspected with ordinary tools. It implements
+// Propagator and is fed by Storage.
+type AOF struct {
+	cfg     *Config
+	storage *Storage
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	err  error
+	// pendingSync is set when data was written since the last fsync.
+	pendingSync bool
+
+	// rewriteBuf collects commands propagated while a rewrite is running,
+	// to be appended to the new file before it replaces the old one.
+	rewriting  bool
+	rewriteBuf []byte
+	baseSize   int64
+
+	rewriteActive atomic.Bool
+}
+
+func NewAOF(cfg *Config, storage *Storage) *AOF {
+	return &AOF{
+		cfg:     cfg,
+		storage: storage,
+	}
+}
+
+func (a *AOF) Path() string {
+	return filepath.Join(a.cfg.Dir, a.cfg.AppendFilename)
+}
+
+// Exists reports whether there is a log to replay.
+func (a *AOF) Exists() bool {
+	_, err := os.Stat(a.Path())
+	return err == nil
+}
+
+// Replay applies every command in the log through cachesrv. A command cut
+// short at the end of the file, as left by a crash mid-write, is dropped
+// and the file truncated to the last complete command.
+func (a *AOF) Replay(cachesrv *CacheService) (int, error) {
+	f, err := os.OpenFile(a.Path(), os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := &aofReader{r: bufio.NewReader(f)}
+	applied := 0
+	for {
+		args, err := reader.readCommand()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			fmt.Printf("AOF truncated after %d commands, dropping incomplete tail\n", applied)
+			if err := f.Truncate(reader.offset); err != nil {
+				return applied, err
+			}
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		if err := cachesrv.ApplyCommand(args); err != nil {
+			return applied, fmt.Errorf("%w: command %d: %v", ErrAOFCorrupt, applied+1, err)
+		}
+		applied++
+	}
+
+	return applied, nil
+}
+
+// Open starts appending to the log, creating it when missing. It must be
+// called after Replay and before the AOF is added as a propagator.
+func (a *AOF) Open() error {
+	f, err := os.OpenFile(a.Path(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	a.mu.Lock()
+	a.file = f
+	a.size = info.Size()
+	a.baseSize = info.Size()
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *AOF) Propagate(args [][]byte) {
+	buf := appendCommand(nil, args)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, buf...)
+	}
+
+	if _, err := a.file.Write(buf); err != nil {
+		a.setErr(err)
+		return
+	}
+	a.size += int64(len(buf))
+	a.pendingSync = true
+
+	if a.cfg.AppendFsync == FsyncAlways {
+		a.syncLocked()
+	}
+}
+
+// Run fsyncs once per second under the everysec policy and starts an
+// automatic rewrite when the log outgrew its configured threshold.
+func (a *AOF) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.cfg.AppendFsync == FsyncEverySec {
+				a.syncLocked()
+			}
+			grow := a.shouldRewriteLocked()
+			a.mu.Unlock()
+
+			if grow {
+				fmt.Println("Starting automatic rewriting of AOF")
+				a.BackgroundRewrite()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close flushes the log to disk and closes it.
+func (a *AOF) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
+
+func (a *AOF) RewriteInProgress() bool {
+	return a.rewriteActive.Load()
+}
+
+// BackgroundRewrite compacts the log to the commands needed to rebuild the
+// current dataset. Writes keep going to the old log meanwhile and are also
+// buffered, then appended to the new log right before it is swapped in.
+func (a *AOF) BackgroundRewrite() error {
+	if !a.rewriteActive.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+
+	go func() {
+		defer a.rewriteActive.Store(false)
+
+		fmt.Println("Background append only file rewriting started")
+		if err := a.rewrite(); err != nil {
+			fmt.Printf("Background AOF rewrite error: %v\n", err)
+			return
+		}
+		fmt.Println("Background AOF rewrite finished successfully")
+	}()
+
+	return nil
+}
+
+func (a *AOF) rewrite() error {
+	abort := func(err error) error {
+		a.mu.Lock()
+		a.rewriting = false
+		a.rewriteBuf = nil
+		a.mu.Unlock()
+		return err
+	}
+
+	tmp, err := os.CreateTemp(a.cfg.Dir, "temp-rewriteaof-*.aof")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	out := bufio.NewWriter(tmp)
+	var buf []byte
+	_, libs, err := a.storage.walkSnapshot(func() {
+		a.mu.Lock()
+		a.rewriting = true
+		a.rewriteBuf = nil
+		a.mu.Unlock()
+	}, func(e snapshotEntry) error {
+		buf = buf[:0]
+		for _, cmd := range entryCommands(e) {
+			buf = appendCommand(buf, cmd)
+		}
+		_, err := out.Write(buf)
+		return err
+	})
+	if err == nil && len(libs) > 0 {
+		_, err = out.Write(appendCommand(nil, functionsCommand(libs)))
+	}
+	if err != nil {
+		tmp.Close()
+		return abort(err)
+	}
+	if err := out.Flush(); err != nil {
+		tmp.Close()
+		return abort(err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rewriting = false
+	tail := a.rewriteBuf
+	a.rewriteBuf = nil
+
+	if _, err := tmp.Write(tail); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), a.Path()); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// The renamed file was opened for writing by CreateTemp, but not in
+	// append mode, so reopen it for appends.
+	tmp.Close()
+	f, err := os.OpenFile(a.Path(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		a.setErr(err)
+		return err
+	}
+
+	a.file.Close()
+	a.file = f
+	a.size = info.Size()
+	a.baseSize = info.Size()
+	a.pendingSync = false
+	return nil
+}
+
+func (a *AOF) shouldRewriteLocked() bool {
+	if a.cfg.AutoRewritePercentage <= 0 || a.size < a.cfg.AutoRewriteMinSize {
+		return false
+	}
+	if a.rewriteActive.Load() || a.baseSize == 0 {
+		return false
+	}
+
+	growth := (a.size - a.baseSize) * 100 / a.baseSize
+	return growth >= int64(a.cfg.AutoRewritePercentage)
+}
+
+func (a *AOF) syncLocked() {
+	if !a.pendingSync {
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		a.setErr(err)
+		return
+	}
+	a.pendingSync = false
+}
+
+func (a *AOF) setErr(err error) {
+	if a.err == nil {
+		fmt.Printf("AOF write error: %v\n", err)
+	}
+	a.err = err
+}
+
+// aofRewriteItemsPerCmd caps the elements per command when an aggregate is
+// rewritten, so huge values do not turn into a single huge command.
+const aofRewriteItemsPerCmd = 64
+
+// entryCommands turns a snapshot entry into the commands recreating it.
+func entryCommands(e snapshotEntry) [][][]byte {
+	if e.typ == TypeString {
+		return [][][]byte{setCommand(e.key, e.item())}
+	}
+
+	var cmds [][][]byte
+	switch e.typ {
+	case TypeHash:
+		args := make([][]byte, 0, 2*len(e.hash))
+		for field, val := range e.hash {
+			args = append(args, []byte(field), val)
+		}
+		cmds = chunkCommands("HSET", e.key, args, 2)
+	case TypeList:
+		cmds = chunkCommands("RPUSH", e.key, e.list, 1)
+	case TypeSet:
+		args := make([][]byte, 0, len(e.set))
+		for m := range e.set {
+			args = append(args, []byte(m))
+		}
+		cmds = chunkCommands("SADD", e.key, args, 1)
+	case TypeZSet:
+		args := make([][]byte, 0, 2*len(e.zset))
+		for _, m := range e.zset {
+			args = append(args, formatScore(m.Score), []byte(m.Member))
+		}
+		cmds = chunkCommands("ZADD", e.key, args, 2)
+	}
+
+	if !e.expiresAt.IsZero() {
+		cmds = append(cmds, command("PEXPIREAT", e.key, formatUnixMilli(e.expiresAt)))
+	}
+	return cmds
+}
+
+// chunkCommands spreads args over commands carrying at most
+// aofRewriteItemsPerCmd items of width arguments each.
+func chunkCommands(name, key string, args [][]byte, width int) [][][]byte {
+	var cmds [][][]byte
+	for len(args) > 0 {
+		n := min(len(args), aofRewriteItemsPerCmd*width)
+		cmds = append(cmds, command(name, key, args[:n]...))
+		args = args[n:]
+	}
+	return cmds
+}
+
+func appendCommand(buf []byte, args [][]byte) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// aofReader decodes the RESP arrays written by appendCommand and tracks the
+// offset of the last complete command.
+type aofReader struct {
+	r      *bufio.Reader
+	offset int64
+	read   int64
+}
+
+func (r *aofReader) readCommand() ([][]byte, error) {
+	count, err := r.readHeader('*')
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([][]byte, count)
+	for i := range args {
+		n, err := r.readHeader('$')
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if n > maxSnapshotBulk {
+			return nil, fmt.Errorf("%w: bulk length %d", ErrAOFCorrupt, n)
+		}
+
+		arg := make([]byte, n+2)
+		if _, err := io.ReadFull(r.r, arg); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		r.read += int64(len(arg))
+		args[i] = arg[:n]
+	}
+
+	r.offset = r.read
+	return args, nil
+}
+
+func (r *aofReader) readHeader(prefix byte) (int, error) {
+	line, err := r.r.ReadString('\n')
+	r.read += int64(len(line))
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, fmt.Errorf("%w: unexpected line %q", ErrAOFCorrupt, line)
+	}
+
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrAOFCorrupt, line)
+	}
+	return n, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
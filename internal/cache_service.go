@@ -2,46 +2,124 @@ package internal
 
 import (
 	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	ErrKeyEmpty = errors.New("key cannot be empty")
-	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyEmpty            = errors.New("key cannot be empty")
+	ErrKeyNotFound         = errors.New("key not found")
+	ErrPersistenceDisabled = errors.New("persistence is disabled")
+	ErrReadOnly            = errors.New("You can't write against a read only replica.")
 )
 
 type CacheService struct {
 	storage    *Storage
 	defaultTTL time.Duration
+	snapshots  *Snapshotter
+	aof        *AOF
+	loader     FunctionLoader
+	pubsub     *PubSub
+	readOnly   atomic.Bool
 }
 
 func NewCacheService(storage *Storage, defaultTTL time.Duration) *CacheService {
+	pubsub := NewPubSub()
+	storage.UsePubSub(pubsub)
+
 	return &CacheService{
-		storage: storage,
+		storage:    storage,
 		defaultTTL: defaultTTL,
+		pubsub:     pubsub,
 	}
 }
 
-func (s *CacheService) Set(key, value string, ttl time.Duration) error {
-	if key == ""{
+// UseSnapshotter enables SAVE/BGSAVE style persistence through sn.
+func (s *CacheService) UseSnapshotter(sn *Snapshotter) {
+	s.snapshots = sn
+}
+
+// UseAOF logs every subsequent write to aof. The log must already be open.
+func (s *CacheService) UseAOF(aof *AOF) {
+	s.aof = aof
+	s.storage.AddPropagator(aof)
+}
+
+// AddPropagator forwards every subsequent write to p, see Propagator.
+func (s *CacheService) AddPropagator(p Propagator) {
+	s.storage.AddPropagator(p)
+}
+
+// SetReadOnly makes client writes fail with ErrReadOnly. Commands applied
+// through ApplyCommand are not affected.
+func (s *CacheService) SetReadOnly(readOnly bool) {
+	s.readOnly.Store(readOnly)
+}
+
+func (s *CacheService) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+func (s *CacheService) Set(key string, value []byte, ttl time.Duration) error {
+	return s.SetWithContentType(key, value, "", ttl)
+}
+
+// SetWithContentType stores value together with the media type it was
+// uploaded with, so it can be served back verbatim.
+func (s *CacheService) SetWithContentType(key string, value []byte, contentType string, ttl time.Duration) error {
+	if key == "" {
 		return ErrKeyEmpty
 	}
 
+	if s.readOnly.Load() {
+		return ErrReadOnly
+	}
+
 	if ttl == 0 {
 		ttl = s.defaultTTL
 	}
 
-	s.storage.Set(key, value, ttl)
-	return nil
+	return s.storage.Set(key, value, contentType, expiresIn(ttl))
+}
+
+// SetWithOptions is SetWithContentType with the conditions of SET, see
+// Storage.SetWithOptions. An expiry given in opts.ExpiresAt takes
+// precedence over ttl, and the default TTL applies when both are zero.
+func (s *CacheService) SetWithOptions(key string, value []byte, contentType string, ttl time.Duration, opts SetOptions) ([]byte, bool, bool, error) {
+	if key == "" {
+		return nil, false, false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, false, false, ErrReadOnly
+	}
+
+	if opts.ExpiresAt.IsZero() {
+		if ttl == 0 {
+			ttl = s.defaultTTL
+		}
+		opts.ExpiresAt = expiresIn(ttl)
+	}
+
+	return s.storage.SetWithOptions(key, value, contentType, opts)
+}
+
+func (s *CacheService) Get(key string) ([]byte, bool, error) {
+	if key == "" {
+		return nil, false, ErrKeyEmpty
+	}
+
+	return s.storage.Get(key)
 }
 
-func (s *CacheService) Get(key string) (string, bool, error) {
-	if key == ""{
-		return "", false, ErrKeyEmpty
+func (s *CacheService) GetWithContentType(key string) ([]byte, string, bool, error) {
+	if key == "" {
+		return nil, "", false, ErrKeyEmpty
 	}
 
-	val, exists := s.storage.Get(key)
-	return val, exists, nil
+	return s.storage.GetWithContentType(key)
 }
 
 func (s *CacheService) Delete(key string) (bool, error) {
@@ -49,6 +127,10 @@ func (s *CacheService) Delete(key string) (bool, error) {
 		return false, ErrKeyEmpty
 	}
 
+	if s.readOnly.Load() {
+		return false, ErrReadOnly
+	}
+
 	deleted := s.storage.Delete(key)
 	return deleted, nil
 }
@@ -62,16 +144,52 @@ func (s *CacheService) Exists(key string) (bool, error) {
 	return exists, nil
 }
 
-func (s *CacheService) Expire(key string, ttl time.Duration) error {
-	if key == ""{
-		return ErrKeyEmpty
+// Expire sets the TTL of an existing key when cond allows it. A TTL that
+// is not positive deletes the key. It reports whether the key was changed.
+func (s *CacheService) Expire(key string, ttl time.Duration, cond ExpireCondition) (bool, error) {
+	return s.ExpireAt(key, utcNow().Add(ttl), cond)
+}
+
+// ExpireAt is Expire with an absolute time, which deletes the key when it
+// is not in the future.
+func (s *CacheService) ExpireAt(key string, at time.Time, cond ExpireCondition) (bool, error) {
+	if key == "" {
+		return false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return false, ErrReadOnly
+	}
+
+	return s.storage.Expire(key, at, cond), nil
+}
+
+// Persist removes the TTL of key. It reports false when the key does not
+// exist or has no TTL.
+func (s *CacheService) Persist(key string) (bool, error) {
+	if key == "" {
+		return false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return false, ErrReadOnly
+	}
+
+	return s.storage.Persist(key), nil
+}
+
+// Type returns the type of the value under key, "none" when it does not
+// exist.
+func (s *CacheService) Type(key string) (string, error) {
+	if key == "" {
+		return "", ErrKeyEmpty
 	}
 
-	success := s.storage.SetTTL(key, ttl)
-	if !success {
-		return ErrKeyNotFound
+	typ, exists := s.storage.Type(key)
+	if !exists {
+		return "none", nil
 	}
-	return nil
+	return typ.String(), nil
 }
 
 func (s *CacheService) TTL(key string) (time.Duration, error) {
@@ -79,11 +197,38 @@ func (s *CacheService) TTL(key string) (time.Duration, error) {
 		return 0, ErrKeyEmpty
 	}
 
-	ttl, exists := s.storage.GetTTL(key)
+	expiresAt, exists := s.storage.GetExpiresAt(key)
 	if !exists {
 		return -2 * time.Second, nil
 	}
-	return ttl, nil
+	if expiresAt.IsZero() {
+		return -1 * time.Second, nil
+	}
+	return expiresAt.Sub(*utcNow()), nil
+}
+
+// ExpireTime returns the absolute expiry of key, zero when it has none, and
+// whether the key exists.
+func (s *CacheService) ExpireTime(key string) (time.Time, bool, error) {
+	if key == "" {
+		return time.Time{}, false, ErrKeyEmpty
+	}
+
+	expiresAt, exists := s.storage.GetExpiresAt(key)
+	return expiresAt, exists, nil
+}
+
+func (s *CacheService) MemoryUsage(key string) (int64, bool, error) {
+	if key == "" {
+		return 0, false, ErrKeyEmpty
+	}
+
+	usage, exists := s.storage.MemoryUsage(key)
+	return usage, exists, nil
+}
+
+func (s *CacheService) MemoryStats() MemoryStats {
+	return s.storage.MemoryStats()
 }
 
 func (s *CacheService) Keys(pattern string) ([]string, error) {
@@ -93,4 +238,101 @@ func (s *CacheService) Keys(pattern string) ([]string, error) {
 
 	keys := s.storage.Keys(pattern)
 	return keys, nil
-}
\ No newline at end of file
+}
+
+// Scan iterates the keyspace like SCAN, keeping only keys of type typ when
+// it is not empty. Start with cursor 0 and stop once the returned cursor is
+// 0 again.
+func (s *CacheService) Scan(cursor uint64, pattern string, count int, typ string) ([]string, uint64, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	keys, next := s.storage.Scan(cursor, pattern, count, strings.ToLower(typ))
+	return keys, next, nil
+}
+
+func (s *CacheService) Save() error {
+	if s.snapshots == nil {
+		return ErrPersistenceDisabled
+	}
+	return s.snapshots.Save()
+}
+
+func (s *CacheService) BackgroundSave() error {
+	if s.snapshots == nil {
+		return ErrPersistenceDisabled
+	}
+	return s.snapshots.BackgroundSave()
+}
+
+func (s *CacheService) LastSave() (time.Time, error) {
+	if s.snapshots == nil {
+		return time.Time{}, ErrPersistenceDisabled
+	}
+	return s.snapshots.LastSave(), nil
+}
+
+// DumpSnapshot writes the dataset to w in snapshot format. mark runs while
+// the dataset is frozen, see Storage.WriteSnapshot.
+func (s *CacheService) DumpSnapshot(w io.Writer, mark func()) error {
+	_, err := s.storage.WriteSnapshot(w, mark)
+	return err
+}
+
+// RestoreSnapshot replaces the whole dataset and the function libraries with
+// the snapshot read from r. The snapshot is validated before the current
+// dataset is dropped.
+func (s *CacheService) RestoreSnapshot(r io.Reader) (int, error) {
+	loaded, libs, err := s.storage.ReplaceWithSnapshot(r)
+	if err != nil || libs == nil || s.storage.functions == nil {
+		return loaded, err
+	}
+	return loaded, s.loadFunctions(libs, s.storage.functions.Save)
+}
+
+func (s *CacheService) RewriteAppendOnly() error {
+	if s.aof == nil {
+		return ErrPersistenceDisabled
+	}
+	return s.aof.BackgroundRewrite()
+}
+
+type PersistenceStats struct {
+	Dirty             int64
+	SnapshotEnabled   bool
+	SaveInProgress    bool
+	LastSave          time.Time
+	LastSaveErr       error
+	AOFEnabled        bool
+	RewriteInProgress bool
+}
+
+func (s *CacheService) PersistenceStats() PersistenceStats {
+	stats := PersistenceStats{
+		Dirty: s.storage.Dirty(),
+	}
+
+	if s.snapshots != nil {
+		stats.SnapshotEnabled = true
+		stats.SaveInProgress = s.snapshots.InProgress()
+		stats.LastSave = s.snapshots.LastSave()
+		stats.LastSaveErr = s.snapshots.LastError()
+	}
+
+	if s.aof != nil {
+		stats.AOFEnabled = true
+		stats.RewriteInProgress = s.aof.RewriteInProgress()
+	}
+
+	return stats
+}
+
+// expiresIn converts a relative TTL to the absolute expiry Storage works
+// with. Non-positive TTLs mean no expiry.
+func expiresIn(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return utcNow().Add(ttl)
+}
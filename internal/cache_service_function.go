@@ -0,0 +1,44 @@
+package internal
+
+// FunctionLoader makes libs the libraries of the scripting engine, storing
+// them with save, as only the engine can run their code. It is used for
+// libraries coming from a primary or from the append-only log.
+type FunctionLoader func(libs []FunctionLibrary, save func([]FunctionLibrary) error) error
+
+// UseFunctionStore keeps the function libraries in fs. It must be called
+// before the storage is shared between goroutines.
+func (s *CacheService) UseFunctionStore(fs *FunctionStore) {
+	s.storage.functions = fs
+}
+
+// UseFunctionLoader hands the libraries restored from a snapshot or a
+// propagated command to loader. Until it is called, they are only stored,
+// to be loaded by the engine when it starts. It must be called before any
+// command is applied.
+func (s *CacheService) UseFunctionLoader(loader FunctionLoader) {
+	s.loader = loader
+}
+
+// FunctionLibraries returns the stored function libraries.
+func (s *CacheService) FunctionLibraries() []FunctionLibrary {
+	if s.storage.functions == nil {
+		return nil
+	}
+	return s.storage.functions.Libraries()
+}
+
+// SaveFunctionLibraries stores libs in place of the current libraries and
+// propagates them. Without a store they only live as long as the process.
+func (s *CacheService) SaveFunctionLibraries(libs []FunctionLibrary) error {
+	if s.storage.functions == nil {
+		return nil
+	}
+	return s.storage.saveFunctions(libs)
+}
+
+func (s *CacheService) loadFunctions(libs []FunctionLibrary, save func([]FunctionLibrary) error) error {
+	if s.loader == nil {
+		return save(libs)
+	}
+	return s.loader(libs, save)
+}
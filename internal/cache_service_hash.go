@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"errors"
+)
+
+var ErrNoFields = errors.New("no fields given")
+
+// HSet stores fields in the hash under key and returns how many of them
+// were new. Unlike Set, a hash created this way does not get the default
+// TTL, as in Redis only string writes take an expiry.
+func (s *CacheService) HSet(key string, fields []HashField) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if len(fields) == 0 {
+		return 0, ErrNoFields
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.HSet(key, fields, false)
+}
+
+// HSetNX stores field only if it does not exist yet.
+func (s *CacheService) HSetNX(key, field string, value []byte) (bool, error) {
+	if key == "" {
+		return false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return false, ErrReadOnly
+	}
+
+	added, err := s.storage.HSet(key, []HashField{{Field: field, Value: value}}, true)
+	return added == 1, err
+}
+
+func (s *CacheService) HGet(key, field string) ([]byte, bool, error) {
+	if key == "" {
+		return nil, false, ErrKeyEmpty
+	}
+
+	return s.storage.HGet(key, field)
+}
+
+func (s *CacheService) HMGet(key string, fields []string) ([][]byte, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.HMGet(key, fields)
+}
+
+func (s *CacheService) HDel(key string, fields []string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.HDel(key, fields)
+}
+
+func (s *CacheService) HExists(key, field string) (bool, error) {
+	_, exists, err := s.HGet(key, field)
+	return exists, err
+}
+
+func (s *CacheService) HLen(key string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	return s.storage.HLen(key)
+}
+
+func (s *CacheService) HGetAll(key string) ([]HashField, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.HGetAll(key)
+}
+
+func (s *CacheService) HKeys(key string) ([]string, error) {
+	fields, err := s.HGetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.Field
+	}
+	return keys, nil
+}
+
+func (s *CacheService) HVals(key string) ([][]byte, error) {
+	fields, err := s.HGetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([][]byte, len(fields))
+	for i, f := range fields {
+		vals[i] = f.Value
+	}
+	return vals, nil
+}
+
+func (s *CacheService) HIncrBy(key, field string, delta int64) (int64, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.HIncrBy(key, field, delta)
+}
+
+// HIncrByFloat returns the new value formatted as it was stored.
+func (s *CacheService) HIncrByFloat(key, field string, delta float64) ([]byte, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, ErrReadOnly
+	}
+
+	return s.storage.HIncrByFloat(key, field, delta)
+}
+
+// HScan iterates the hash under key like HSCAN. Start with cursor 0 and
+// stop once the returned cursor is 0 again.
+func (s *CacheService) HScan(key string, cursor uint64, pattern string, count int) ([]HashField, uint64, error) {
+	if key == "" {
+		return nil, 0, ErrKeyEmpty
+	}
+
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	return s.storage.HScan(key, cursor, pattern, count)
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LPush prepends elems to the list under key, so the last one ends up at
+// the head, and returns the new length. Like hashes, lists do not get the
+// default TTL.
+func (s *CacheService) LPush(key string, elems [][]byte) (int, error) {
+	return s.push(key, elems, true)
+}
+
+// RPush appends elems to the list under key and returns the new length.
+func (s *CacheService) RPush(key string, elems [][]byte) (int, error) {
+	return s.push(key, elems, false)
+}
+
+func (s *CacheService) push(key string, elems [][]byte, left bool) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if len(elems) == 0 {
+		return 0, ErrNoFields
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.Push(key, elems, left)
+}
+
+// LPop removes and returns up to count elements from the head of the list.
+// It returns nil when the key does not exist.
+func (s *CacheService) LPop(key string, count int) ([][]byte, error) {
+	return s.pop(key, true, count)
+}
+
+// RPop removes and returns up to count elements from the tail of the list.
+func (s *CacheService) RPop(key string, count int) ([][]byte, error) {
+	return s.pop(key, false, count)
+}
+
+func (s *CacheService) pop(key string, left bool, count int) ([][]byte, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, ErrReadOnly
+	}
+
+	return s.storage.Pop(key, left, count)
+}
+
+func (s *CacheService) LLen(key string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	return s.storage.LLen(key)
+}
+
+func (s *CacheService) LRange(key string, start, stop int) ([][]byte, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.LRange(key, start, stop)
+}
+
+func (s *CacheService) LIndex(key string, index int) ([]byte, bool, error) {
+	if key == "" {
+		return nil, false, ErrKeyEmpty
+	}
+
+	return s.storage.LIndex(key, index)
+}
+
+func (s *CacheService) LSet(key string, index int, elem []byte) error {
+	if key == "" {
+		return ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return ErrReadOnly
+	}
+
+	return s.storage.LSet(key, index, elem)
+}
+
+func (s *CacheService) LRem(key string, count int, elem []byte) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.LRem(key, count, elem)
+}
+
+func (s *CacheService) LTrim(key string, start, stop int) error {
+	if key == "" {
+		return ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return ErrReadOnly
+	}
+
+	return s.storage.LTrim(key, start, stop)
+}
+
+func (s *CacheService) LInsert(key string, before bool, pivot, elem []byte) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.LInsert(key, before, pivot, elem)
+}
+
+func (s *CacheService) LPos(key string, elem []byte, rank, count, maxLen int) ([]int, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.LPos(key, elem, rank, count, maxLen)
+}
+
+func (s *CacheService) LMove(src, dst string, srcLeft, dstLeft bool) ([]byte, bool, error) {
+	if src == "" || dst == "" {
+		return nil, false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, false, ErrReadOnly
+	}
+
+	return s.storage.LMove(src, dst, srcLeft, dstLeft)
+}
+
+// BLPop pops from the head of the first non-empty list among keys, waiting
+// up to timeout for one to receive an element, 0 meaning forever. It
+// returns the key popped from, or false on timeout. Waiting stops early
+// with ctx.Err() once ctx is done.
+func (s *CacheService) BLPop(ctx context.Context, keys []string, timeout time.Duration) (string, []byte, bool, error) {
+	return s.blockingPop(ctx, keys, true, timeout)
+}
+
+// BRPop is BLPop popping from the tail.
+func (s *CacheService) BRPop(ctx context.Context, keys []string, timeout time.Duration) (string, []byte, bool, error) {
+	return s.blockingPop(ctx, keys, false, timeout)
+}
+
+func (s *CacheService) blockingPop(ctx context.Context, keys []string, left bool, timeout time.Duration) (string, []byte, bool, error) {
+	for _, key := range keys {
+		if key == "" {
+			return "", nil, false, ErrKeyEmpty
+		}
+	}
+
+	if s.readOnly.Load() {
+		return "", nil, false, ErrReadOnly
+	}
+
+	return s.storage.BlockingPop(ctx, keys, left, timeout)
+}
+
+// BLMove is LMove waiting for src like BLPop.
+func (s *CacheService) BLMove(ctx context.Context, src, dst string, srcLeft, dstLeft bool, timeout time.Duration) ([]byte, bool, error) {
+	if src == "" || dst == "" {
+		return nil, false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, false, ErrReadOnly
+	}
+
+	return s.storage.BlockingMove(ctx, src, dst, srcLeft, dstLeft, timeout)
+}
+
+// applyListCommand replays a propagated list write, see ApplyCommand.
+func (s *CacheService) applyListCommand(name, key string, args [][]byte) error {
+	ints := func(n int) ([]int, error) {
+		if len(args) < n {
+			return nil, fmt.Errorf("%w: %s with %d arguments", ErrUnknownCommand, name, len(args))
+		}
+
+		vals := make([]int, n)
+		for i := range vals {
+			v, err := strconv.Atoi(string(args[i]))
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+		}
+		return vals, nil
+	}
+
+	switch name {
+	case "LPUSH", "RPUSH":
+		_, err := s.storage.Push(key, args, name == "LPUSH")
+		return err
+	case "LPOP", "RPOP":
+		count := 1
+		if len(args) > 0 {
+			n, err := ints(1)
+			if err != nil {
+				return err
+			}
+			count = n[0]
+		}
+		_, err := s.storage.Pop(key, name == "LPOP", count)
+		return err
+	case "LSET":
+		n, err := ints(1)
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("%w: LSET without element", ErrUnknownCommand)
+		}
+		return s.storage.LSet(key, n[0], args[1])
+	case "LREM":
+		n, err := ints(1)
+		if err != nil {
+			return err
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("%w: LREM without element", ErrUnknownCommand)
+		}
+		_, err = s.storage.LRem(key, n[0], args[1])
+		return err
+	case "LTRIM":
+		n, err := ints(2)
+		if err != nil {
+			return err
+		}
+		return s.storage.LTrim(key, n[0], n[1])
+	case "LINSERT":
+		if len(args) != 3 {
+			return fmt.Errorf("%w: LINSERT without pivot and element", ErrUnknownCommand)
+		}
+		_, err := s.storage.LInsert(key, strings.EqualFold(string(args[0]), "BEFORE"), args[1], args[2])
+		return err
+	case "LMOVE":
+		if len(args) != 3 {
+			return fmt.Errorf("%w: LMOVE without directions", ErrUnknownCommand)
+		}
+		_, _, err := s.storage.LMove(key, string(args[0]), strings.EqualFold(string(args[1]), "LEFT"), strings.EqualFold(string(args[2]), "LEFT"))
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownCommand, name)
+}
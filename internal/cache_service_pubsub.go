@@ -0,0 +1,32 @@
+package internal
+
+// Publish sends payload to the subscribers of channel and of the patterns
+// matching it. It returns the number of deliveries.
+func (s *CacheService) Publish(channel string, payload []byte) int {
+	return s.pubsub.Publish(channel, payload)
+}
+
+// NewSubscriber returns a subscriber queueing up to buffer messages before
+// it is dropped as too slow. It must be closed once no longer used.
+func (s *CacheService) NewSubscriber(buffer int) *Subscriber {
+	return s.pubsub.NewSubscriber(buffer)
+}
+
+// PubSubChannels returns the active channels matching pattern, all of them
+// when pattern is empty.
+func (s *CacheService) PubSubChannels(pattern string) []string {
+	return s.pubsub.Channels(pattern)
+}
+
+func (s *CacheService) PubSubNumSub(channels []string) []int {
+	return s.pubsub.NumSub(channels)
+}
+
+func (s *CacheService) PubSubNumPat() int {
+	return s.pubsub.NumPat()
+}
+
+// NotifyKeyspaceEvents returns the keyspace notifications being published.
+func (s *CacheService) NotifyKeyspaceEvents() NotifyFlags {
+	return s.storage.NotifyFlags()
+}
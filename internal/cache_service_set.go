@@ -0,0 +1,142 @@
+package internal
+
+// SAdd adds members to the set under key and returns how many were new.
+// Like hashes, sets do not get the default TTL.
+func (s *CacheService) SAdd(key string, members []string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if len(members) == 0 {
+		return 0, ErrNoFields
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.SAdd(key, members)
+}
+
+func (s *CacheService) SRem(key string, members []string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.SRem(key, members)
+}
+
+func (s *CacheService) SMembers(key string) ([]string, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.SMembers(key)
+}
+
+func (s *CacheService) SIsMember(key, member string) (bool, error) {
+	found, err := s.SMIsMember(key, []string{member})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+func (s *CacheService) SMIsMember(key string, members []string) ([]bool, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.SMIsMember(key, members)
+}
+
+func (s *CacheService) SCard(key string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	return s.storage.SCard(key)
+}
+
+// SPop removes and returns up to count random members.
+func (s *CacheService) SPop(key string, count int) ([]string, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, ErrReadOnly
+	}
+
+	return s.storage.SPop(key, count)
+}
+
+// SRandMember returns up to count distinct random members, or -count
+// members that may repeat when count is negative.
+func (s *CacheService) SRandMember(key string, count int) ([]string, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.SRandMember(key, count)
+}
+
+func (s *CacheService) SMove(src, dst, member string) (bool, error) {
+	if src == "" || dst == "" {
+		return false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return false, ErrReadOnly
+	}
+
+	return s.storage.SMove(src, dst, member)
+}
+
+// SetOp returns the union, intersection or difference of the sets under
+// keys, missing keys counting as empty sets.
+func (s *CacheService) SetOp(op SetOp, keys []string) ([]string, error) {
+	for _, key := range keys {
+		if key == "" {
+			return nil, ErrKeyEmpty
+		}
+	}
+
+	return s.storage.SetOp(op, keys)
+}
+
+// SetOpStore stores the result of SetOp under dst and returns its size.
+func (s *CacheService) SetOpStore(op SetOp, dst string, keys []string) (int, error) {
+	if dst == "" {
+		return 0, ErrKeyEmpty
+	}
+	for _, key := range keys {
+		if key == "" {
+			return 0, ErrKeyEmpty
+		}
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.SetOpStore(op, dst, keys)
+}
+
+// SScan iterates the set under key like SSCAN. Start with cursor 0 and
+// stop once the returned cursor is 0 again.
+func (s *CacheService) SScan(key string, cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	if key == "" {
+		return nil, 0, ErrKeyEmpty
+	}
+
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	return s.storage.SScan(key, cursor, pattern, count)
+}
@@ -0,0 +1,152 @@
+package internal
+
+import "time"
+
+// IncrBy adds delta to the integer under key. Like Set, a key it creates
+// gets the default TTL, while an existing key keeps its own.
+func (s *CacheService) IncrBy(key string, delta int64) (int64, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.IncrBy(key, delta, expiresIn(s.defaultTTL))
+}
+
+// IncrByFloat adds delta to the number under key like IncrBy and returns
+// the new value as stored.
+func (s *CacheService) IncrByFloat(key string, delta float64) ([]byte, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, ErrReadOnly
+	}
+
+	return s.storage.IncrByFloat(key, delta, expiresIn(s.defaultTTL))
+}
+
+// Append adds value to the end of the string under key and returns its new
+// length. A key it creates gets the default TTL.
+func (s *CacheService) Append(key string, value []byte) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.Append(key, value, expiresIn(s.defaultTTL))
+}
+
+// SetRange overwrites the string under key from offset with value, padding
+// it with zero bytes as needed, and returns its new length.
+func (s *CacheService) SetRange(key string, offset int, value []byte) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.SetRange(key, offset, value, expiresIn(s.defaultTTL))
+}
+
+func (s *CacheService) StrLen(key string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	return s.storage.StrLen(key)
+}
+
+// GetRange returns the bytes of the string under key from start to end
+// inclusive, negative offsets counting from the end.
+func (s *CacheService) GetRange(key string, start, end int) ([]byte, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.GetRange(key, start, end)
+}
+
+// MGet returns the values of keys in order, nil for keys that are missing
+// or not strings.
+func (s *CacheService) MGet(keys []string) ([][]byte, error) {
+	for _, key := range keys {
+		if key == "" {
+			return nil, ErrKeyEmpty
+		}
+	}
+
+	return s.storage.MGet(keys), nil
+}
+
+// MSet stores all pairs at once with the default TTL.
+func (s *CacheService) MSet(pairs []KeyValue) error {
+	_, err := s.mset(pairs, false)
+	return err
+}
+
+// MSetNX is MSet that writes nothing when any of the keys exists. It reports
+// whether the pairs were written.
+func (s *CacheService) MSetNX(pairs []KeyValue) (bool, error) {
+	return s.mset(pairs, true)
+}
+
+func (s *CacheService) mset(pairs []KeyValue, onlyNew bool) (bool, error) {
+	if len(pairs) == 0 {
+		return false, ErrNoFields
+	}
+	for _, p := range pairs {
+		if p.Key == "" {
+			return false, ErrKeyEmpty
+		}
+	}
+
+	if s.readOnly.Load() {
+		return false, ErrReadOnly
+	}
+
+	return s.storage.MSet(pairs, expiresIn(s.defaultTTL), onlyNew)
+}
+
+// GetDel removes the string under key and returns its value.
+func (s *CacheService) GetDel(key string) ([]byte, bool, error) {
+	if key == "" {
+		return nil, false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, false, ErrReadOnly
+	}
+
+	return s.storage.GetDel(key)
+}
+
+// GetEx returns the string under key and, with update, sets its expiry to
+// expiresAt, removing it when expiresAt is zero.
+func (s *CacheService) GetEx(key string, expiresAt time.Time, update bool) ([]byte, bool, error) {
+	if key == "" {
+		return nil, false, ErrKeyEmpty
+	}
+
+	if update && s.readOnly.Load() {
+		return nil, false, ErrReadOnly
+	}
+
+	return s.storage.GetEx(key, expiresAt, update)
+}
+
+// GetSet stores value under key like Set and returns the string it
+// replaced.
+func (s *CacheService) GetSet(key string, value []byte) ([]byte, bool, error) {
+	old, existed, _, err := s.SetWithOptions(key, value, "", 0, SetOptions{Get: true})
+	return old, existed, err
+}
@@ -0,0 +1,41 @@
+package internal
+
+// Shared marks the start of a client operation on keys that must not
+// overlap a transaction on them, see Storage.Shared.
+func (s *CacheService) Shared(keys ...string) (unlock func()) {
+	return s.storage.Shared(keys...)
+}
+
+// SharedAll is Shared for an operation on the whole keyspace.
+func (s *CacheService) SharedAll() (unlock func()) {
+	return s.storage.SharedAll()
+}
+
+// Exclusive lets a transaction on keys run without other client operations
+// on them interleaving, see Storage.Exclusive.
+func (s *CacheService) Exclusive(keys ...string) (unlock func()) {
+	return s.storage.Exclusive(keys...)
+}
+
+// ExclusiveAll is Exclusive for a transaction on the whole keyspace.
+func (s *CacheService) ExclusiveAll() (unlock func()) {
+	return s.storage.ExclusiveAll()
+}
+
+// Watch returns the current state of keys for WATCH.
+func (s *CacheService) Watch(keys []string) ([]WatchedKey, error) {
+	watched := make([]WatchedKey, len(keys))
+	for i, key := range keys {
+		if key == "" {
+			return nil, ErrKeyEmpty
+		}
+		watched[i] = s.storage.Watch(key)
+	}
+	return watched, nil
+}
+
+// Modified reports whether any watched key changed since Watch, including
+// by expiring.
+func (s *CacheService) Modified(watched []WatchedKey) bool {
+	return s.storage.Modified(watched)
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// ZAdd adds members to the sorted set under key, or updates their scores,
+// as allowed by opts. Like hashes, sorted sets do not get the default TTL.
+func (s *CacheService) ZAdd(key string, members []ZMember, opts ZAddOptions) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if len(members) == 0 {
+		return 0, ErrNoFields
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.ZAdd(key, members, opts)
+}
+
+// ZAddIncr is ZADD with INCR. It reports false when opts prevented the
+// update.
+func (s *CacheService) ZAddIncr(key, member string, delta float64, opts ZAddOptions) (float64, bool, error) {
+	if key == "" {
+		return 0, false, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, false, ErrReadOnly
+	}
+
+	return s.storage.ZAddIncr(key, member, delta, opts)
+}
+
+func (s *CacheService) ZIncrBy(key, member string, delta float64) (float64, error) {
+	score, _, err := s.ZAddIncr(key, member, delta, ZAddOptions{})
+	return score, err
+}
+
+func (s *CacheService) ZRem(key string, members []string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.ZRem(key, members)
+}
+
+func (s *CacheService) ZScore(key, member string) (float64, bool, error) {
+	if key == "" {
+		return 0, false, ErrKeyEmpty
+	}
+
+	return s.storage.ZScore(key, member)
+}
+
+func (s *CacheService) ZCard(key string) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	return s.storage.ZCard(key)
+}
+
+// ZRank returns the 0-based rank of member, from the highest score when
+// rev.
+func (s *CacheService) ZRank(key, member string, rev bool) (int, bool, error) {
+	if key == "" {
+		return 0, false, ErrKeyEmpty
+	}
+
+	return s.storage.ZRank(key, member, rev)
+}
+
+func (s *CacheService) ZRange(key string, spec ZRangeSpec) ([]ZMember, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	return s.storage.ZRange(key, spec)
+}
+
+// ZRangeStore stores the result of ZRange on src under dst and returns its
+// size.
+func (s *CacheService) ZRangeStore(dst, src string, spec ZRangeSpec) (int, error) {
+	if dst == "" || src == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.ZRangeStore(dst, src, spec)
+}
+
+func (s *CacheService) ZCount(key string, r ScoreRange) (int, error) {
+	if key == "" {
+		return 0, ErrKeyEmpty
+	}
+
+	return s.storage.ZCount(key, r)
+}
+
+// ZPop removes and returns up to count members with the lowest scores, or
+// the highest when max.
+func (s *CacheService) ZPop(key string, max bool, count int) ([]ZMember, error) {
+	if key == "" {
+		return nil, ErrKeyEmpty
+	}
+
+	if s.readOnly.Load() {
+		return nil, ErrReadOnly
+	}
+
+	return s.storage.ZPop(key, max, count)
+}
+
+// BZPop is ZPop of a single member from the first non-empty sorted set of
+// keys, waiting for one like BLPop.
+func (s *CacheService) BZPop(ctx context.Context, keys []string, max bool, timeout time.Duration) (string, ZMember, bool, error) {
+	for _, key := range keys {
+		if key == "" {
+			return "", ZMember{}, false, ErrKeyEmpty
+		}
+	}
+
+	if s.readOnly.Load() {
+		return "", ZMember{}, false, ErrReadOnly
+	}
+
+	return s.storage.BlockingZPop(ctx, keys, max, timeout)
+}
+
+// ZSetOpStore stores the union or intersection of the sorted sets under
+// keys in dst and returns its size. Weights, nil for all 1, scale the
+// scores of each input, and agg combines the scores of common members.
+func (s *CacheService) ZSetOpStore(op SetOp, dst string, keys []string, weights []float64, agg ZAggregate) (int, error) {
+	if dst == "" {
+		return 0, ErrKeyEmpty
+	}
+	for _, key := range keys {
+		if key == "" {
+			return 0, ErrKeyEmpty
+		}
+	}
+
+	if s.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+
+	return s.storage.ZSetOpStore(op, dst, keys, weights, agg)
+}
@@ -1,14 +1,28 @@
 package internal
 
 import (
-	"fmt"
-	"time"
 	"context"
+	"sync/atomic"
+	"time"
 )
 
 type CleanupWorker struct {
 	cfg     *Config
 	storage *Storage
+
+	cycles       atomic.Int64
+	timedOut     atomic.Int64
+	expiredKeys  atomic.Int64
+	sampledKeys  atomic.Int64
+	lastDuration atomic.Int64
+}
+
+type ExpireStats struct {
+	Cycles            int64
+	TimedOutCycles    int64
+	ExpiredKeys       int64
+	SampledKeys       int64
+	LastCycleDuration time.Duration
 }
 
 func NewCleanupWorker(cfg *Config, storage *Storage) *CleanupWorker {
@@ -25,12 +39,31 @@ func (w *CleanupWorker) Run(ctx context.Context) {
 	for {
 		select {
 		case <-ticker.C:
-			count := w.storage.CleanupExired()
-			if count > 0 {
-				fmt.Printf("Cleaned %d expired keys\n", count)
-			}
+			stats := w.storage.ActiveExpireCycle(w.cfg.ExpireSamples, w.cfg.ExpireCycleBudget)
+			w.record(stats)
 		case <-ctx.Done():
 			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+func (w *CleanupWorker) record(stats ExpireCycleStats) {
+	w.cycles.Add(1)
+	w.expiredKeys.Add(int64(stats.Expired))
+	w.sampledKeys.Add(int64(stats.Sampled))
+	w.lastDuration.Store(int64(stats.Duration))
+	if stats.TimedOut {
+		w.timedOut.Add(1)
+	}
+}
+
+// Stats returns the totals accumulated over all expiration cycles.
+func (w *CleanupWorker) Stats() ExpireStats {
+	return ExpireStats{
+		Cycles:            w.cycles.Load(),
+		TimedOutCycles:    w.timedOut.Load(),
+		ExpiredKeys:       w.expiredKeys.Load(),
+		SampledKeys:       w.sampledKeys.Load(),
+		LastCycleDuration: time.Duration(w.lastDuration.Load()),
+	}
+}
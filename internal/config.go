@@ -3,6 +3,7 @@ package internal
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,14 +12,74 @@ type Config struct {
 	Host            string
 	CleanupInterval time.Duration
 	DefaultTTL      time.Duration
+	ShardCount      int
+	MaxMemory       int64
+	EvictionPolicy  EvictionPolicy
+
+	// ExpireSamples is how many volatile keys each shard is sampled with
+	// per expiration round, and ExpireCycleBudget caps one cycle's runtime.
+	ExpireSamples     int
+	ExpireCycleBudget time.Duration
+
+	Dir        string
+	DbFilename string
+	SaveRules  []SaveRule
+
+	// FunctionsFilename is the file in Dir keeping the libraries loaded
+	// with FUNCTION LOAD.
+	FunctionsFilename string
+
+	AppendOnly            bool
+	AppendFilename        string
+	AppendFsync           FsyncPolicy
+	AutoRewritePercentage int
+	AutoRewriteMinSize    int64
+
+	// ReplicaOf holds "host port" of the primary to replicate from at
+	// startup, empty for a primary.
+	ReplicaOf       string
+	ReplBacklogSize int
+
+	// PubSubBuffer is how many messages may be queued for a subscriber
+	// before it is disconnected as too slow.
+	PubSubBuffer int
+
+	// NotifyKeyspaceEvents selects the keyspace notifications published,
+	// none by default.
+	NotifyKeyspaceEvents NotifyFlags
+
+	// LuaTimeLimit is how long a script may run before other clients are
+	// answered BUSY and it can be stopped with SCRIPT KILL.
+	LuaTimeLimit time.Duration
 }
 
 func LoadConfig() *Config {
 	cfg := &Config{
-		Port:            6379,
-		Host:            "0.0.0.0",
-		CleanupInterval: 60 * time.Second,
-		DefaultTTL:      5 * time.Minute,
+		Port:              6379,
+		Host:              "0.0.0.0",
+		CleanupInterval:   60 * time.Second,
+		DefaultTTL:        5 * time.Minute,
+		ExpireSamples:     20,
+		ExpireCycleBudget: 25 * time.Millisecond,
+		Dir:               ".",
+		DbFilename:        "dump.cago",
+		FunctionsFilename: "functions.cago",
+		SaveRules: []SaveRule{
+			{Interval: time.Hour, Changes: 1},
+			{Interval: 5 * time.Minute, Changes: 100},
+			{Interval: time.Minute, Changes: 10000},
+		},
+		AppendOnly:            false,
+		AppendFilename:        "appendonly.aof",
+		AppendFsync:           FsyncEverySec,
+		AutoRewritePercentage: 100,
+		AutoRewriteMinSize:    64 << 20,
+		ReplBacklogSize:       1 << 20,
+		PubSubBuffer:          4096,
+		LuaTimeLimit:          5 * time.Second,
+		ShardCount:            32,
+		MaxMemory:             0,
+		EvictionPolicy:        NoEviction,
 	}
 
 	if port := os.Getenv("CAGO_Port"); port != "" {
@@ -37,11 +98,182 @@ func LoadConfig() *Config {
 		}
 	}
 
+	if samples := os.Getenv("CAGO_ExpireSamples"); samples != "" {
+		if samplesInt, err := strconv.Atoi(samples); err == nil && samplesInt > 0 {
+			cfg.ExpireSamples = samplesInt
+		}
+	}
+
+	if budget := os.Getenv("CAGO_ExpireCycleBudgetMs"); budget != "" {
+		if budgetInt, err := strconv.Atoi(budget); err == nil && budgetInt > 0 {
+			cfg.ExpireCycleBudget = time.Duration(budgetInt) * time.Millisecond
+		}
+	}
+
 	if ttl := os.Getenv("CAGO_DefaultTTL"); ttl != "" {
 		if ttlInt, err := strconv.Atoi(ttl); err == nil {
 			cfg.DefaultTTL = time.Duration(ttlInt) * time.Second
 		}
 	}
 
+	if shards := os.Getenv("CAGO_ShardCount"); shards != "" {
+		if shardsInt, err := strconv.Atoi(shards); err == nil && shardsInt > 0 {
+			cfg.ShardCount = shardsInt
+		}
+	}
+
+	if maxMemory := os.Getenv("CAGO_MaxMemory"); maxMemory != "" {
+		if bytes, ok := parseMemory(maxMemory); ok {
+			cfg.MaxMemory = bytes
+		}
+	}
+
+	if policy := os.Getenv("CAGO_EvictionPolicy"); policy != "" {
+		if parsed, ok := ParseEvictionPolicy(strings.ToLower(policy)); ok {
+			cfg.EvictionPolicy = parsed
+		}
+	}
+
+	if dir := os.Getenv("CAGO_Dir"); dir != "" {
+		cfg.Dir = dir
+	}
+
+	if filename := os.Getenv("CAGO_DbFilename"); filename != "" {
+		cfg.DbFilename = filename
+	}
+
+	if filename := os.Getenv("CAGO_FunctionsFilename"); filename != "" {
+		cfg.FunctionsFilename = filename
+	}
+
+	// An empty CAGO_Save disables automatic snapshots.
+	if save, ok := os.LookupEnv("CAGO_Save"); ok {
+		if rules, ok := parseSaveRules(save); ok {
+			cfg.SaveRules = rules
+		}
+	}
+
+	if appendOnly := os.Getenv("CAGO_AppendOnly"); appendOnly != "" {
+		cfg.AppendOnly = parseBool(appendOnly)
+	}
+
+	if filename := os.Getenv("CAGO_AppendFilename"); filename != "" {
+		cfg.AppendFilename = filename
+	}
+
+	if fsync := os.Getenv("CAGO_AppendFsync"); fsync != "" {
+		if parsed, ok := ParseFsyncPolicy(strings.ToLower(fsync)); ok {
+			cfg.AppendFsync = parsed
+		}
+	}
+
+	if percentage := os.Getenv("CAGO_AutoAofRewritePercentage"); percentage != "" {
+		if percentageInt, err := strconv.Atoi(percentage); err == nil && percentageInt >= 0 {
+			cfg.AutoRewritePercentage = percentageInt
+		}
+	}
+
+	if minSize := os.Getenv("CAGO_AutoAofRewriteMinSize"); minSize != "" {
+		if bytes, ok := parseMemory(minSize); ok {
+			cfg.AutoRewriteMinSize = bytes
+		}
+	}
+
+	if replicaOf := os.Getenv("CAGO_ReplicaOf"); replicaOf != "" {
+		cfg.ReplicaOf = replicaOf
+	}
+
+	if backlog := os.Getenv("CAGO_ReplBacklogSize"); backlog != "" {
+		if bytes, ok := parseMemory(backlog); ok && bytes > 0 {
+			cfg.ReplBacklogSize = int(bytes)
+		}
+	}
+
+	if buffer := os.Getenv("CAGO_PubSubBuffer"); buffer != "" {
+		if bufferInt, err := strconv.Atoi(buffer); err == nil && bufferInt > 0 {
+			cfg.PubSubBuffer = bufferInt
+		}
+	}
+
+	if events := os.Getenv("CAGO_NotifyKeyspaceEvents"); events != "" {
+		if flags, err := ParseNotifyFlags(events); err == nil {
+			cfg.NotifyKeyspaceEvents = flags
+		}
+	}
+
+	if limit := os.Getenv("CAGO_LuaTimeLimitMs"); limit != "" {
+		if limitInt, err := strconv.Atoi(limit); err == nil && limitInt > 0 {
+			cfg.LuaTimeLimit = time.Duration(limitInt) * time.Millisecond
+		}
+	}
+
 	return cfg
 }
+
+// parseBool accepts the yes/no spelling used by Redis configs as well as
+// the forms understood by strconv.ParseBool.
+func parseBool(val string) bool {
+	switch strings.ToLower(val) {
+	case "yes", "on":
+		return true
+	}
+	b, _ := strconv.ParseBool(val)
+	return b
+}
+
+// parseSaveRules reads "seconds changes" pairs, e.g. "3600 1 300 100".
+func parseSaveRules(val string) ([]SaveRule, bool) {
+	fields := strings.Fields(val)
+	if len(fields)%2 != 0 {
+		return nil, false
+	}
+
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds <= 0 {
+			return nil, false
+		}
+
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, false
+		}
+
+		rules = append(rules, SaveRule{
+			Interval: time.Duration(seconds) * time.Second,
+			Changes:  changes,
+		})
+	}
+
+	return rules, true
+}
+
+// parseMemory accepts a byte count with an optional kb/mb/gb suffix,
+// e.g. "1048576", "512mb" or "2gb".
+func parseMemory(val string) (int64, bool) {
+	val = strings.ToLower(strings.TrimSpace(val))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"gb", 1 << 30},
+		{"mb", 1 << 20},
+		{"kb", 1 << 10},
+		{"b", 1},
+	} {
+		if strings.HasSuffix(val, unit.suffix) {
+			val = strings.TrimSuffix(val, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n * multiplier, true
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+type EvictionPolicy string
+
+const (
+	NoEviction    EvictionPolicy = "noeviction"
+	AllKeysLRU    EvictionPolicy = "allkeys-lru"
+	AllKeysLFU    EvictionPolicy = "allkeys-lfu"
+	AllKeysRandom EvictionPolicy = "allkeys-random"
+	VolatileLRU   EvictionPolicy = "volatile-lru"
+	VolatileTTL   EvictionPolicy = "volatile-ttl"
+)
+
+const (
+	// evictionSamples is how many keys are inspected per shard when
+	// looking for an eviction candidate, mirroring maxmemory-samples.
+	evictionSamples = 5
+	// evictionShards is how many shards holding a candidate are compared
+	// before one is evicted.
+	evictionShards = 4
+
+	lfuInitValue   = 5
+	lfuLogFactor   = 10
+	lfuDecayPeriod = time.Minute
+)
+
+func ParseEvictionPolicy(name string) (EvictionPolicy, bool) {
+	switch policy := EvictionPolicy(name); policy {
+	case NoEviction, AllKeysLRU, AllKeysLFU, AllKeysRandom, VolatileLRU, VolatileTTL:
+		return policy, true
+	}
+	return "", false
+}
+
+// touch records an access for the LRU clock and the LFU counter. It is safe
+// to call while holding only the shard read lock.
+func (item *StorageItem) touch(now *time.Time) {
+	prev := item.lastAccess.Swap(now.UnixNano())
+
+	counter := lfuDecay(item.lfuCounter.Load(), now.UnixNano()-prev)
+	item.lfuCounter.Store(lfuLogIncr(counter))
+}
+
+// lfuLogIncr increments the counter with a probability that shrinks as the
+// counter grows, so 8 bits are enough to tell hot keys from cold ones.
+func lfuLogIncr(counter uint32) uint32 {
+	if counter >= 255 {
+		return 255
+	}
+
+	base := float64(counter) - lfuInitValue
+	if base < 0 {
+		base = 0
+	}
+
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecay takes one point off the counter per idle decay period.
+func lfuDecay(counter uint32, idle int64) uint32 {
+	periods := idle / int64(lfuDecayPeriod)
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint32(periods)
+}
+
+// reserve makes room for a write of size bytes to key, evicting other keys
+// according to the configured policy. It must be called without any shard
+// lock held.
+func (s *Storage) reserve(key string, size int64) error {
+	if s.maxMemory <= 0 {
+		return nil
+	}
+
+	for s.usedMemory.Load()+size > s.maxMemory {
+		if s.policy == NoEviction || s.policy == "" {
+			return ErrOutOfMemory
+		}
+
+		if !s.evictOne(key) {
+			return ErrOutOfMemory
+		}
+	}
+
+	return nil
+}
+
+// evictOne samples keys from evictionShards shards, starting at a random
+// one, and removes the best candidate for the policy. Shards without a
+// candidate are skipped, but every key inspected counts toward the samples
+// of its shard, so finding a victim never walks a whole shard. Shards held
+// by a transaction or script are skipped. It reports false when no key is
+// eligible for eviction.
+func (s *Storage) evictOne(skip string) bool {
+	now := utcNow()
+	start := rand.IntN(len(s.shards))
+	volatile := s.policy == VolatileLRU || s.policy == VolatileTTL
+
+	var (
+		bestKey   string
+		bestShard *storageShard
+		bestScore = math.Inf(-1)
+		compared  = 0
+	)
+
+	for i := 0; i < len(s.shards) && compared < evictionShards; i++ {
+		sh := s.shards[(start+i)%len(s.shards)]
+
+		// Keys a transaction or script has locked are left alone; the gate
+		// of the best shard so far stays held until its key is removed.
+		if !sh.gate.TryRLock() {
+			continue
+		}
+		prevShard := bestShard
+
+		sh.mu.RLock()
+		found := false
+		sample := func(key string, item *StorageItem) {
+			if key == skip || item == nil {
+				return
+			}
+			if score, ok := s.evictionScore(item, now); ok {
+				found = true
+				if score > bestScore {
+					bestKey, bestShard, bestScore = key, sh, score
+				}
+			}
+		}
+
+		// The volatile policies only look at keys with a TTL, which the
+		// expires index holds without the others.
+		sampled := 0
+		if volatile {
+			for key := range sh.expires {
+				if sampled >= evictionSamples {
+					break
+				}
+				sampled++
+				sample(key, sh.data[key])
+			}
+		} else {
+			for key, item := range sh.data {
+				if sampled >= evictionSamples {
+					break
+				}
+				sampled++
+				sample(key, item)
+			}
+		}
+		sh.mu.RUnlock()
+
+		if bestShard != prevShard && prevShard != nil {
+			prevShard.gate.RUnlock()
+		}
+		if bestShard != sh {
+			sh.gate.RUnlock()
+		}
+
+		if found {
+			compared++
+		}
+
+		// Random eviction does not benefit from comparing shards.
+		if s.policy == AllKeysRandom && bestShard != nil {
+			break
+		}
+	}
+
+	if bestShard == nil {
+		return false
+	}
+	defer bestShard.gate.RUnlock()
+
+	bestShard.lock()
+	if s.removeLocked(bestShard, bestKey) {
+		s.evictedKeys.Add(1)
+		s.propagate(command("DEL", bestKey))
+		s.notify(NotifyEvicted, "evicted", bestKey)
+	}
+	bestShard.mu.Unlock()
+	return true
+}
+
+// evictionScore rates item for eviction, higher meaning a better candidate.
+// The second result is false when the policy does not allow evicting item.
+func (s *Storage) evictionScore(item *StorageItem, now *time.Time) (float64, bool) {
+	volatile := !item.ExpiresAt.IsZero()
+
+	switch s.policy {
+	case AllKeysLRU:
+		return float64(now.UnixNano() - item.lastAccess.Load()), true
+	case VolatileLRU:
+		return float64(now.UnixNano() - item.lastAccess.Load()), volatile
+	case AllKeysLFU:
+		idle := now.UnixNano() - item.lastAccess.Load()
+		return 255 - float64(lfuDecay(item.lfuCounter.Load(), idle)), true
+	case VolatileTTL:
+		return float64(-item.ExpiresAt.Sub(*now)), volatile
+	case AllKeysRandom:
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"time"
+)
+
+// expireAcceptableStale is the share of expired keys in a sample above which
+// a shard is sampled again in the same cycle.
+const expireAcceptableStale = 0.25
+
+type ExpireCycleStats struct {
+	Sampled  int
+	Expired  int
+	Duration time.Duration
+	// TimedOut is set when the cycle stopped because it ran out of budget
+	// before visiting every shard.
+	TimedOut bool
+}
+
+// ActiveExpireCycle samples volatile keys shard by shard and removes the
+// expired ones. A shard is sampled again while more than a quarter of its
+// sample was expired, and the whole cycle stops once budget is spent. The
+// next cycle resumes from the shard where this one stopped, so a slow cycle
+// does not starve the shards at the end. Shards a transaction holds are
+// skipped, so keys do not expire in the middle of one. Only one goroutine
+// may run cycles.
+func (s *Storage) ActiveExpireCycle(samples int, budget time.Duration) ExpireCycleStats {
+	start := time.Now()
+	deadline := start.Add(budget)
+
+	var stats ExpireCycleStats
+	for range s.shards {
+		sh := s.shards[s.expireCursor]
+		s.expireCursor = (s.expireCursor + 1) % len(s.shards)
+
+		for {
+			sampled, expired := s.expireShard(sh, samples)
+			stats.Sampled += sampled
+			stats.Expired += expired
+
+			if sampled == 0 || float64(expired) <= float64(sampled)*expireAcceptableStale {
+				break
+			}
+			if time.Now().After(deadline) {
+				break
+			}
+		}
+
+		if time.Now().After(deadline) {
+			stats.TimedOut = true
+			break
+		}
+	}
+
+	stats.Duration = time.Since(start)
+	return stats
+}
+
+// expireShard checks up to samples volatile keys of sh. Map iteration order
+// is randomized, which makes the sample random without extra bookkeeping.
+func (s *Storage) expireShard(sh *storageShard, samples int) (sampled, expired int) {
+	if !sh.gate.TryRLock() {
+		return 0, 0
+	}
+	defer sh.gate.RUnlock()
+
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	for key := range sh.expires {
+		if sampled >= samples {
+			break
+		}
+		sampled++
+
+		if item := sh.data[key]; checkIfExpired(&item.ExpiresAt, now) {
+			s.removeExpiredLocked(sh, key)
+			expired++
+		}
+	}
+
+	return sampled, expired
+}
+
+// removeExpired removes key when it has expired, for readers that came
+// across it while holding sh.mu for reading only.
+func (s *Storage) removeExpired(sh *storageShard, key string) {
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	if item, exists := sh.data[key]; exists && checkIfExpired(&item.ExpiresAt, utcNow()) {
+		s.removeExpiredLocked(sh, key)
+	}
+}
+
+// removeExpiredLocked removes the expired key and publishes its expired
+// event. The caller must hold sh.mu for writing.
+func (s *Storage) removeExpiredLocked(sh *storageShard, key string) {
+	s.removeLocked(sh, key)
+	s.notify(NotifyExpired, "expired", key)
+}
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Function library file and FUNCTION DUMP payload layout:
+//
+//	"CAGOFUNC" | version (1 byte) | uvarint library count | (name | code)... | CRC-64 (8 bytes, LE)
+//
+// Names and code are written like snapshot strings, and the checksum covers
+// everything before it.
+const (
+	functionsMagic   = "CAGOFUNC"
+	functionsVersion = 1
+)
+
+var ErrFunctionsCorrupt = errors.New("function libraries are corrupt")
+
+// FunctionLibrary is the source of a library loaded with FUNCTION LOAD.
+// Only the code is kept, the functions it registers are found by running
+// it again.
+type FunctionLibrary struct {
+	Name string
+	Code string
+}
+
+// EncodeFunctions serializes libs for the function library file and
+// FUNCTION DUMP.
+func EncodeFunctions(libs []FunctionLibrary) []byte {
+	buf := append([]byte(functionsMagic), functionsVersion)
+	buf = appendLibraries(buf, libs)
+	return binary.LittleEndian.AppendUint64(buf, crc64.Checksum(buf, crcTable))
+}
+
+// appendLibraries writes the library count and libs, as in the library
+// file and in snapshots.
+func appendLibraries(buf []byte, libs []FunctionLibrary) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(libs)))
+	for _, lib := range libs {
+		buf = appendBytes(buf, []byte(lib.Name))
+		buf = appendBytes(buf, []byte(lib.Code))
+	}
+	return buf
+}
+
+// libraries reads libraries written by appendLibraries.
+func (d *snapshotDecoder) libraries() []FunctionLibrary {
+	count := d.count()
+	libs := make([]FunctionLibrary, 0, min(count, 1024))
+	for range count {
+		name := d.bytes()
+		code := d.bytes()
+		if d.err != nil {
+			return nil
+		}
+		libs = append(libs, FunctionLibrary{Name: string(name), Code: string(code)})
+	}
+	return libs
+}
+
+// DecodeFunctions reads libraries serialized by EncodeFunctions, checking
+// the version and checksum.
+func DecodeFunctions(data []byte) ([]FunctionLibrary, error) {
+	if len(data) < len(functionsMagic)+1+8 {
+		return nil, fmt.Errorf("%w: truncated", ErrFunctionsCorrupt)
+	}
+
+	body, sum := data[:len(data)-8], data[len(data)-8:]
+	if binary.LittleEndian.Uint64(sum) != crc64.Checksum(body, crcTable) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrFunctionsCorrupt)
+	}
+	if !bytes.HasPrefix(body, []byte(functionsMagic)) {
+		return nil, fmt.Errorf("%w: bad magic", ErrFunctionsCorrupt)
+	}
+	if version := body[len(functionsMagic)]; version != functionsVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrFunctionsCorrupt, version)
+	}
+
+	dec := &snapshotDecoder{r: bufio.NewReader(bytes.NewReader(body[len(functionsMagic)+1:])), hash: crc64.New(crcTable)}
+	libs := dec.libraries()
+	if dec.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFunctionsCorrupt, dec.err)
+	}
+	if _, err := dec.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing data", ErrFunctionsCorrupt)
+	}
+	return libs, nil
+}
+
+// FunctionStore keeps the function libraries in a file of their own next
+// to the dataset, rewritten on every change, so they survive restarts
+// whatever the persistence settings. Snapshots carry them as well, for
+// replicas and for a data directory holding only a snapshot.
+type FunctionStore struct {
+	cfg *Config
+
+	// mu is also held while a change is propagated and while a dump
+	// begins, which lines the libraries up with the command stream.
+	mu   sync.Mutex
+	libs []FunctionLibrary
+	// found is set once the library file was loaded or saved.
+	found bool
+}
+
+func NewFunctionStore(cfg *Config) *FunctionStore {
+	return &FunctionStore{cfg: cfg}
+}
+
+func (fs *FunctionStore) Path() string {
+	return filepath.Join(fs.cfg.Dir, fs.cfg.FunctionsFilename)
+}
+
+// Load reads the function library file. A missing file is not an error.
+func (fs *FunctionStore) Load() (int, error) {
+	data, err := os.ReadFile(fs.Path())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	libs, err := DecodeFunctions(data)
+	if err != nil {
+		return 0, err
+	}
+
+	fs.mu.Lock()
+	fs.libs = libs
+	fs.found = true
+	fs.mu.Unlock()
+	return len(libs), nil
+}
+
+// fallback takes libs, read from the snapshot loaded at startup, unless
+// the library file was loaded: it is saved on every change, so it is never
+// older than a snapshot.
+func (fs *FunctionStore) fallback(libs []FunctionLibrary) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.found {
+		return nil
+	}
+	return fs.saveLocked(libs)
+}
+
+// Libraries returns a copy of the libraries last loaded or saved.
+func (fs *FunctionStore) Libraries() []FunctionLibrary {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return slices.Clone(fs.libs)
+}
+
+// Save replaces the libraries on disk with libs. Like snapshots, they are
+// written to a temporary file renamed over the previous one, so a crash
+// leaves either the old or the new set.
+func (fs *FunctionStore) Save(libs []FunctionLibrary) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.saveLocked(libs)
+}
+
+func (fs *FunctionStore) saveLocked(libs []FunctionLibrary) error {
+	tmp, err := os.CreateTemp(fs.cfg.Dir, "temp-*.func")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(EncodeFunctions(libs)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), fs.Path()); err != nil {
+		return err
+	}
+
+	fs.libs = libs
+	fs.found = true
+	return nil
+}
+
+// functionsCommand propagates libs as a whole, which replays the same
+// whatever the libraries were before.
+func functionsCommand(libs []FunctionLibrary) [][]byte {
+	return [][]byte{[]byte("FUNCTION"), []byte("RESTORE"), EncodeFunctions(libs), []byte("FLUSH")}
+}
+
+// saveFunctions stores libs in place of the function libraries and
+// propagates them.
+func (s *Storage) saveFunctions(libs []FunctionLibrary) error {
+	fs := s.functions
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.saveLocked(libs); err != nil {
+		return err
+	}
+	s.propagate(functionsCommand(libs))
+	return nil
+}
@@ -0,0 +1,113 @@
+package internal
+
+// matchPattern reports whether key matches the Redis-style glob pattern,
+// byte by byte. A * matches any run of bytes, a ? any single byte, [abc]
+// one of the listed bytes, [a-z] one in the range and [^abc] any byte but
+// the listed ones. A \ escapes the byte after it, so \* matches a literal
+// star.
+//
+// Like Redis, an unclosed [ class runs to the end of the pattern and a
+// trailing \ matches itself. A * is retried from the last one seen only,
+// so matching takes at most len(pattern)*len(key) steps.
+func matchPattern(pattern, key string) bool {
+	p, k := 0, 0
+	starP, starK := -1, 0
+
+	for k < len(key) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starK = p, k
+				continue
+			case '?':
+				p++
+				k++
+				continue
+			case '[':
+				if ok, next := matchClass(pattern, p+1, key[k]); ok {
+					p = next
+					k++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == key[k] {
+						p += 2
+						k++
+						continue
+					}
+				} else if key[k] == '\\' {
+					p++
+					k++
+					continue
+				}
+			default:
+				if pattern[p] == key[k] {
+					p++
+					k++
+					continue
+				}
+			}
+		}
+
+		// Mismatch: let the last * swallow one more byte and retry.
+		if starP < 0 {
+			return false
+		}
+		starK++
+		p, k = starP, starK
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class starting at pattern[p], just after
+// its [. It returns whether c matched and the index following the class.
+func matchClass(pattern string, p int, c byte) (bool, int) {
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	matched := false
+	for ; p < len(pattern); p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+		case pattern[p] == ']':
+			return matched != negate, p + 1
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+		}
+	}
+	return matched != negate, p
+}
+
+// MatchPattern reports whether s matches the Redis-style glob pattern, the
+// way KEYS and PSUBSCRIBE match them.
+func MatchPattern(pattern, s string) bool {
+	return matchPattern(pattern, s)
+}
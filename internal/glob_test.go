@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		// Literals and ?.
+		{"", "", true},
+		{"", "a", false},
+		{"hello", "hello", true},
+		{"hello", "hell", false},
+		{"hello", "helloo", false},
+		{"h?llo", "hallo", true},
+		{"h?llo", "hllo", false},
+		{"???", "abc", true},
+		{"???", "ab", false},
+
+		// Stars.
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h*", "h", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"*llo", "hello", true},
+		{"**a**", "bab", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXcYb", false},
+
+		// Backtracking: the star has to give up bytes it matched.
+		{"*ab", "aab", true},
+		{"*abc", "ababc", true},
+		{"a*a*a", "aaa", true},
+		{"a*a*a", "aa", false},
+		{"*a*b", "xaxaxbxb", true},
+		{"*.txt", "a.txt.txt", true},
+		{"*.txt", "a.txt.md", false},
+		{"user:*:name", "user:1:x:name", true},
+
+		// Classes.
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hbllo", true},
+		{"[0-9][0-9]", "42", true},
+		{"[0-9][0-9]", "4x", false},
+		{"[a-cx-z]", "y", true},
+		{"[a-cx-z]", "m", false},
+		{"[]", "a", false},
+
+		// Negation.
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"[^a-c]", "d", true},
+		{"[^a-c]", "b", false},
+		{"[^]", "a", true},
+
+		// Escapes.
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\?c`, "a?c", true},
+		{`a\?c`, "abc", false},
+		{`\[a]`, "[a]", true},
+		{`\\`, `\`, true},
+		{`a\`, `a\`, true},
+		{`a\`, "a", false},
+		{`[\]]`, "]", true},
+		{`[\-]`, "-", true},
+		{`[\-]`, "a", false},
+		{`*\*`, "ab*", true},
+		{`*\*`, "ab", false},
+
+		// An unclosed class runs to the end of the pattern.
+		{"h[ae", "ha", true},
+		{"h[ae", "hb", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchPattern(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("MatchPattern(%q, %q) = %t, want %t", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestMatchPatternStars checks that patterns with many stars, which would
+// backtrack exponentially if every star were retried, still finish.
+func TestMatchPatternStars(t *testing.T) {
+	pattern := strings.Repeat("a*", 30) + "b"
+	key := strings.Repeat("a", 1000)
+
+	if MatchPattern(pattern, key) {
+		t.Errorf("MatchPattern(%q, %q) = true, want false", pattern, key)
+	}
+	if !MatchPattern(pattern, key+"b") {
+		t.Errorf("MatchPattern(%q, %q) = false, want true", pattern, key+"b")
+	}
+}
@@ -0,0 +1,239 @@
+package http_s
+
+import (
+	"cago/internal"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GET /v1/keys/{key}/fields
+// GET /v1/keys/{key}/fields?cursor=0&count=100&pattern=a* pages through
+// large hashes, the response carries the next_cursor, "0" once done.
+func (s *HttpServer) handleHGetAll(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	query := r.URL.Query()
+
+	var fields []internal.HashField
+	var nextCursor string
+	var err error
+
+	if query.Has("cursor") {
+		cursor, parseErr := strconv.ParseUint(query.Get("cursor"), 10, 64)
+		if parseErr != nil {
+			s.errorResponse(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+
+		count := 10
+		if countParam := query.Get("count"); countParam != "" {
+			count, parseErr = strconv.Atoi(countParam)
+			if parseErr != nil || count < 1 {
+				s.errorResponse(w, "invalid count", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var next uint64
+		unlock := s.cachesrv.Shared(key)
+		fields, next, err = s.cachesrv.HScan(key, cursor, query.Get("pattern"), count)
+		unlock()
+		nextCursor = strconv.FormatUint(next, 10)
+	} else {
+		unlock := s.cachesrv.Shared(key)
+		fields, err = s.cachesrv.HGetAll(key)
+		unlock()
+	}
+
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if fields == nil && nextCursor == "" {
+		s.errorResponse(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	response := HashResponse{
+		Key:        key,
+		Fields:     make(map[string]string, len(fields)),
+		Count:      len(fields),
+		NextCursor: nextCursor,
+	}
+	for _, f := range fields {
+		response.Fields[f.Field] = string(f.Value)
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// PUT /v1/keys/{key}/fields
+// {"fields": {"name": "bob", "age": "42"}}
+func (s *HttpServer) handleHSet(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var req HashSetRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+
+	fields := make([]internal.HashField, 0, len(req.Fields))
+	for field, value := range req.Fields {
+		fields = append(fields, internal.HashField{Field: field, Value: []byte(value)})
+	}
+
+	unlock := s.cachesrv.Shared(key)
+	added, err := s.cachesrv.HSet(key, fields)
+	unlock()
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	response := HashSetResponse{
+		Key:     key,
+		Added:   added,
+		Success: true,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// GET /v1/keys/{key}/fields/{field}
+func (s *HttpServer) handleHGet(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	field := chi.URLParam(r, "field")
+
+	unlock := s.cachesrv.Shared(key)
+	value, exists, err := s.cachesrv.HGet(key, field)
+	unlock()
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if !exists {
+		s.errorResponse(w, "field not found", http.StatusNotFound)
+		return
+	}
+
+	response := HashFieldResponse{
+		Key:   key,
+		Field: field,
+		Value: string(value),
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// PUT /v1/keys/{key}/fields/{field}
+// {"value": "bob"}
+func (s *HttpServer) handleHSetField(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	field := chi.URLParam(r, "field")
+
+	var req HashFieldRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.errorResponse(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+
+	unlock := s.cachesrv.Shared(key)
+	added, err := s.cachesrv.HSet(key, []internal.HashField{{Field: field, Value: []byte(req.Value)}})
+	unlock()
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	response := HashSetResponse{
+		Key:     key,
+		Added:   added,
+		Success: true,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// DELETE /v1/keys/{key}/fields/{field}
+func (s *HttpServer) handleHDel(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	field := chi.URLParam(r, "field")
+
+	unlock := s.cachesrv.Shared(key)
+	removed, err := s.cachesrv.HDel(key, []string{field})
+	unlock()
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if removed == 0 {
+		s.errorResponse(w, "field not found", http.StatusNotFound)
+		return
+	}
+
+	response := HashFieldDeleteResponse{
+		Key:     key,
+		Field:   field,
+		Deleted: true,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// POST /v1/keys/{key}/fields/{field}/incr
+// {"increment": 5} increments an integer field, {"increment": 0.5} a float
+func (s *HttpServer) handleHIncr(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	field := chi.URLParam(r, "field")
+
+	var req HashIncrRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil || req.Increment == "" {
+		s.errorResponse(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+
+	unlock := s.cachesrv.Shared(key)
+	defer unlock()
+
+	var value json.Number
+	if delta, err := req.Increment.Int64(); err == nil {
+		result, err := s.cachesrv.HIncrBy(key, field, delta)
+		if err != nil {
+			s.errorResponse(w, err.Error(), errorStatus(err))
+			return
+		}
+		value = json.Number(strconv.FormatInt(result, 10))
+	} else {
+		delta, err := req.Increment.Float64()
+		if err != nil {
+			s.errorResponse(w, "invalid increment", http.StatusBadRequest)
+			return
+		}
+
+		result, err := s.cachesrv.HIncrByFloat(key, field, delta)
+		if err != nil {
+			s.errorResponse(w, err.Error(), errorStatus(err))
+			return
+		}
+		value = json.Number(result)
+	}
+
+	response := HashIncrResponse{
+		Key:   key,
+		Field: field,
+		Value: value,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
@@ -1,5 +1,7 @@
 package http_s
 
+import "encoding/json"
+
 type HealthResponse struct {
 	Status string `json:"status"`
 	Server string `json:"server"`
@@ -11,9 +13,10 @@ type ErrorResponse struct {
 }
 
 type KeysListResponse struct {
-	Keys    []string `json:"keys"`
-	Count   int      `json:"count"`
-	Pattern string   `json:"pattern"`
+	Keys       []string `json:"keys"`
+	Count      int      `json:"count"`
+	Pattern    string   `json:"pattern"`
+	NextCursor string   `json:"next_cursor,omitempty"`
 }
 
 type GetResponse struct {
@@ -23,15 +26,22 @@ type GetResponse struct {
 }
 
 type SetRequest struct {
-	Value string `jsoin:"value"`
-	TTL   int64  `json:"ttl,omitempty"`
+	Value     string `json:"value"`
+	TTL       int64  `json:"ttl,omitempty"`
+	TTLMs     int64  `json:"ttl_ms,omitempty"`
+	IfAbsent  bool   `json:"if_absent,omitempty"`
+	IfPresent bool   `json:"if_present,omitempty"`
+	KeepTTL   bool   `json:"keep_ttl,omitempty"`
 }
 
 type SetResponse struct {
-	Key     string `json:"key"`
-	Value   string `json:"value"`
-	TTL     int64  `json:"ttl"`
-	Success bool   `json:"success"`
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+	TTL         int64  `json:"ttl"`
+	TTLMs       int64  `json:"ttl_ms,omitempty"`
+	Success     bool   `json:"success"`
 }
 
 type DeleteResponse struct {
@@ -39,18 +49,115 @@ type DeleteResponse struct {
 	Deleted bool   `json:"deleted"`
 }
 
+// ExpireRequest holds the TTL as pointers, so a missing TTL can be told
+// apart from a TTL of 0, which deletes the key.
 type ExpireRequest struct {
-	TTL int64 `json:"ttl"`
+	TTL       *int64 `json:"ttl,omitempty"`
+	TTLMs     *int64 `json:"ttl_ms,omitempty"`
+	Condition string `json:"condition,omitempty"`
 }
 
 type ExpireResponse struct {
 	Key     string `json:"key"`
 	TTL     int64  `json:"ttl"`
+	TTLMs   int64  `json:"ttl_ms,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
 	Success bool   `json:"success"`
 }
 
+type IncrRequest struct {
+	Increment json.Number `json:"increment"`
+}
+
+type IncrResponse struct {
+	Key   string      `json:"key"`
+	Value json.Number `json:"value"`
+}
+
 type StatsResponse struct {
 	TotalKeys       int     `json:"total_keys"`
 	DefaultTTL      float64 `json:"default_ttl_seconds"`
 	CleanupInterval float64 `json:"cleanup_interval_seconds"`
+	UsedMemory      int64   `json:"used_memory_bytes"`
+	PeakMemory      int64   `json:"peak_memory_bytes"`
+	DatasetMemory   int64   `json:"dataset_memory_bytes"`
+	OverheadMemory  int64   `json:"overhead_memory_bytes"`
+	MaxMemory       int64   `json:"max_memory_bytes"`
+	EvictionPolicy  string  `json:"eviction_policy"`
+	EvictedKeys     int64   `json:"evicted_keys"`
+}
+
+type SaveResponse struct {
+	Background bool  `json:"background"`
+	LastSave   int64 `json:"last_save"`
+	Success    bool  `json:"success"`
+}
+
+type HashResponse struct {
+	Key        string            `json:"key"`
+	Fields     map[string]string `json:"fields"`
+	Count      int               `json:"count"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+type HashSetRequest struct {
+	Fields map[string]string `json:"fields"`
+}
+
+type HashSetResponse struct {
+	Key     string `json:"key"`
+	Added   int    `json:"added"`
+	Success bool   `json:"success"`
+}
+
+type HashFieldRequest struct {
+	Value string `json:"value"`
+}
+
+type HashFieldResponse struct {
+	Key   string `json:"key"`
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type HashFieldDeleteResponse struct {
+	Key     string `json:"key"`
+	Field   string `json:"field"`
+	Deleted bool   `json:"deleted"`
+}
+
+type HashIncrRequest struct {
+	Increment json.Number `json:"increment"`
+}
+
+type HashIncrResponse struct {
+	Key   string      `json:"key"`
+	Field string      `json:"field"`
+	Value json.Number `json:"value"`
+}
+
+type ChannelsResponse struct {
+	Channels []string `json:"channels"`
+	Count    int      `json:"count"`
+	Pattern  string   `json:"pattern,omitempty"`
+}
+
+type PublishRequest struct {
+	Message string `json:"message"`
+}
+
+type PublishResponse struct {
+	Channel   string `json:"channel"`
+	Receivers int    `json:"receivers"`
+}
+
+type StreamMessage struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message"`
+}
+
+type KeyspaceEvent struct {
+	Key   string `json:"key"`
+	Event string `json:"event"`
 }
@@ -0,0 +1,196 @@
+package http_s
+
+import (
+	"cago/internal"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// sseHeartbeat is how often an idle event stream gets a comment, which
+	// keeps proxies from closing it and notices clients that went away.
+	sseHeartbeat = 15 * time.Second
+
+	// sseWriteTimeout bounds each write to an event stream, so a client
+	// that stopped reading cannot hold on to its handler.
+	sseWriteTimeout = 10 * time.Second
+)
+
+// GET /v1/channels
+// GET /v1/channels?pattern=news.* lists the channels with subscribers
+func (s *HttpServer) handleChannelsList(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	channels := s.cachesrv.PubSubChannels(pattern)
+
+	response := ChannelsResponse{
+		Channels: channels,
+		Count:    len(channels),
+		Pattern:  pattern,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// POST /v1/channels/{channel}
+// {"message": "hello"}
+// POST /v1/channels/{channel} with any other Content-Type publishes the
+// raw body
+func (s *HttpServer) handlePublish(w http.ResponseWriter, r *http.Request) {
+	channel := chi.URLParam(r, "channel")
+
+	var message []byte
+	if isRawBody(r) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+		if err != nil {
+			s.errorResponse(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		message = body
+	} else {
+		var req PublishRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.errorResponse(w, "invalid json body", http.StatusBadRequest)
+			return
+		}
+		message = []byte(req.Message)
+	}
+
+	response := PublishResponse{
+		Channel:   channel,
+		Receivers: s.cachesrv.Publish(channel, message),
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// GET /v1/subscribe?channel=news&channel=alerts&pattern=jobs.*
+// streams the messages of the given channels and patterns as Server-Sent
+// Events:
+//
+//	event: message
+//	data: {"channel":"jobs.1","pattern":"jobs.*","message":"done"}
+//
+// A subscriber that falls behind is disconnected rather than slowing down
+// publishers.
+func (s *HttpServer) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	channels := query["channel"]
+	patterns := query["pattern"]
+	if len(channels) == 0 && len(patterns) == 0 {
+		s.errorResponse(w, "channel or pattern required", http.StatusBadRequest)
+		return
+	}
+
+	sub := s.cachesrv.NewSubscriber(s.cfg.PubSubBuffer)
+	defer sub.Close()
+	for _, channel := range channels {
+		sub.Subscribe(channel)
+	}
+	for _, pattern := range patterns {
+		sub.PSubscribe(pattern)
+	}
+
+	s.streamMessages(w, r, sub, func(msg internal.Message) []byte {
+		data, _ := json.Marshal(StreamMessage{
+			Channel: msg.Channel,
+			Pattern: msg.Pattern,
+			Message: string(msg.Payload),
+		})
+		return fmt.Appendf(nil, "event: message\ndata: %s\n\n", data)
+	})
+}
+
+// GET /v1/events?pattern=session:*&event=expired&event=evicted streams the
+// keyspace notifications of the keys matching pattern, all keys by default,
+// as Server-Sent Events:
+//
+//	event: expired
+//	data: {"key":"session:42","event":"expired"}
+//
+// Without event every enabled event is sent. Keyspace notifications must be
+// enabled with K in notify-keyspace-events.
+func (s *HttpServer) handleKeyspaceEvents(w http.ResponseWriter, r *http.Request) {
+	if s.cachesrv.NotifyKeyspaceEvents()&internal.NotifyKeyspace == 0 {
+		s.errorResponse(w, "keyspace notifications are disabled", http.StatusConflict)
+		return
+	}
+
+	query := r.URL.Query()
+	pattern := query.Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	events := make(map[string]bool)
+	for _, event := range query["event"] {
+		events[event] = true
+	}
+
+	sub := s.cachesrv.NewSubscriber(s.cfg.PubSubBuffer)
+	defer sub.Close()
+	sub.PSubscribe(internal.KeyspaceChannelPrefix + pattern)
+
+	s.streamMessages(w, r, sub, func(msg internal.Message) []byte {
+		event := string(msg.Payload)
+		if len(events) > 0 && !events[event] {
+			return nil
+		}
+
+		data, _ := json.Marshal(KeyspaceEvent{
+			Key:   strings.TrimPrefix(msg.Channel, internal.KeyspaceChannelPrefix),
+			Event: event,
+		})
+		return fmt.Appendf(nil, "event: %s\ndata: %s\n\n", event, data)
+	})
+}
+
+// streamMessages writes the messages of sub as Server-Sent Events encoded
+// by encode, skipping those it returns nil for, until the client goes away
+// or sub is dropped for falling behind.
+func (s *HttpServer) streamMessages(w http.ResponseWriter, r *http.Request, sub *internal.Subscriber, encode func(internal.Message) []byte) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var event []byte
+		select {
+		case msg := <-sub.Messages():
+			if event = encode(msg); event == nil {
+				continue
+			}
+		case <-heartbeat.C:
+			event = []byte(": ping\n\n")
+		case <-sub.Done():
+			if sub.Dropped() {
+				fmt.Printf("Disconnecting slow subscriber: %s\n", r.RemoteAddr)
+			}
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+
+		rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if _, err := w.Write(event); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -5,15 +5,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// maxValueSize caps raw request bodies, matching the RESP bulk string limit.
+const maxValueSize = 512 << 20
+
 type HttpServer struct {
 	cfg      *internal.Config
 	cachesrv *internal.CacheService
@@ -37,16 +43,39 @@ func (s *HttpServer) Run() error {
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/health", s.handleHealth)
 		r.Get("/stats", s.handleStats)
+		r.Post("/admin/save", s.handleSave)
 
 		r.Route("/keys", func(r chi.Router) {
+			r.Use(middleware.RequestSize(maxValueSize))
+
 			r.Get("/", s.handleKeysList)
 			r.Route("/{key}", func(r chi.Router) {
 				r.Get("/", s.handleGet)
 				r.Put("/", s.handleSet)
 				r.Delete("/", s.handleDelete)
 				r.Post("/expire", s.handleExpire)
+				r.Delete("/expire", s.handlePersist)
+				r.Post("/incr", s.handleIncr)
+
+				r.Route("/fields", func(r chi.Router) {
+					r.Get("/", s.handleHGetAll)
+					r.Put("/", s.handleHSet)
+					r.Route("/{field}", func(r chi.Router) {
+						r.Get("/", s.handleHGet)
+						r.Put("/", s.handleHSetField)
+						r.Delete("/", s.handleHDel)
+						r.Post("/incr", s.handleHIncr)
+					})
+				})
 			})
 		})
+
+		r.Route("/channels", func(r chi.Router) {
+			r.Get("/", s.handleChannelsList)
+			r.Post("/{channel}", s.handlePublish)
+		})
+		r.Get("/subscribe", s.handleSubscribe)
+		r.Get("/events", s.handleKeyspaceEvents)
 	})
 
 	httpPort := s.cfg.Port + 1000
@@ -67,34 +96,83 @@ func (s *HttpServer) Run() error {
 }
 
 // GET /v1/keys?pattern=user:*
+// GET /v1/keys?cursor=0&limit=100&pattern=user:*&type=hash pages through large
+// keyspaces, the response carries the next_cursor, "0" once done.
 func (s *HttpServer) handleKeysList(w http.ResponseWriter, r *http.Request) {
-	pattern := r.URL.Query().Get("pattern")
+	query := r.URL.Query()
+	pattern := query.Get("pattern")
 	if pattern == "" {
 		pattern = "*"
 	}
 
-	keys, err := s.cachesrv.Keys(pattern)
+	var keys []string
+	var nextCursor string
+	var err error
+
+	if query.Has("cursor") || query.Has("limit") {
+		var cursor uint64
+		if cursorParam := query.Get("cursor"); cursorParam != "" {
+			var parseErr error
+			cursor, parseErr = strconv.ParseUint(cursorParam, 10, 64)
+			if parseErr != nil {
+				s.errorResponse(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit := 100
+		if limitParam := query.Get("limit"); limitParam != "" {
+			var parseErr error
+			limit, parseErr = strconv.Atoi(limitParam)
+			if parseErr != nil || limit < 1 {
+				s.errorResponse(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var next uint64
+		unlock := s.cachesrv.SharedAll()
+		keys, next, err = s.cachesrv.Scan(cursor, pattern, limit, query.Get("type"))
+		unlock()
+		nextCursor = strconv.FormatUint(next, 10)
+	} else {
+		unlock := s.cachesrv.SharedAll()
+		keys, err = s.cachesrv.Keys(pattern)
+		unlock()
+	}
+
 	if err != nil {
 		s.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	response := KeysListResponse{
-		Keys:    keys,
-		Count:   len(keys),
-		Pattern: pattern,
+		Keys:       keys,
+		Count:      len(keys),
+		Pattern:    pattern,
+		NextCursor: nextCursor,
 	}
 
 	s.jsonResponse(w, response, http.StatusOK)
 }
 
 // GET /v1/keys/{key}
+// Values uploaded with a raw body, or requested with
+// "Accept: application/octet-stream", are returned as raw bytes with the TTL
+// in the X-Cago-TTL header.
 func (s *HttpServer) handleGet(w http.ResponseWriter, r *http.Request) {
 	key := chi.URLParam(r, "key")
 
-	value, exists, err := s.cachesrv.Get(key)
+	// Requests take the gate of their key only around the cache calls,
+	// once the body is read and before the response is written, so a slow
+	// client does not hold up the transactions on that shard.
+	unlock := s.cachesrv.Shared(key)
+	value, contentType, exists, err := s.cachesrv.GetWithContentType(key)
+	ttl, _ := s.cachesrv.TTL(key)
+	unlock()
+
 	if err != nil {
-		s.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		s.errorResponse(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -103,7 +181,6 @@ func (s *HttpServer) handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ttl, _ := s.cachesrv.TTL(key)
 	ttlSeconds := int64(-1)
 	if ttl > 0 {
 		ttlSeconds = int64(ttl.Seconds())
@@ -111,9 +188,21 @@ func (s *HttpServer) handleGet(w http.ResponseWriter, r *http.Request) {
 		ttlSeconds = -2
 	}
 
+	if contentType != "" || strings.Contains(r.Header.Get("Accept"), "application/octet-stream") {
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Cago-TTL", strconv.FormatInt(ttlSeconds, 10))
+		w.WriteHeader(http.StatusOK)
+		w.Write(value)
+		return
+	}
+
 	response := GetResponse{
 		Key:   key,
-		Value: value,
+		Value: string(value),
 		Ttl:   ttlSeconds,
 	}
 
@@ -122,9 +211,18 @@ func (s *HttpServer) handleGet(w http.ResponseWriter, r *http.Request) {
 
 // PUT /v1/keys/{key}
 // {"value": "value", "ttl" : 60}
+// {"value": "owner", "ttl_ms": 30000, "if_absent": true} only writes a
+// missing key, "if_present" only an existing one, and "keep_ttl" keeps the
+// TTL of the value being replaced
+// PUT /v1/keys/{key}?ttl=60 with any other Content-Type stores the raw body
 func (s *HttpServer) handleSet(w http.ResponseWriter, r *http.Request) {
 	key := chi.URLParam(r, "key")
 
+	if isRawBody(r) {
+		s.handleSetRaw(w, r, key)
+		return
+	}
+
 	var req SetRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -132,30 +230,117 @@ func (s *HttpServer) handleSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.IfAbsent && req.IfPresent {
+		s.errorResponse(w, "if_absent and if_present are not compatible", http.StatusBadRequest)
+		return
+	}
+	if (req.TTL != 0 && req.TTLMs != 0) || (req.KeepTTL && (req.TTL != 0 || req.TTLMs != 0)) {
+		s.errorResponse(w, "only one of ttl, ttl_ms and keep_ttl can be set", http.StatusBadRequest)
+		return
+	}
+
 	ttl := time.Duration(req.TTL) * time.Second
+	if req.TTLMs != 0 {
+		ttl = time.Duration(req.TTLMs) * time.Millisecond
+	}
 
-	if err := s.cachesrv.Set(key, req.Value, ttl); err != nil {
-		s.errorResponse(w, err.Error(), http.StatusInternalServerError)
+	opts := internal.SetOptions{KeepTTL: req.KeepTTL}
+	switch {
+	case req.IfAbsent:
+		opts.Condition = internal.SetIfAbsent
+	case req.IfPresent:
+		opts.Condition = internal.SetIfPresent
+	}
+
+	unlock := s.cachesrv.Shared(key)
+	_, _, written, err := s.cachesrv.SetWithOptions(key, []byte(req.Value), "", ttl, opts)
+	unlock()
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if !written {
+		if req.IfAbsent {
+			s.errorResponse(w, "key already exists", http.StatusConflict)
+		} else {
+			s.errorResponse(w, "key not found", http.StatusNotFound)
+		}
 		return
 	}
 
 	response := SetResponse{
 		Key:     key,
 		Value:   req.Value,
+		Size:    len(req.Value),
 		TTL:     req.TTL,
+		TTLMs:   req.TTLMs,
 		Success: true,
 	}
 
 	s.jsonResponse(w, response, http.StatusOK)
 }
 
+// isRawBody reports whether the request body is a value rather than a JSON
+// SetRequest. Form encoding is what clients such as curl send by default,
+// so it is treated as JSON too.
+func isRawBody(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "", "application/json", "application/x-www-form-urlencoded":
+		return false
+	}
+	return true
+}
+
+func (s *HttpServer) handleSetRaw(w http.ResponseWriter, r *http.Request, key string) {
+	var ttlSeconds int64
+	if ttlParam := r.URL.Query().Get("ttl"); ttlParam != "" {
+		parsed, err := strconv.ParseInt(ttlParam, 10, 64)
+		if err != nil {
+			s.errorResponse(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttlSeconds = parsed
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+	if err != nil {
+		s.errorResponse(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	ttl := time.Duration(ttlSeconds) * time.Second
+
+	unlock := s.cachesrv.Shared(key)
+	err = s.cachesrv.SetWithContentType(key, body, contentType, ttl)
+	unlock()
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	response := SetResponse{
+		Key:         key,
+		ContentType: contentType,
+		Size:        len(body),
+		TTL:         ttlSeconds,
+		Success:     true,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
 // DELETE /v1/keys/{key}
 func (s *HttpServer) handleDelete(w http.ResponseWriter, r *http.Request) {
 	key := chi.URLParam(r, "key")
 
+	unlock := s.cachesrv.Shared(key)
 	deleted, err := s.cachesrv.Delete(key)
+	unlock()
 	if err != nil {
-		s.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		s.errorResponse(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -173,7 +358,10 @@ func (s *HttpServer) handleDelete(w http.ResponseWriter, r *http.Request) {
 }
 
 // POST /v1/keys/{key}/expire
-// {"ttl": 60}
+// {"ttl": 60}, or {"ttl_ms": 1500} for milliseconds; one of them is
+// required. A TTL that is not positive deletes the key. {"ttl": 60,
+// "condition": "gt"} only moves the TTL later, "lt" sooner, "nx" only sets a
+// TTL on a key without one and "xx" only changes an existing TTL.
 func (s *HttpServer) handleExpire(w http.ResponseWriter, r *http.Request) {
 	key := chi.URLParam(r, "key")
 
@@ -184,26 +372,153 @@ func (s *HttpServer) handleExpire(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ttl := time.Duration(req.TTL) * time.Second
+	if req.TTL == nil && req.TTLMs == nil {
+		s.errorResponse(w, "ttl or ttl_ms is required", http.StatusBadRequest)
+		return
+	}
 
-	if err := s.cachesrv.Expire(key, ttl); err != nil {
-		if err == internal.ErrKeyNotFound {
-			s.errorResponse(w, "key not found", http.StatusNotFound)
+	if req.TTL != nil && req.TTLMs != nil {
+		s.errorResponse(w, "only one of ttl and ttl_ms can be set", http.StatusBadRequest)
+		return
+	}
+
+	var cond internal.ExpireCondition
+	switch strings.ToLower(req.Condition) {
+	case "":
+		cond = internal.ExpireAlways
+	case "nx":
+		cond = internal.ExpireIfNone
+	case "xx":
+		cond = internal.ExpireIfSet
+	case "gt":
+		cond = internal.ExpireIfLater
+	case "lt":
+		cond = internal.ExpireIfSooner
+	default:
+		s.errorResponse(w, "invalid condition", http.StatusBadRequest)
+		return
+	}
+
+	var ttlSec, ttlMs int64
+	if req.TTL != nil {
+		ttlSec = *req.TTL
+	} else {
+		ttlMs = *req.TTLMs
+	}
+	ttl := time.Duration(ttlSec)*time.Second + time.Duration(ttlMs)*time.Millisecond
+
+	unlock := s.cachesrv.Shared(key)
+	defer unlock()
+
+	changed, err := s.cachesrv.Expire(key, ttl, cond)
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if !changed {
+		if exists, _ := s.cachesrv.Exists(key); exists {
+			s.errorResponse(w, "expire condition not met", http.StatusConflict)
 			return
 		}
-		s.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		s.errorResponse(w, "key not found", http.StatusNotFound)
 		return
 	}
 
 	response := ExpireResponse{
 		Key:     key,
-		TTL:     req.TTL,
+		TTL:     ttlSec,
+		TTLMs:   ttlMs,
+		Deleted: ttl <= 0,
+		Success: true,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// DELETE /v1/keys/{key}/expire removes the TTL, so the key never expires
+func (s *HttpServer) handlePersist(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	unlock := s.cachesrv.Shared(key)
+	defer unlock()
+
+	persisted, err := s.cachesrv.Persist(key)
+	if err != nil {
+		s.errorResponse(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if !persisted {
+		if exists, _ := s.cachesrv.Exists(key); exists {
+			s.errorResponse(w, "key has no ttl", http.StatusConflict)
+			return
+		}
+		s.errorResponse(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	response := ExpireResponse{
+		Key:     key,
+		TTL:     -1,
 		Success: true,
 	}
 
 	s.jsonResponse(w, response, http.StatusOK)
 }
 
+// POST /v1/keys/{key}/incr
+// {"increment": 5} increments an integer value, {"increment": 0.5} a float,
+// an empty body increments by 1. The key keeps its TTL.
+func (s *HttpServer) handleIncr(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var req IncrRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil && err != io.EOF {
+		s.errorResponse(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	if req.Increment == "" {
+		req.Increment = "1"
+	}
+
+	unlock := s.cachesrv.Shared(key)
+	defer unlock()
+
+	var value json.Number
+	if delta, err := req.Increment.Int64(); err == nil {
+		result, err := s.cachesrv.IncrBy(key, delta)
+		if err != nil {
+			s.errorResponse(w, err.Error(), errorStatus(err))
+			return
+		}
+		value = json.Number(strconv.FormatInt(result, 10))
+	} else {
+		delta, err := req.Increment.Float64()
+		if err != nil {
+			s.errorResponse(w, "invalid increment", http.StatusBadRequest)
+			return
+		}
+
+		result, err := s.cachesrv.IncrByFloat(key, delta)
+		if err != nil {
+			s.errorResponse(w, err.Error(), errorStatus(err))
+			return
+		}
+		value = json.Number(result)
+	}
+
+	response := IncrResponse{
+		Key:   key,
+		Value: value,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
 func (s *HttpServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	response := HealthResponse{
 		Status: "ok",
@@ -215,17 +530,73 @@ func (s *HttpServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // GET /v1/stats
 func (s *HttpServer) handleStats(w http.ResponseWriter, _ *http.Request) {
-	keys, _ := s.cachesrv.Keys("*")
+	// Counting the keys in memory, like DBSIZE, rather than listing them
+	// keeps the endpoint cheap on large datasets.
+	memory := s.cachesrv.MemoryStats()
 
 	response := StatsResponse{
-		TotalKeys:       len(keys),
+		TotalKeys:       memory.KeyCount,
 		DefaultTTL:      s.cfg.DefaultTTL.Seconds(),
 		CleanupInterval: s.cfg.CleanupInterval.Seconds(),
+		UsedMemory:      memory.UsedMemory,
+		PeakMemory:      memory.PeakMemory,
+		DatasetMemory:   memory.DatasetBytes,
+		OverheadMemory:  memory.OverheadBytes,
+		MaxMemory:       memory.MaxMemory,
+		EvictionPolicy:  string(memory.EvictionPolicy),
+		EvictedKeys:     memory.EvictedKeys,
 	}
 
 	s.jsonResponse(w, response, http.StatusOK)
 }
 
+// POST /v1/admin/save
+// POST /v1/admin/save?background=true
+func (s *HttpServer) handleSave(w http.ResponseWriter, r *http.Request) {
+	background := r.URL.Query().Get("background") == "true"
+
+	var err error
+	if background {
+		err = s.cachesrv.BackgroundSave()
+	} else {
+		err = s.cachesrv.Save()
+	}
+
+	if err != nil {
+		if err == internal.ErrSaveInProgress {
+			s.errorResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		s.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lastSave, _ := s.cachesrv.LastSave()
+	response := SaveResponse{
+		Background: background,
+		LastSave:   lastSave.Unix(),
+		Success:    true,
+	}
+
+	s.jsonResponse(w, response, http.StatusOK)
+}
+
+// errorStatus maps errors returned by the cache service to a status code.
+func errorStatus(err error) int {
+	switch err {
+	case internal.ErrOutOfMemory:
+		return http.StatusInsufficientStorage
+	case internal.ErrReadOnly:
+		return http.StatusForbidden
+	case internal.ErrWrongType, internal.ErrHashNotInteger, internal.ErrHashNotFloat, internal.ErrIncrOverflow, internal.ErrIncrNaN,
+		internal.ErrNotInteger, internal.ErrNotFloat:
+		return http.StatusConflict
+	case internal.ErrKeyEmpty, internal.ErrNoFields:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func (s *HttpServer) jsonResponse(w http.ResponseWriter, data interface{}, status int) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
@@ -0,0 +1,134 @@
+package internal
+
+// minListCap is the smallest ring a List shrinks back to.
+const minListCap = 8
+
+// List is a double-ended queue of elements backed by a ring buffer, so
+// pushes and pops at both ends take constant time. Elements are shared with
+// callers and never modified in place.
+type List struct {
+	ring [][]byte
+	head int
+	n    int
+}
+
+func newList(elems [][]byte) *List {
+	l := &List{ring: make([][]byte, max(len(elems), minListCap))}
+	l.n = copy(l.ring, elems)
+	return l
+}
+
+func (l *List) Len() int {
+	return l.n
+}
+
+// index maps a position from the head to a ring index.
+func (l *List) index(i int) int {
+	return (l.head + i) % len(l.ring)
+}
+
+func (l *List) at(i int) []byte {
+	return l.ring[l.index(i)]
+}
+
+func (l *List) set(i int, elem []byte) {
+	l.ring[l.index(i)] = elem
+}
+
+func (l *List) pushFront(elem []byte) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.ring)) % len(l.ring)
+	l.ring[l.head] = elem
+	l.n++
+}
+
+func (l *List) pushBack(elem []byte) {
+	l.grow()
+	l.ring[l.index(l.n)] = elem
+	l.n++
+}
+
+func (l *List) popFront() []byte {
+	elem := l.ring[l.head]
+	l.ring[l.head] = nil
+	l.head = (l.head + 1) % len(l.ring)
+	l.n--
+	l.shrink()
+	return elem
+}
+
+func (l *List) popBack() []byte {
+	i := l.index(l.n - 1)
+	elem := l.ring[i]
+	l.ring[i] = nil
+	l.n--
+	l.shrink()
+	return elem
+}
+
+// slice copies the elements from start to stop inclusive, which must be
+// valid positions.
+func (l *List) slice(start, stop int) [][]byte {
+	elems := make([][]byte, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		elems = append(elems, l.at(i))
+	}
+	return elems
+}
+
+func (l *List) all() [][]byte {
+	if l.n == 0 {
+		return nil
+	}
+	return l.slice(0, l.n-1)
+}
+
+// replace swaps the contents for elems, used by the operations that have to
+// rewrite the middle of the list anyway.
+func (l *List) replace(elems [][]byte) {
+	*l = *newList(elems)
+}
+
+func (l *List) grow() {
+	if l.n < len(l.ring) {
+		return
+	}
+	l.resize(len(l.ring) * 2)
+}
+
+func (l *List) shrink() {
+	if len(l.ring) > minListCap && l.n < len(l.ring)/4 {
+		l.resize(len(l.ring) / 2)
+	}
+}
+
+func (l *List) resize(size int) {
+	ring := make([][]byte, size)
+	for i := 0; i < l.n; i++ {
+		ring[i] = l.at(i)
+	}
+	l.ring = ring
+	l.head = 0
+}
+
+// normalizeRange resolves Redis style inclusive start and stop indexes,
+// where negative values count from the end, against a length of n. It
+// reports false when the range is empty.
+func normalizeRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NotifyFlags selects the keyspace events that are published, written as
+// the letters of notify-keyspace-events in Redis, e.g. "KEx". K and E pick
+// the channels and the other letters the classes of events; nothing is
+// published unless both a channel and a class are selected. The list, set,
+// hash and sorted set classes of Redis (l, s, h and z) are rejected, as
+// their commands publish no events.
+type NotifyFlags uint32
+
+const (
+	// NotifyKeyspace publishes the event name on __keyspace@0__:<key>.
+	NotifyKeyspace NotifyFlags = 1 << iota
+	// NotifyKeyevent publishes the key on __keyevent@0__:<event>.
+	NotifyKeyevent
+	// NotifyGeneric covers del, expire and persist.
+	NotifyGeneric
+	// NotifyString covers set, incrby, incrbyfloat, append and setrange.
+	NotifyString
+	// NotifyExpired covers keys removed once their TTL ran out, by the
+	// cleanup worker or when they are accessed.
+	NotifyExpired
+	// NotifyEvicted covers keys removed to stay under maxmemory.
+	NotifyEvicted
+
+	// NotifyAll is every class of event, the A alias.
+	NotifyAll = NotifyGeneric | NotifyString | NotifyExpired | NotifyEvicted
+)
+
+// The channels keyspace notifications are published on, followed by the
+// key or the event name.
+const (
+	KeyspaceChannelPrefix = "__keyspace@0__:"
+	KeyeventChannelPrefix = "__keyevent@0__:"
+)
+
+var notifyLetters = []struct {
+	letter byte
+	flag   NotifyFlags
+}{
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+}
+
+// ParseNotifyFlags parses notify-keyspace-events letters. An empty string
+// disables notifications.
+func ParseNotifyFlags(val string) (NotifyFlags, error) {
+	var flags NotifyFlags
+	for i := 0; i < len(val); i++ {
+		if val[i] == 'A' {
+			flags |= NotifyAll
+			continue
+		}
+
+		found := false
+		for _, l := range notifyLetters {
+			if l.letter == val[i] {
+				flags |= l.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid notify-keyspace-events flag %q", val[i])
+		}
+	}
+	return flags, nil
+}
+
+// String returns flags in the form ParseNotifyFlags reads, using A when
+// every class is selected.
+func (f NotifyFlags) String() string {
+	var b strings.Builder
+	if f&NotifyAll == NotifyAll {
+		b.WriteByte('A')
+	}
+	for _, l := range notifyLetters {
+		if f&l.flag == 0 || (f&NotifyAll == NotifyAll && l.flag&NotifyAll != 0) {
+			continue
+		}
+		b.WriteByte(l.letter)
+	}
+	return b.String()
+}
+
+// UsePubSub publishes the keyspace notifications of s through ps.
+func (s *Storage) UsePubSub(ps *PubSub) {
+	s.pubsub = ps
+}
+
+// SetNotifyFlags changes which keyspace events are published.
+func (s *Storage) SetNotifyFlags(flags NotifyFlags) {
+	s.notifyFlags.Store(uint32(flags))
+}
+
+func (s *Storage) NotifyFlags() NotifyFlags {
+	return NotifyFlags(s.notifyFlags.Load())
+}
+
+// notify publishes event for key when its class is enabled. It is called
+// with the shard of key locked, so events are published in the order the
+// writes happened.
+func (s *Storage) notify(class NotifyFlags, event, key string) {
+	flags := s.NotifyFlags()
+	if flags&class == 0 || s.pubsub == nil {
+		return
+	}
+
+	if flags&NotifyKeyspace != 0 {
+		s.pubsub.Publish(KeyspaceChannelPrefix+key, []byte(event))
+	}
+	if flags&NotifyKeyevent != 0 {
+		s.pubsub.Publish(KeyeventChannelPrefix+event, []byte(key))
+	}
+}
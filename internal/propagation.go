@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownCommand = errors.New("unknown propagated command")
+
+// Propagator receives every write applied to Storage as a command that
+// ApplyCommand can replay. Propagate is called while the written key's
+// shard is locked, so commands touching the same key arrive in the order
+// they were applied. Implementations must not call back into Storage.
+type Propagator interface {
+	Propagate(args [][]byte)
+}
+
+// AddPropagator registers p for all subsequent writes. It must be called
+// before the storage is shared between goroutines.
+func (s *Storage) AddPropagator(p Propagator) {
+	s.propagators = append(s.propagators, p)
+}
+
+func (s *Storage) propagate(args [][]byte) {
+	for _, p := range s.propagators {
+		p.Propagate(args)
+	}
+}
+
+func command(name, key string, args ...[]byte) [][]byte {
+	cmd := make([][]byte, 0, len(args)+2)
+	cmd = append(cmd, []byte(name), []byte(key))
+	return append(cmd, args...)
+}
+
+// setCommand encodes item as a SET with an absolute expiry, so replaying it
+// later restores the same deadline rather than a fresh TTL.
+func setCommand(key string, item *StorageItem) [][]byte {
+	cmd := command("SET", key, item.Value)
+	if !item.ExpiresAt.IsZero() {
+		cmd = append(cmd, []byte("PXAT"), formatUnixMilli(item.ExpiresAt))
+	}
+	if item.ContentType != "" {
+		cmd = append(cmd, []byte("CONTENTTYPE"), []byte(item.ContentType))
+	}
+	return cmd
+}
+
+func stringArgs(args [][]byte) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = string(arg)
+	}
+	return strs
+}
+
+func stringsToBytes(strs []string) [][]byte {
+	args := make([][]byte, len(strs))
+	for i, str := range strs {
+		args[i] = []byte(str)
+	}
+	return args
+}
+
+func formatUnixMilli(t time.Time) []byte {
+	return strconv.AppendInt(nil, t.UnixMilli(), 10)
+}
+
+func parseUnixMilli(val []byte) (time.Time, error) {
+	ms, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
+
+// ApplyCommand executes a command produced by a Propagator, bypassing the
+// defaults and checks applied to client writes. It is used to replay the
+// append-only log and to apply a replication stream.
+func (s *CacheService) ApplyCommand(args [][]byte) error {
+	if len(args) < 2 {
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, args)
+	}
+
+	key := string(args[1])
+	switch name := strings.ToUpper(string(args[0])); name {
+	case "SET":
+		if len(args) < 3 {
+			return fmt.Errorf("%w: SET without value", ErrUnknownCommand)
+		}
+
+		var expiresAt time.Time
+		var contentType string
+		for i := 3; i+1 < len(args); i += 2 {
+			switch strings.ToUpper(string(args[i])) {
+			case "PXAT":
+				at, err := parseUnixMilli(args[i+1])
+				if err != nil {
+					return err
+				}
+				expiresAt = at
+			case "CONTENTTYPE":
+				contentType = string(args[i+1])
+			}
+		}
+
+		return s.storage.Set(key, args[2], contentType, expiresAt)
+	case "DEL":
+		s.storage.Delete(key)
+		return nil
+	case "PEXPIREAT":
+		if len(args) != 3 {
+			return fmt.Errorf("%w: PEXPIREAT without time", ErrUnknownCommand)
+		}
+
+		at, err := parseUnixMilli(args[2])
+		if err != nil {
+			return err
+		}
+		s.storage.Expire(key, at, ExpireAlways)
+		return nil
+	case "PERSIST":
+		s.storage.Persist(key)
+		return nil
+	case "HSET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return fmt.Errorf("%w: HSET without field value pairs", ErrUnknownCommand)
+		}
+
+		fields := make([]HashField, 0, (len(args)-2)/2)
+		for i := 2; i < len(args); i += 2 {
+			fields = append(fields, HashField{Field: string(args[i]), Value: args[i+1]})
+		}
+		_, err := s.storage.HSet(key, fields, false)
+		return err
+	case "HDEL":
+		_, err := s.storage.HDel(key, stringArgs(args[2:]))
+		return err
+	case "LPUSH", "RPUSH", "LPOP", "RPOP", "LSET", "LREM", "LTRIM", "LINSERT", "LMOVE":
+		return s.applyListCommand(name, key, args[2:])
+	case "SADD":
+		_, err := s.storage.SAdd(key, stringArgs(args[2:]))
+		return err
+	case "SREM":
+		_, err := s.storage.SRem(key, stringArgs(args[2:]))
+		return err
+	case "SMOVE":
+		if len(args) != 4 {
+			return fmt.Errorf("%w: SMOVE without destination and member", ErrUnknownCommand)
+		}
+		_, err := s.storage.SMove(key, string(args[2]), string(args[3]))
+		return err
+	case "SUNIONSTORE", "SINTERSTORE", "SDIFFSTORE":
+		if len(args) < 3 {
+			return fmt.Errorf("%w: %s without keys", ErrUnknownCommand, name)
+		}
+		op := SetUnion
+		switch name {
+		case "SINTERSTORE":
+			op = SetInter
+		case "SDIFFSTORE":
+			op = SetDiff
+		}
+		_, err := s.storage.SetOpStore(op, key, stringArgs(args[2:]))
+		return err
+	case "ZADD":
+		if len(args)%2 != 0 {
+			return fmt.Errorf("%w: ZADD without score member pairs", ErrUnknownCommand)
+		}
+
+		members := make([]ZMember, 0, (len(args)-2)/2)
+		for i := 2; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(string(args[i]), 64)
+			if err != nil {
+				return err
+			}
+			members = append(members, ZMember{Member: string(args[i+1]), Score: score})
+		}
+		_, err := s.storage.ZAdd(key, members, ZAddOptions{})
+		return err
+	case "ZREM":
+		_, err := s.storage.ZRem(key, stringArgs(args[2:]))
+		return err
+	case "FUNCTION":
+		if len(args) != 4 || key != "RESTORE" || string(args[3]) != "FLUSH" {
+			return fmt.Errorf("%w: %s %s", ErrUnknownCommand, name, key)
+		}
+		libs, err := DecodeFunctions(args[2])
+		if err != nil {
+			return err
+		}
+		if s.storage.functions == nil {
+			return nil
+		}
+		return s.loadFunctions(libs, s.storage.saveFunctions)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, name)
+	}
+}
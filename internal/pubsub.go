@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Message is a payload published to Channel. Pattern is set when it was
+// delivered through a pattern subscription.
+type Message struct {
+	Pattern string
+	Channel string
+	Payload []byte
+}
+
+// PubSub routes published messages to the subscribers of channels and of
+// glob patterns matching them, see matchPattern.
+type PubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]map[*Subscriber]struct{}
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels: make(map[string]map[*Subscriber]struct{}),
+		patterns: make(map[string]map[*Subscriber]struct{}),
+	}
+}
+
+// Subscriber is one receiving end, such as a RESP connection in subscribe
+// mode. Messages are queued without ever blocking the publisher, and a
+// subscriber whose queue is full is dropped: it loses its subscriptions and
+// Done is closed, so its owner can disconnect it.
+type Subscriber struct {
+	pubsub   *PubSub
+	messages chan Message
+	done     chan struct{}
+	once     sync.Once
+	dropped  atomic.Bool
+
+	// channels and patterns are guarded by pubsub.mu.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// NewSubscriber returns a subscriber queueing up to buffer messages.
+func (ps *PubSub) NewSubscriber(buffer int) *Subscriber {
+	return &Subscriber{
+		pubsub:   ps,
+		messages: make(chan Message, buffer),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// Publish delivers payload to the subscribers of channel and of the
+// patterns matching it, and returns the number of deliveries.
+func (ps *PubSub) Publish(channel string, payload []byte) int {
+	// The payload may live in a reused read buffer.
+	payload = append([]byte(nil), payload...)
+
+	var receivers int
+	var slow []*Subscriber
+
+	ps.mu.RLock()
+	for sub := range ps.channels[channel] {
+		if sub.send(Message{Channel: channel, Payload: payload}) {
+			receivers++
+		} else {
+			slow = append(slow, sub)
+		}
+	}
+	for pattern, subs := range ps.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			if sub.send(Message{Pattern: pattern, Channel: channel, Payload: payload}) {
+				receivers++
+			} else {
+				slow = append(slow, sub)
+			}
+		}
+	}
+	ps.mu.RUnlock()
+
+	for _, sub := range slow {
+		sub.dropped.Store(true)
+		sub.Close()
+	}
+	return receivers
+}
+
+// Channels returns the channels with at least one subscriber that match
+// pattern, all of them when pattern is empty.
+func (ps *PubSub) Channels(pattern string) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	channels := make([]string, 0)
+	for channel := range ps.channels {
+		if pattern == "" || matchPattern(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns the number of subscribers of each channel, not counting
+// pattern subscriptions.
+func (ps *PubSub) NumSub(channels []string) []int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	counts := make([]int, len(channels))
+	for i, channel := range channels {
+		counts[i] = len(ps.channels[channel])
+	}
+	return counts
+}
+
+// NumPat returns the number of patterns with at least one subscriber.
+func (ps *PubSub) NumPat() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	return len(ps.patterns)
+}
+
+// Messages returns the queue of messages delivered to sub.
+func (sub *Subscriber) Messages() <-chan Message {
+	return sub.messages
+}
+
+// Done is closed once sub was closed or dropped for falling behind.
+func (sub *Subscriber) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Dropped reports whether sub was dropped for falling behind.
+func (sub *Subscriber) Dropped() bool {
+	return sub.dropped.Load()
+}
+
+// Subscribe adds channel to the subscriptions of sub and returns how many
+// channels and patterns it is subscribed to.
+func (sub *Subscriber) Subscribe(channel string) int {
+	return sub.subscribe(sub.pubsub.channels, sub.channels, channel)
+}
+
+// Unsubscribe removes channel from the subscriptions of sub and returns how
+// many are left.
+func (sub *Subscriber) Unsubscribe(channel string) int {
+	return sub.unsubscribe(sub.pubsub.channels, sub.channels, channel)
+}
+
+// PSubscribe is Subscribe for a glob pattern.
+func (sub *Subscriber) PSubscribe(pattern string) int {
+	return sub.subscribe(sub.pubsub.patterns, sub.patterns, pattern)
+}
+
+// PUnsubscribe is Unsubscribe for a glob pattern.
+func (sub *Subscriber) PUnsubscribe(pattern string) int {
+	return sub.unsubscribe(sub.pubsub.patterns, sub.patterns, pattern)
+}
+
+// Channels returns the channels sub is subscribed to, sorted.
+func (sub *Subscriber) Channels() []string {
+	return sub.names(sub.channels)
+}
+
+// Patterns returns the patterns sub is subscribed to, sorted.
+func (sub *Subscriber) Patterns() []string {
+	return sub.names(sub.patterns)
+}
+
+// Count returns how many channels and patterns sub is subscribed to.
+func (sub *Subscriber) Count() int {
+	sub.pubsub.mu.RLock()
+	defer sub.pubsub.mu.RUnlock()
+
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// Close removes every subscription of sub and closes Done. Messages still
+// queued are left unread.
+func (sub *Subscriber) Close() {
+	sub.once.Do(func() {
+		ps := sub.pubsub
+		ps.mu.Lock()
+		for channel := range sub.channels {
+			removeSubscriber(ps.channels, channel, sub)
+		}
+		for pattern := range sub.patterns {
+			removeSubscriber(ps.patterns, pattern, sub)
+		}
+		clear(sub.channels)
+		clear(sub.patterns)
+		ps.mu.Unlock()
+
+		close(sub.done)
+	})
+}
+
+// send queues msg unless the queue is full. The caller must hold pubsub.mu.
+func (sub *Subscriber) send(msg Message) bool {
+	select {
+	case sub.messages <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sub *Subscriber) subscribe(index map[string]map[*Subscriber]struct{}, own map[string]struct{}, name string) int {
+	ps := sub.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	// A closed subscriber must not show up in the index again.
+	select {
+	case <-sub.done:
+	default:
+		own[name] = struct{}{}
+		subs := index[name]
+		if subs == nil {
+			subs = make(map[*Subscriber]struct{})
+			index[name] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+	return len(sub.channels) + len(sub.patterns)
+}
+
+func (sub *Subscriber) unsubscribe(index map[string]map[*Subscriber]struct{}, own map[string]struct{}, name string) int {
+	ps := sub.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := own[name]; ok {
+		delete(own, name)
+		removeSubscriber(index, name, sub)
+	}
+	return len(sub.channels) + len(sub.patterns)
+}
+
+func (sub *Subscriber) names(own map[string]struct{}) []string {
+	sub.pubsub.mu.RLock()
+	defer sub.pubsub.mu.RUnlock()
+
+	names := make([]string, 0, len(own))
+	for name := range own {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// removeSubscriber drops sub from the subscribers of name, and name from
+// index once it has none. The caller must hold the PubSub lock.
+func removeSubscriber(index map[string]map[*Subscriber]struct{}, name string, sub *Subscriber) {
+	subs := index[name]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(index, name)
+	}
+}
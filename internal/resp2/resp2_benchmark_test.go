@@ -0,0 +1,149 @@
+package resp2
+
+import (
+	"bytes"
+	"cago/internal"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// pipelineDepth is the number of commands sent at once, like
+// redis-benchmark -P 16.
+const pipelineDepth = 16
+
+// batchConn is a connection that receives the same pipelined batch on every
+// read, as a client waiting for its replies would send it, and counts the
+// writes made to it, each of which would be a syscall.
+type batchConn struct {
+	batch  []byte
+	writes int
+}
+
+func (c *batchConn) Read(p []byte) (int, error) {
+	return copy(p, c.batch), nil
+}
+
+func (c *batchConn) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+func pipeline(args ...string) []byte {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+
+	batch := encodeCommand(cmd)
+	return bytes.Repeat(batch, pipelineDepth)
+}
+
+func benchmarkHandler(b *testing.B) *RESPHandler {
+	cfg := internal.LoadConfig()
+	cfg.DefaultTTL = 0
+	cachesrv := internal.NewCacheService(internal.NewStorage(cfg), 0)
+	replication := NewReplication(cfg, cachesrv, b.Context())
+
+	if err := cachesrv.Set("key:1", []byte("value:1"), 0); err != nil {
+		b.Fatal(err)
+	}
+	return NewRESPHandler(cfg, cachesrv, replication)
+}
+
+// BenchmarkParse parses batches of pipelined GET commands.
+func BenchmarkParse(b *testing.B) {
+	conn := &batchConn{batch: pipeline("GET", "key:1")}
+	parser := NewRESPParser(conn)
+
+	b.SetBytes(int64(len(conn.batch)))
+	b.ReportAllocs()
+	for b.Loop() {
+		for range pipelineDepth {
+			if _, err := parser.Parse(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkWriter writes a batch of bulk string replies, flushing them
+// together or writing each of them on its own.
+func BenchmarkWriter(b *testing.B) {
+	val := []byte("value:1")
+
+	for _, buffered := range []bool{false, true} {
+		b.Run(fmt.Sprintf("buffered=%t", buffered), func(b *testing.B) {
+			conn := &batchConn{}
+			writer := NewRESPWriter(conn)
+			if buffered {
+				writer = NewBufferedRESPWriter(conn)
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				for range pipelineDepth {
+					if err := writer.WriteBulk(val); err != nil {
+						b.Fatal(err)
+					}
+					if err := writer.WriteInteger(int64(len(val))); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := writer.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+		})
+	}
+}
+
+// BenchmarkPipeline runs batches of pipelined commands through the handler
+// the way the connection loop does, flushing the replies once the batch is
+// parsed. Without the buffered writer each reply is a write of its own.
+func BenchmarkPipeline(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		args []string
+	}{
+		{"GET", []string{"GET", "key:1"}},
+		{"SET", []string{"SET", "key:1", "value:1"}},
+		{"INCR", []string{"INCR", "counter"}},
+	} {
+		for _, buffered := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/buffered=%t", bench.name, buffered), func(b *testing.B) {
+				h := benchmarkHandler(b)
+				conn := &batchConn{batch: pipeline(bench.args...)}
+				client := &Client{
+					ctx:    context.Background(),
+					parser: NewRESPParser(conn),
+					writer: NewRESPWriter(conn),
+				}
+				if buffered {
+					client.writer = NewBufferedRESPWriter(conn)
+				}
+
+				b.SetBytes(int64(len(conn.batch)))
+				b.ReportAllocs()
+				for b.Loop() {
+					for range pipelineDepth {
+						cmd, err := client.parser.Parse()
+						if err != nil {
+							b.Fatal(err)
+						}
+						if err := h.HandleCommand(cmd, client); err != nil {
+							b.Fatal(err)
+						}
+						if client.parser.Buffered() == 0 {
+							if err := client.writer.Flush(); err != nil {
+								b.Fatal(err)
+							}
+						}
+					}
+				}
+				b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package resp2
+
+import (
+	"cago/internal"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Client is the server side state of one RESP connection.
+type Client struct {
+	ID     int64
+	ctx    context.Context
+	conn   net.Conn
+	parser *RESPParser
+	writer *RESPWriter
+
+	// name is set with HELLO SETNAME.
+	name string
+
+	// listeningPort is announced by replicas through REPLCONF.
+	listeningPort string
+
+	// sub is created by the first SUBSCRIBE or PSUBSCRIBE. Its messages
+	// are written concurrently with replies, so both hold writeMu. The
+	// connection loop holds it while a command runs, and sets writing,
+	// but lets go of it while the command waits; see releaseWrites.
+	sub     *internal.Subscriber
+	writeMu sync.Mutex
+	writing bool
+
+	// multi queues the commands sent after MULTI until EXEC or DISCARD.
+	multi *transaction
+	// watched holds the keys of WATCH until EXEC, DISCARD or UNWATCH.
+	watched []internal.WatchedKey
+	// inExec is set while a transaction or a script runs, where blocking
+	// commands return at once instead of waiting.
+	inExec bool
+}
+
+// NewClient wraps conn. Blocking commands give up once ctx is done.
+func NewClient(ctx context.Context, id int64, conn net.Conn) *Client {
+	return &Client{
+		ID:     id,
+		ctx:    ctx,
+		conn:   conn,
+		parser: NewRESPParser(conn),
+		writer: NewBufferedRESPWriter(conn),
+	}
+}
+
+func (c *Client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// watchClose returns a context for a blocking command that is cancelled
+// when the client disconnects or the client context is done. stop must be
+// called before the connection is read again. Inside a transaction or a
+// script nothing blocks, so there is nothing to watch.
+func (c *Client) watchClose() (ctx context.Context, stop func()) {
+	if c.inExec {
+		return c.ctx, func() {}
+	}
+
+	// The replies to the commands pipelined before must not wait for the
+	// blocking one. A failed write shows up as the connection closing.
+	c.writer.Flush()
+	reacquire := c.releaseWrites()
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// Input sent while blocked stays buffered for the next Parse.
+		err := c.parser.waitInput()
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			cancel()
+		}
+	}()
+
+	return ctx, func() {
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+		cancel()
+		reacquire()
+	}
+}
+
+// lockWrites takes writeMu for the connection loop while it runs a
+// command and writes its reply.
+func (c *Client) lockWrites() {
+	c.writeMu.Lock()
+	c.writing = true
+}
+
+func (c *Client) unlockWrites() {
+	c.writing = false
+	c.writeMu.Unlock()
+}
+
+// releaseWrites lets go of writeMu, when the connection loop holds it, so
+// the messages of the subscriber are not held up while a command waits.
+// The replies written so far must be complete, as messages follow them in
+// the buffer. The returned function takes writeMu back.
+func (c *Client) releaseWrites() (reacquire func()) {
+	if !c.writing {
+		return func() {}
+	}
+	c.writeMu.Unlock()
+	return c.writeMu.Lock
+}
+
+// subscriber returns the subscriber of c, creating it on first use along
+// with the goroutine writing its messages to the connection.
+func (c *Client) subscriber(cachesrv *internal.CacheService, buffer int) *internal.Subscriber {
+	if c.sub == nil {
+		c.sub = cachesrv.NewSubscriber(buffer)
+		go c.deliver(c.sub)
+	}
+	return c.sub
+}
+
+// subscribed reports whether c is in subscribe mode, where RESP2 clients
+// may only send the commands in subscribeCommands.
+func (c *Client) subscribed() bool {
+	return c.sub != nil && c.sub.Count() > 0
+}
+
+// deliver writes the messages of sub until it is closed. A subscriber
+// dropped for falling behind is disconnected, which also unblocks a write
+// stuck on a client that stopped reading.
+func (c *Client) deliver(sub *internal.Subscriber) {
+	go func() {
+		<-sub.Done()
+		if sub.Dropped() {
+			fmt.Printf("Disconnecting slow subscriber: %s\n", c.RemoteAddr())
+			c.conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-sub.Messages():
+			c.writeMu.Lock()
+			err := writeMessage(c.writer, msg)
+			if err == nil && len(sub.Messages()) == 0 {
+				err = c.writer.Flush()
+			}
+			c.writeMu.Unlock()
+			if err != nil {
+				sub.Close()
+				return
+			}
+		case <-sub.Done():
+			return
+		}
+	}
+}
+
+// close releases what c holds once the connection is gone.
+func (c *Client) close() {
+	if c.sub != nil {
+		c.sub.Close()
+	}
+}
@@ -0,0 +1,329 @@
+package resp2
+
+import (
+	"strconv"
+
+	"cago/internal"
+)
+
+// commandFlags tell where and how a command may run.
+type commandFlags uint8
+
+const (
+	// flagWrite commands modify the dataset. A script that ran one of them
+	// can no longer be killed, as that would leave its writes half done.
+	flagWrite commandFlags = 1 << iota
+	// flagNoScript commands cannot be called from a script, as they act on
+	// the connection or the server rather than on the dataset.
+	flagNoScript
+	// flagNoMulti commands cannot be queued, as they change the connection
+	// itself.
+	flagNoMulti
+	// flagTransaction commands run at once even between MULTI and EXEC.
+	flagTransaction
+	// flagUngated commands do not hold the storage gates of their keys for
+	// their whole run: EXEC, scripts and functions take them exclusively, blocking
+	// commands only while they do not wait, PSYNC streams to the replica
+	// for as long as it is connected and SCRIPT KILL and FUNCTION KILL must
+	// get through while a script holds them.
+	flagUngated
+	// flagKeyspace commands work on the whole keyspace rather than on the
+	// keys among their arguments, so they hold the gates of every shard.
+	flagKeyspace
+)
+
+// commandFunc runs a command for client. command is the name it was called
+// with, for handlers shared by several commands.
+type commandFunc func(h *RESPHandler, command string, args []Value, client *Client) error
+
+// commandSpec is an entry of the command table.
+type commandSpec struct {
+	run commandFunc
+	// arity is the number of arguments, counting the name of the command
+	// as in Redis. A negative arity is a minimum. Transactions and scripts
+	// check it up front, the way Redis does; handlers check their
+	// arguments again when they run.
+	arity int
+	flags commandFlags
+	keys  keySpec
+}
+
+// keySpec tells which arguments of a command are keys, by position as in
+// Redis, the name of the command being 0: every step-th argument from first
+// to last, a negative last counting from the end, and when numKeys is set
+// the keys following the number of keys at that position. A zero first
+// means the command has no keys at fixed positions.
+type keySpec struct {
+	first, last, step int
+	numKeys           int
+}
+
+var (
+	noKeys      = keySpec{}
+	oneKey      = keySpec{first: 1, last: 1, step: 1}
+	twoKeys     = keySpec{first: 1, last: 2, step: 1}
+	allKeys     = keySpec{first: 1, last: -1, step: 1}
+	keyPairs    = keySpec{first: 1, last: -1, step: 2}
+	keysButLast = keySpec{first: 1, last: -2, step: 1}
+)
+
+// find appends to keys the keys among args, the arguments following the
+// name of the command. Arguments missing or malformed are skipped, as the
+// handler reports them.
+func (k keySpec) find(keys []string, args []Value) []string {
+	argc := len(args) + 1
+
+	if k.first > 0 {
+		last := k.last
+		if last < 0 {
+			last += argc
+		}
+		for i := k.first; i <= last && i < argc; i += k.step {
+			keys = append(keys, string(args[i-1].Bulk))
+		}
+	}
+
+	if k.numKeys > 0 && k.numKeys < argc {
+		n, err := strconv.Atoi(string(args[k.numKeys-1].Bulk))
+		if err != nil {
+			return keys
+		}
+		for i := k.numKeys + 1; i <= k.numKeys+n && i < argc; i++ {
+			keys = append(keys, string(args[i-1].Bulk))
+		}
+	}
+	return keys
+}
+
+func (c *commandSpec) is(flag commandFlags) bool {
+	return c.flags&flag != 0
+}
+
+// arityOK reports whether args, the name of the command included, fit the
+// arity.
+func (c *commandSpec) arityOK(args int) bool {
+	if c.arity >= 0 {
+		return args == c.arity
+	}
+	return args >= -c.arity
+}
+
+// commands is the command table, which dispatch, transactions and scripts
+// all look commands up in. It is filled in by init, as the handlers refer
+// back to it through dispatch.
+var commands map[string]*commandSpec
+
+func init() {
+	commands = map[string]*commandSpec{
+		"PING":        {withClient((*RESPHandler).handlePing), -1, 0, noKeys},
+		"SET":         {withWriter((*RESPHandler).handleSet), -3, flagWrite, oneKey},
+		"GET":         {withWriter((*RESPHandler).handleGet), 2, 0, oneKey},
+		"INCR":        {withCommandWriter((*RESPHandler).handleIncr), 2, flagWrite, oneKey},
+		"DECR":        {withCommandWriter((*RESPHandler).handleIncr), 2, flagWrite, oneKey},
+		"INCRBY":      {withCommandWriter((*RESPHandler).handleIncr), 3, flagWrite, oneKey},
+		"DECRBY":      {withCommandWriter((*RESPHandler).handleIncr), 3, flagWrite, oneKey},
+		"INCRBYFLOAT": {withWriter((*RESPHandler).handleIncrByFloat), 3, flagWrite, oneKey},
+		"APPEND":      {withWriter((*RESPHandler).handleAppend), 3, flagWrite, oneKey},
+		"STRLEN":      {withWriter((*RESPHandler).handleStrLen), 2, 0, oneKey},
+		"GETRANGE":    {withWriter((*RESPHandler).handleGetRange), 4, 0, oneKey},
+		"SETRANGE":    {withWriter((*RESPHandler).handleSetRange), 4, flagWrite, oneKey},
+		"MGET":        {withWriter((*RESPHandler).handleMGet), -2, 0, allKeys},
+		"MSET":        {withWriter((*RESPHandler).handleMSet), -3, flagWrite, keyPairs},
+		"MSETNX":      {withWriter((*RESPHandler).handleMSetNX), -3, flagWrite, keyPairs},
+		"GETDEL":      {withWriter((*RESPHandler).handleGetDel), 2, flagWrite, oneKey},
+		"GETEX":       {withWriter((*RESPHandler).handleGetEx), -2, flagWrite, oneKey},
+		"GETSET":      {withWriter((*RESPHandler).handleGetSet), 3, flagWrite, oneKey},
+		"DEL":         {withWriter((*RESPHandler).handleDel), -2, flagWrite, allKeys},
+		"EXISTS":      {withWriter((*RESPHandler).handleExists), -2, 0, allKeys},
+		"EXPIRE":      {withCommandWriter((*RESPHandler).handleExpire), -3, flagWrite, oneKey},
+		"PEXPIRE":     {withCommandWriter((*RESPHandler).handleExpire), -3, flagWrite, oneKey},
+		"EXPIREAT":    {withCommandWriter((*RESPHandler).handleExpire), -3, flagWrite, oneKey},
+		"PEXPIREAT":   {withCommandWriter((*RESPHandler).handleExpire), -3, flagWrite, oneKey},
+		"PERSIST":     {withWriter((*RESPHandler).handlePersist), 2, flagWrite, oneKey},
+		"TTL":         {withCommandWriter((*RESPHandler).handleTTL), 2, 0, oneKey},
+		"PTTL":        {withCommandWriter((*RESPHandler).handleTTL), 2, 0, oneKey},
+		"EXPIRETIME":  {withCommandWriter((*RESPHandler).handleExpireTime), 2, 0, oneKey},
+		"PEXPIRETIME": {withCommandWriter((*RESPHandler).handleExpireTime), 2, 0, oneKey},
+		"TYPE":        {withWriter((*RESPHandler).handleType), 2, 0, oneKey},
+		"KEYS":        {withWriter((*RESPHandler).handleKeys), 2, flagKeyspace, noKeys},
+		"SCAN":        {withWriter((*RESPHandler).handleScan), -2, flagKeyspace, noKeys},
+
+		"HSET":         {withCommandWriter((*RESPHandler).handleHSet), -4, flagWrite, oneKey},
+		"HMSET":        {withCommandWriter((*RESPHandler).handleHSet), -4, flagWrite, oneKey},
+		"HSETNX":       {withWriter((*RESPHandler).handleHSetNX), 4, flagWrite, oneKey},
+		"HGET":         {withWriter((*RESPHandler).handleHGet), 3, 0, oneKey},
+		"HMGET":        {withWriter((*RESPHandler).handleHMGet), -3, 0, oneKey},
+		"HDEL":         {withWriter((*RESPHandler).handleHDel), -3, flagWrite, oneKey},
+		"HEXISTS":      {withWriter((*RESPHandler).handleHExists), 3, 0, oneKey},
+		"HLEN":         {withWriter((*RESPHandler).handleHLen), 2, 0, oneKey},
+		"HKEYS":        {withWriter((*RESPHandler).handleHKeys), 2, 0, oneKey},
+		"HVALS":        {withWriter((*RESPHandler).handleHVals), 2, 0, oneKey},
+		"HGETALL":      {withWriter((*RESPHandler).handleHGetAll), 2, 0, oneKey},
+		"HINCRBY":      {withWriter((*RESPHandler).handleHIncrBy), 4, flagWrite, oneKey},
+		"HINCRBYFLOAT": {withWriter((*RESPHandler).handleHIncrByFloat), 4, flagWrite, oneKey},
+		"HSCAN":        {withWriter((*RESPHandler).handleHScan), -3, 0, oneKey},
+
+		"LPUSH":   {withCommandWriter((*RESPHandler).handlePush), -3, flagWrite, oneKey},
+		"RPUSH":   {withCommandWriter((*RESPHandler).handlePush), -3, flagWrite, oneKey},
+		"LPOP":    {withCommandWriter((*RESPHandler).handlePop), -2, flagWrite, oneKey},
+		"RPOP":    {withCommandWriter((*RESPHandler).handlePop), -2, flagWrite, oneKey},
+		"LLEN":    {withWriter((*RESPHandler).handleLLen), 2, 0, oneKey},
+		"LRANGE":  {withWriter((*RESPHandler).handleLRange), 4, 0, oneKey},
+		"LINDEX":  {withWriter((*RESPHandler).handleLIndex), 3, 0, oneKey},
+		"LSET":    {withWriter((*RESPHandler).handleLSet), 4, flagWrite, oneKey},
+		"LREM":    {withWriter((*RESPHandler).handleLRem), 4, flagWrite, oneKey},
+		"LTRIM":   {withWriter((*RESPHandler).handleLTrim), 4, flagWrite, oneKey},
+		"LINSERT": {withWriter((*RESPHandler).handleLInsert), 5, flagWrite, oneKey},
+		"LPOS":    {withWriter((*RESPHandler).handleLPos), -3, 0, oneKey},
+		"LMOVE":   {withWriter((*RESPHandler).handleLMove), 5, flagWrite, twoKeys},
+		"BLPOP":   {withCommandClient((*RESPHandler).handleBlockingPop), -3, flagWrite | flagUngated, keysButLast},
+		"BRPOP":   {withCommandClient((*RESPHandler).handleBlockingPop), -3, flagWrite | flagUngated, keysButLast},
+		"BLMOVE":  {withClient((*RESPHandler).handleBLMove), 6, flagWrite | flagUngated, twoKeys},
+
+		"SADD":        {withWriter((*RESPHandler).handleSAdd), -3, flagWrite, oneKey},
+		"SREM":        {withWriter((*RESPHandler).handleSRem), -3, flagWrite, oneKey},
+		"SMEMBERS":    {withWriter((*RESPHandler).handleSMembers), 2, 0, oneKey},
+		"SISMEMBER":   {withWriter((*RESPHandler).handleSIsMember), 3, 0, oneKey},
+		"SMISMEMBER":  {withWriter((*RESPHandler).handleSMIsMember), -3, 0, oneKey},
+		"SCARD":       {withWriter((*RESPHandler).handleSCard), 2, 0, oneKey},
+		"SPOP":        {withWriter((*RESPHandler).handleSPop), -2, flagWrite, oneKey},
+		"SRANDMEMBER": {withWriter((*RESPHandler).handleSRandMember), -2, 0, oneKey},
+		"SMOVE":       {withWriter((*RESPHandler).handleSMove), 4, flagWrite, twoKeys},
+		"SUNION":      {setOp(internal.SetUnion), -2, 0, allKeys},
+		"SINTER":      {setOp(internal.SetInter), -2, 0, allKeys},
+		"SDIFF":       {setOp(internal.SetDiff), -2, 0, allKeys},
+		"SUNIONSTORE": {setOpStore(internal.SetUnion), -3, flagWrite, allKeys},
+		"SINTERSTORE": {setOpStore(internal.SetInter), -3, flagWrite, allKeys},
+		"SDIFFSTORE":  {setOpStore(internal.SetDiff), -3, flagWrite, allKeys},
+		"SSCAN":       {withWriter((*RESPHandler).handleSScan), -3, 0, oneKey},
+
+		"ZADD":        {withWriter((*RESPHandler).handleZAdd), -4, flagWrite, oneKey},
+		"ZREM":        {withWriter((*RESPHandler).handleZRem), -3, flagWrite, oneKey},
+		"ZSCORE":      {withWriter((*RESPHandler).handleZScore), 3, 0, oneKey},
+		"ZINCRBY":     {withWriter((*RESPHandler).handleZIncrBy), 4, flagWrite, oneKey},
+		"ZCARD":       {withWriter((*RESPHandler).handleZCard), 2, 0, oneKey},
+		"ZRANK":       {zrank(false), -3, 0, oneKey},
+		"ZREVRANK":    {zrank(true), -3, 0, oneKey},
+		"ZRANGE":      {withWriter((*RESPHandler).handleZRange), -4, 0, oneKey},
+		"ZRANGESTORE": {withWriter((*RESPHandler).handleZRangeStore), -5, flagWrite, twoKeys},
+		"ZCOUNT":      {withWriter((*RESPHandler).handleZCount), 4, 0, oneKey},
+		"ZPOPMIN":     {zpop(false), -2, flagWrite, oneKey},
+		"ZPOPMAX":     {zpop(true), -2, flagWrite, oneKey},
+		"BZPOPMIN":    {bzpop(false), -3, flagWrite | flagUngated, keysButLast},
+		"BZPOPMAX":    {bzpop(true), -3, flagWrite | flagUngated, keysButLast},
+		"ZUNIONSTORE": {zsetOpStore(internal.SetUnion), -4, flagWrite, keySpec{first: 1, last: 1, step: 1, numKeys: 2}},
+		"ZINTERSTORE": {zsetOpStore(internal.SetInter), -4, flagWrite, keySpec{first: 1, last: 1, step: 1, numKeys: 2}},
+
+		"SUBSCRIBE":    {withCommandClient((*RESPHandler).handleSubscribe), -2, flagNoScript | flagNoMulti, noKeys},
+		"PSUBSCRIBE":   {withCommandClient((*RESPHandler).handleSubscribe), -2, flagNoScript | flagNoMulti, noKeys},
+		"UNSUBSCRIBE":  {withCommandClient((*RESPHandler).handleUnsubscribe), -1, flagNoScript | flagNoMulti, noKeys},
+		"PUNSUBSCRIBE": {withCommandClient((*RESPHandler).handleUnsubscribe), -1, flagNoScript | flagNoMulti, noKeys},
+		"PUBLISH":      {withWriter((*RESPHandler).handlePublish), 3, 0, noKeys},
+		"PUBSUB":       {withWriter((*RESPHandler).handlePubSub), -2, 0, noKeys},
+
+		"MEMORY":       {withWriter((*RESPHandler).handleMemory), -2, 0, keySpec{first: 2, last: 2, step: 1}},
+		"SAVE":         {withWriter((*RESPHandler).handleSave), 1, flagNoScript | flagKeyspace, noKeys},
+		"BGSAVE":       {withWriter((*RESPHandler).handleBgSave), -1, flagNoScript, noKeys},
+		"LASTSAVE":     {withWriter((*RESPHandler).handleLastSave), 1, 0, noKeys},
+		"BGREWRITEAOF": {withWriter((*RESPHandler).handleBgRewriteAOF), 1, flagNoScript, noKeys},
+		"HELLO":        {withClient((*RESPHandler).handleHello), -1, flagNoScript | flagNoMulti, noKeys},
+		"INFO":         {withWriter((*RESPHandler).handleInfo), -1, 0, noKeys},
+		"REPLICAOF":    {withWriter((*RESPHandler).handleReplicaOf), 3, flagNoScript, noKeys},
+		"SLAVEOF":      {withWriter((*RESPHandler).handleReplicaOf), 3, flagNoScript, noKeys},
+		"REPLCONF":     {withClient((*RESPHandler).handleReplConf), -1, flagNoScript | flagNoMulti, noKeys},
+		"PSYNC":        {withClient((*RESPHandler).handlePsync), -3, flagNoScript | flagNoMulti | flagUngated, noKeys},
+		"SYNC":         {fullSync, 1, flagNoScript | flagNoMulti | flagUngated, noKeys},
+
+		"MULTI":   {withClient((*RESPHandler).handleMulti), 1, flagNoScript | flagTransaction, noKeys},
+		"EXEC":    {withClient((*RESPHandler).handleExec), 1, flagNoScript | flagTransaction | flagUngated, noKeys},
+		"DISCARD": {withClient((*RESPHandler).handleDiscard), 1, flagNoScript | flagTransaction, noKeys},
+		"WATCH":   {withClient((*RESPHandler).handleWatch), -2, flagNoScript | flagTransaction, allKeys},
+		"UNWATCH": {withClient((*RESPHandler).handleUnwatch), 1, flagNoScript, noKeys},
+
+		"EVAL":     {withCommandClient((*RESPHandler).handleEval), -3, flagNoScript | flagUngated, keySpec{numKeys: 2}},
+		"EVALSHA":  {withCommandClient((*RESPHandler).handleEval), -3, flagNoScript | flagUngated, keySpec{numKeys: 2}},
+		"SCRIPT":   {withWriter((*RESPHandler).handleScript), -2, flagNoScript | flagUngated, noKeys},
+		"FUNCTION": {withWriter((*RESPHandler).handleFunction), -2, flagNoScript | flagUngated, noKeys},
+		"FCALL":    {withCommandClient((*RESPHandler).handleFcall), -3, flagNoScript | flagUngated, keySpec{numKeys: 2}},
+		"FCALL_RO": {withCommandClient((*RESPHandler).handleFcall), -3, flagNoScript | flagUngated, keySpec{numKeys: 2}},
+	}
+}
+
+// shared holds the storage gates for running spec on args, those of every
+// shard for a command on the whole keyspace or unknown, see
+// CacheService.Shared.
+func shared(cachesrv *internal.CacheService, spec *commandSpec, args []Value) (unlock func()) {
+	if spec == nil || spec.is(flagKeyspace) {
+		return cachesrv.SharedAll()
+	}
+	var buf [4]string
+	return cachesrv.Shared(spec.keys.find(buf[:0], args)...)
+}
+
+// The adapters below turn handlers into commandFuncs, according to what
+// they take besides the arguments.
+
+func withWriter(fn func(*RESPHandler, []Value, *RESPWriter) error) commandFunc {
+	return func(h *RESPHandler, _ string, args []Value, client *Client) error {
+		return fn(h, args, client.writer)
+	}
+}
+
+func withCommandWriter(fn func(*RESPHandler, string, []Value, *RESPWriter) error) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return fn(h, command, args, client.writer)
+	}
+}
+
+func withClient(fn func(*RESPHandler, []Value, *Client) error) commandFunc {
+	return func(h *RESPHandler, _ string, args []Value, client *Client) error {
+		return fn(h, args, client)
+	}
+}
+
+func withCommandClient(fn func(*RESPHandler, string, []Value, *Client) error) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return fn(h, command, args, client)
+	}
+}
+
+func setOp(op internal.SetOp) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return h.handleSetOp(command, op, args, client.writer)
+	}
+}
+
+func setOpStore(op internal.SetOp) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return h.handleSetOpStore(command, op, args, client.writer)
+	}
+}
+
+func zsetOpStore(op internal.SetOp) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return h.handleZSetOpStore(command, op, args, client.writer)
+	}
+}
+
+func zrank(rev bool) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return h.handleZRank(command, rev, args, client.writer)
+	}
+}
+
+func zpop(max bool) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return h.handleZPop(command, max, args, client.writer)
+	}
+}
+
+func bzpop(max bool) commandFunc {
+	return func(h *RESPHandler, command string, args []Value, client *Client) error {
+		return h.handleBZPop(command, max, args, client)
+	}
+}
+
+// fullSync is SYNC, a PSYNC asking for a full resynchronization.
+func fullSync(h *RESPHandler, _ string, _ []Value, client *Client) error {
+	return h.handlePsync([]Value{{Type: BulkString, Bulk: []byte("?")}, {Type: BulkString, Bulk: []byte("-1")}}, client)
+}
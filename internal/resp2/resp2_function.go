@@ -0,0 +1,380 @@
+package resp2
+
+import (
+	"cago/internal"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	ERRFunctionNotFound = "ERR Function not found"
+	ERRLibraryNotFound  = "ERR Library not found"
+	ERRFunctionRO       = "ERR Can not execute a script with write flag using *_ro command."
+)
+
+// functionFlags are the flags redis.register_function accepts. Only
+// no-writes changes anything here: a function without it cannot be called
+// with FCALL_RO nor while writes are refused.
+var functionFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+// functionLibrary is a library of functions loaded with FUNCTION LOAD, kept
+// with its source so it can be stored and loaded again.
+type functionLibrary struct {
+	name      string
+	code      string
+	functions map[string]*luaFunction
+}
+
+// luaFunction is a function registered by a library.
+type luaFunction struct {
+	name        string
+	description string
+	flags       []string
+	fn          *lua.LFunction
+}
+
+func (f *luaFunction) noWrites() bool {
+	return slices.Contains(f.flags, "no-writes")
+}
+
+// register runs the library in code, which must start with a
+// "#!lua name=<library>" line, and returns the functions it registered. The
+// engine keeps using its current libraries until they are committed. The
+// caller must hold mu.
+func (e *scriptEngine) register(code string) (*functionLibrary, error) {
+	name, body, err := parseLibraryHeader(code)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(body), "user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %s", oneLine(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %s", oneLine(err.Error()))
+	}
+
+	lib := &functionLibrary{name: name, code: code, functions: make(map[string]*luaFunction)}
+	e.loading = lib
+	defer func() { e.loading = nil }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeLimit)
+	defer cancel()
+
+	L := e.L
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	err = L.PCall(0, 0, nil)
+	L.RemoveContext()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ERR FUNCTION LOAD timeout")
+		}
+		return nil, fmt.Errorf("ERR Error registering functions: %s", strings.TrimPrefix(scriptError(err), "ERR "))
+	}
+	if len(lib.functions) == 0 {
+		return nil, fmt.Errorf("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// parseLibraryHeader splits the "#!<engine> name=<library>" line off code.
+// The line is replaced by an empty one so errors keep their line numbers.
+func parseLibraryHeader(code string) (string, string, error) {
+	header, body, found := strings.Cut(code, "\n")
+	if !strings.HasPrefix(header, "#!") {
+		return "", "", fmt.Errorf("ERR Missing library metadata")
+	}
+	if found {
+		body = "\n" + body
+	}
+
+	fields := strings.Fields(header[2:])
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("ERR Engine '' not found")
+	}
+	if !strings.EqualFold(fields[0], "lua") {
+		return "", "", fmt.Errorf("ERR Engine '%s' not found", fields[0])
+	}
+
+	name := ""
+	for _, field := range fields[1:] {
+		val, ok := strings.CutPrefix(field, "name=")
+		if !ok {
+			return "", "", fmt.Errorf("ERR Invalid metadata value given: %s", field)
+		}
+		name = val
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("ERR Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", "", fmt.Errorf("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, body, nil
+}
+
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// registerFunction implements redis.register_function, called either with
+// a name and a callback or with a table of function_name, callback, flags
+// and description. It only works while a library loads.
+func (e *scriptEngine) registerFunction(L *lua.LState) int {
+	lib := e.loading
+	if lib == nil {
+		L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+		return 0
+	}
+
+	f := &luaFunction{}
+	switch L.GetTop() {
+	case 1:
+		var bad string
+		L.CheckTable(1).ForEach(func(key, val lua.LValue) {
+			switch key.String() {
+			case "function_name":
+				f.name = lua.LVAsString(val)
+			case "callback":
+				f.fn, _ = val.(*lua.LFunction)
+			case "description":
+				f.description = lua.LVAsString(val)
+			case "flags":
+				flags, ok := val.(*lua.LTable)
+				if !ok {
+					bad = "flags argument to redis.register_function must be a table representing function flags"
+					return
+				}
+				flags.ForEach(func(_, flag lua.LValue) {
+					if !functionFlags[flag.String()] {
+						bad = "unknown flag given"
+						return
+					}
+					f.flags = append(f.flags, flag.String())
+				})
+			default:
+				bad = "unknown argument given to redis.register_function"
+			}
+		})
+		if bad != "" {
+			L.RaiseError("%s", bad)
+		}
+	case 2:
+		f.name = L.CheckString(1)
+		f.fn = L.CheckFunction(2)
+	default:
+		L.RaiseError("wrong number of arguments to redis.register_function")
+	}
+
+	if !validFunctionName(f.name) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if f.fn == nil {
+		L.RaiseError("redis.register_function must get a callback argument")
+	}
+	if _, ok := lib.functions[f.name]; ok {
+		L.RaiseError("Function already exists in the library")
+	}
+	lib.functions[f.name] = f
+	return 0
+}
+
+// boot loads the libraries stored on disk. A library that no longer loads
+// is reported and left out.
+func (e *scriptEngine) boot(stored []internal.FunctionLibrary) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, s := range stored {
+		lib, err := e.register(s.Code)
+		if err == nil {
+			err = addLibrary(e.libraries, lib, false)
+		}
+		if err != nil {
+			fmt.Printf("Function library %s failed to load: %v\n", s.Name, err)
+		}
+	}
+	e.functions = indexFunctions(e.libraries)
+}
+
+// loadLibrary registers the library in code, in place of the one with the
+// same name when replace is set, and returns its name.
+func (e *scriptEngine) loadLibrary(code string, replace bool, save functionSaver) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lib, err := e.register(code)
+	if err != nil {
+		return "", err
+	}
+
+	libs := maps.Clone(e.libraries)
+	if err := addLibrary(libs, lib, replace); err != nil {
+		return "", err
+	}
+	return lib.name, e.commit(libs, save)
+}
+
+// deleteLibrary removes the library called name and its functions.
+func (e *scriptEngine) deleteLibrary(name string, save functionSaver) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.libraries[name]; !ok {
+		return fmt.Errorf("%s", ERRLibraryNotFound)
+	}
+
+	libs := maps.Clone(e.libraries)
+	delete(libs, name)
+	return e.commit(libs, save)
+}
+
+// restoreLibraries loads the libraries of a FUNCTION DUMP payload. mode is
+// FLUSH to drop the current libraries first, APPEND to fail on any library
+// loaded already, or REPLACE to take their place.
+func (e *scriptEngine) restoreLibraries(stored []internal.FunctionLibrary, mode string, save functionSaver) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	libs := make(map[string]*functionLibrary)
+	if mode != "FLUSH" {
+		libs = maps.Clone(e.libraries)
+	}
+	for _, s := range stored {
+		lib, err := e.register(s.Code)
+		if err != nil {
+			return err
+		}
+		if err := addLibrary(libs, lib, mode == "REPLACE"); err != nil {
+			return err
+		}
+	}
+	return e.commit(libs, save)
+}
+
+// flushLibraries removes every library.
+func (e *scriptEngine) flushLibraries(save functionSaver) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.commit(make(map[string]*functionLibrary), save)
+}
+
+// functionSaver stores the libraries of the engine, sorted by name.
+type functionSaver func([]internal.FunctionLibrary) error
+
+// commit stores libs and makes them the libraries of the engine. When they
+// cannot be stored, the engine keeps the ones it had. The caller must hold
+// mu.
+func (e *scriptEngine) commit(libs map[string]*functionLibrary, save functionSaver) error {
+	if err := save(storedLibraries(libs)); err != nil {
+		return fmt.Errorf("ERR Error saving functions: %v", err)
+	}
+
+	e.libraries = libs
+	e.functions = indexFunctions(libs)
+	return nil
+}
+
+// libraryList returns the libraries of the engine sorted by name.
+func (e *scriptEngine) libraryList() []*functionLibrary {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	libs := make([]*functionLibrary, 0, len(e.libraries))
+	for _, lib := range e.libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+	return libs
+}
+
+// fcall calls the function called name with the keys and args tables.
+// Functions that may write are refused by FCALL_RO, when readOnlyCall is
+// set, and with the writeErr reply while writes are refused.
+func (e *scriptEngine) fcall(name string, keys, argv [][]byte, readOnlyCall bool, writeErr string, call scriptCaller, writer *RESPWriter) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f := e.functions[name]
+	if f == nil {
+		return writer.WriteError(ERRFunctionNotFound)
+	}
+	if !f.noWrites() {
+		if readOnlyCall {
+			return writer.WriteError(ERRFunctionRO)
+		}
+		if writeErr != "" {
+			return writer.WriteError(writeErr)
+		}
+	}
+
+	L := e.L
+	return e.exec(f.fn, []lua.LValue{luaStrings(L, keys), luaStrings(L, argv)}, f.noWrites(), call, writer)
+}
+
+// addLibrary adds lib to libs unless a function of another library has
+// the name of one of its own.
+func addLibrary(libs map[string]*functionLibrary, lib *functionLibrary, replace bool) error {
+	if _, ok := libs[lib.name]; ok && !replace {
+		return fmt.Errorf("ERR Library '%s' already exists", lib.name)
+	}
+	for name, other := range libs {
+		if name == lib.name {
+			continue
+		}
+		for fname := range lib.functions {
+			if _, ok := other.functions[fname]; ok {
+				return fmt.Errorf("ERR Function %s already exists", fname)
+			}
+		}
+	}
+
+	libs[lib.name] = lib
+	return nil
+}
+
+func indexFunctions(libs map[string]*functionLibrary) map[string]*luaFunction {
+	functions := make(map[string]*luaFunction)
+	for _, lib := range libs {
+		for name, f := range lib.functions {
+			functions[name] = f
+		}
+	}
+	return functions
+}
+
+func storedLibraries(libs map[string]*functionLibrary) []internal.FunctionLibrary {
+	stored := make([]internal.FunctionLibrary, 0, len(libs))
+	for _, lib := range libs {
+		stored = append(stored, internal.FunctionLibrary{Name: lib.name, Code: lib.code})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Name < stored[j].Name })
+	return stored
+}
@@ -3,6 +3,7 @@ package resp2
 import (
 	"cago/internal"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -14,16 +15,30 @@ const (
 )
 
 type RESPHandler struct {
-	cachesrv *internal.CacheService
+	cfg         *internal.Config
+	cachesrv    *internal.CacheService
+	replication *Replication
+	scripts     *scriptEngine
 }
 
-func NewRESPHandler(cachesrv *internal.CacheService) *RESPHandler {
+func NewRESPHandler(cfg *internal.Config, cachesrv *internal.CacheService, replication *Replication) *RESPHandler {
+	scripts := newScriptEngine(cfg.LuaTimeLimit)
+	scripts.boot(cachesrv.FunctionLibraries())
+	cachesrv.UseFunctionLoader(func(libs []internal.FunctionLibrary, save func([]internal.FunctionLibrary) error) error {
+		return scripts.restoreLibraries(libs, "FLUSH", save)
+	})
+
 	return &RESPHandler{
-		cachesrv: cachesrv,
+		cfg:         cfg,
+		cachesrv:    cachesrv,
+		replication: replication,
+		scripts:     scripts,
 	}
 }
 
-func (h *RESPHandler) HandleCommand(cmd *Value, writer *RESPWriter) error {
+func (h *RESPHandler) HandleCommand(cmd *Value, client *Client) error {
+	writer := client.writer
+
 	if cmd.Type != Array || len(cmd.Array) == 0 {
 		return writer.WriteError("ERR invalid command format")
 	}
@@ -32,29 +47,34 @@ func (h *RESPHandler) HandleCommand(cmd *Value, writer *RESPWriter) error {
 		return writer.WriteError("ERR command must be a bulk string")
 	}
 
-	command := strings.ToUpper(cmd.Array[0].Bulk)
+	command := strings.ToUpper(string(cmd.Array[0].Bulk))
 	args := cmd.Array[1:]
 
-	switch command {
-	case "PING":
-		return h.handlePing(args, writer)
-	case "SET":
-		return h.handleSet(args, writer)
-	case "GET":
-		return h.handleGet(args, writer)
-	case "DEL":
-		return h.handleDel(args, writer)
-	case "EXISTS":
-		return h.handleExists(args, writer)
-	case "EXPIRE":
-		return h.handleExpire(args, writer)
-	case "TTL":
-		return h.handleTTL(args, writer)
-	case "KEYS":
-		return h.handleKeys(args, writer)
-	default:
-		return writer.WriteError(fmt.Sprintf("ERR unknown command '%s'", command))
+	if h.scripts.busy() && !scriptKill(command, args) {
+		return writer.WriteError(ERRBusy)
+	}
+
+	spec := commands[command]
+	if client.multi != nil && (spec == nil || !spec.is(flagTransaction)) {
+		return h.queueCommand(command, args, client)
+	}
+
+	if spec != nil && !spec.is(flagUngated) {
+		unlock := shared(h.cachesrv, spec, args)
+		defer unlock()
 	}
+
+	return h.dispatch(command, args, client)
+}
+
+// dispatch runs command once it passed the checks of HandleCommand.
+func (h *RESPHandler) dispatch(command string, args []Value, client *Client) error {
+	cmd := commands[command]
+	if cmd == nil {
+		return client.writer.WriteError(fmt.Sprintf("ERR unknown command '%s'", command))
+	}
+
+	return cmd.run(h, command, args, client)
 }
 
 // RESP: *1\r\n$4\r\nPING\r\n
@@ -62,23 +82,44 @@ func (h *RESPHandler) HandleCommand(cmd *Value, writer *RESPWriter) error {
 // Pattern: PING [message]
 // Example: PING → PONG
 // Example: PING "hello" → "hello"
-func (h *RESPHandler) handlePing(args []Value, writer *RESPWriter) error {
-	if len(args) == 0 {
-		return writer.WriteSimpleString("PONG")
-	}
+// Returns: in subscribe mode over RESP2, ["pong", message] with an empty
+// message by default
+func (h *RESPHandler) handlePing(args []Value, client *Client) error {
+	writer := client.writer
 
-	if args[0].Type != BulkString {
+	if len(args) > 0 && args[0].Type != BulkString {
 		return writer.WriteError(ERRWrongArgumentType)
 	}
 
-	return writer.WriteBulkString(args[0].Bulk)
+	if client.subscribed() && writer.Protocol() == 2 {
+		if err := writer.WriteArray(2); err != nil {
+			return err
+		}
+		if err := writer.WriteBulkString("pong"); err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return writer.WriteBulkString("")
+		}
+		return writer.WriteBulk(args[0].Bulk)
+	}
+
+	if len(args) == 0 {
+		return writer.WriteSimpleString("PONG")
+	}
+
+	return writer.WriteBulk(args[0].Bulk)
 }
 
 // RESP: *3\r\n$3\r\nSET\r\n$5\r\nmykey\r\n$5\r\nhello\r\n
 // RESP: *5\r\n$3\r\nSET\r\n$5\r\nmykey\r\n$5\r\nhello\r\n$2\r\nEX\r\n$2\r\n10\r\n
-// Pattern: SET key value [EX seconds]
+// Pattern: SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT unix-seconds|PXAT unix-milliseconds|KEEPTTL]
 // Example: SET mykey "hello" → OK
 // Example: SET mykey "hello" EX 10 → OK (expires in 10s)
+// Example: SET lock "owner" NX PX 30000 → (nil) (already locked)
+// Example: SET mykey "world" KEEPTTL GET → "hello"
+// Returns: OK, or null when NX or XX prevented the write. With GET the
+// previous value, or null when there was none
 func (h *RESPHandler) handleSet(args []Value, writer *RESPWriter) error {
 	if len(args) < 2 {
 		return writer.WriteError("ERR wrong number of arguments for 'SET' command")
@@ -88,41 +129,84 @@ func (h *RESPHandler) handleSet(args []Value, writer *RESPWriter) error {
 		return writer.WriteError(ERRWrongArgumentType)
 	}
 
-	key := args[0].Bulk
+	key := string(args[0].Bulk)
 	value := args[1].Bulk
+	var opts internal.SetOptions
 	var ttl time.Duration
+	expiry := ""
 
 	for i := 2; i < len(args); i++ {
 		if args[i].Type != BulkString {
 			return writer.WriteError(ERRSyntexError)
 		}
 
-		option := strings.ToUpper(args[i].Bulk)
+		option := strings.ToUpper(string(args[i].Bulk))
 
-		if option == "EX" {
-			if i+1 >= len(args) {
+		switch option {
+		case "NX", "XX":
+			if opts.Condition != internal.SetAlways {
 				return writer.WriteError(ERRSyntexError)
 			}
+			opts.Condition = internal.SetIfAbsent
+			if option == "XX" {
+				opts.Condition = internal.SetIfPresent
+			}
+		case "GET":
+			opts.Get = true
+		case "KEEPTTL":
+			if expiry != "" {
+				return writer.WriteError(ERRSyntexError)
+			}
+			expiry = option
+			opts.KeepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiry != "" || i+1 >= len(args) {
+				return writer.WriteError(ERRSyntexError)
+			}
+			expiry = option
 
 			if args[i+1].Type != BulkString {
-				return writer.WriteError("ERR value is not an integer or out of range")
+				return writer.WriteError(ERRNotInteger)
 			}
 
-			seconds, err := strconv.Atoi(args[i+1].Bulk)
+			n, err := strconv.ParseInt(string(args[i+1].Bulk), 10, 64)
 			if err != nil {
-				return writer.WriteError("ERR value is not an integer or out of range")
+				return writer.WriteError(ERRNotInteger)
 			}
 
-			ttl = time.Duration(seconds) * time.Second
+			unit := time.Millisecond
+			if option == "EX" || option == "EXAT" {
+				unit = time.Second
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				return writer.WriteError("ERR invalid expire time in 'set' command")
+			}
+
+			if option == "EX" || option == "PX" {
+				ttl = time.Duration(n) * unit
+			} else {
+				opts.ExpiresAt = time.Unix(0, n*int64(unit)).UTC()
+			}
 			i++
+		default:
+			return writer.WriteError(ERRSyntexError)
 		}
 	}
 
-	err := h.cachesrv.Set(key, value, ttl)
+	old, existed, written, err := h.cachesrv.SetWithOptions(key, value, "", ttl, opts)
 	if err != nil {
 		return writer.WriteError(formatError(err))
 	}
 
+	if opts.Get {
+		if !existed {
+			return writer.WriteNull()
+		}
+		return writer.WriteBulk(old)
+	}
+	if !written {
+		return writer.WriteNull()
+	}
 	return writer.WriteSimpleString("OK")
 }
 
@@ -139,7 +223,7 @@ func (h *RESPHandler) handleGet(args []Value, writer *RESPWriter) error {
 		return writer.WriteError("ERR wrong argument type")
 	}
 
-	key := args[0].Bulk
+	key := string(args[0].Bulk)
 	value, exists, err := h.cachesrv.Get(key)
 	if err != nil {
 		return writer.WriteError(formatError(err))
@@ -147,7 +231,7 @@ func (h *RESPHandler) handleGet(args []Value, writer *RESPWriter) error {
 	if !exists {
 		return writer.WriteNull()
 	}
-	return writer.WriteBulkString(value)
+	return writer.WriteBulk(value)
 }
 
 // RESP: *2\r\n$3\r\nDEL\r\n$4\r\nkey1\r\n
@@ -168,7 +252,7 @@ func (h *RESPHandler) handleDel(args []Value, writer *RESPWriter) error {
 			return writer.WriteError(ERRWrongArgumentType)
 		}
 
-		success, err := h.cachesrv.Delete(arg.Bulk)
+		success, err := h.cachesrv.Delete(string(arg.Bulk))
 		if err != nil {
 			return writer.WriteError(formatError(err))
 		}
@@ -194,11 +278,11 @@ func (h *RESPHandler) handleExists(args []Value, writer *RESPWriter) error {
 
 	count := int64(0)
 	for _, arg := range args {
-		if arg.Type != BulkString{
+		if arg.Type != BulkString {
 			return writer.WriteError(ERRWrongArgumentType)
 		}
 
-		exists, err := h.cachesrv.Exists(arg.Bulk)
+		exists, err := h.cachesrv.Exists(string(arg.Bulk))
 		if err != nil {
 			return writer.WriteError(formatError(err))
 		}
@@ -212,67 +296,207 @@ func (h *RESPHandler) handleExists(args []Value, writer *RESPWriter) error {
 }
 
 // RESP: *3\r\n$6\r\nEXPIRE\r\n$5\r\nmykey\r\n$2\r\n10\r\n
-// Pattern: EXPIRE key seconds
+// Pattern: EXPIRE key seconds [NX|XX|GT|LT]
+// Pattern: PEXPIREAT key unix-milliseconds [NX|XX|GT|LT]
 // Example: EXPIRE mykey 10 → 1 (TTL set)
 // Example: EXPIRE nonexistent 10 → 0 (key doesn't exist)
-// Returns: 1 if TTL was set, 0 if key doesn't exist
-func (h *RESPHandler) handleExpire(args []Value, writer *RESPWriter) error {
-	if len(args) != 2 {
-		return writer.WriteError("ERR wrong number of arguments for 'EXPIRE' command")
+// Example: PEXPIRE mykey 1500 GT → 0 (only a later expiry is accepted)
+// Example: EXPIRE mykey 0 → 1 (key deleted)
+// Returns: 1 if TTL was set, 0 if key doesn't exist or the condition was not
+// met. PEXPIRE takes milliseconds, EXPIREAT and PEXPIREAT a unix time, and
+// a time that is not in the future deletes the key. NX only sets a TTL on
+// keys without one, XX only changes an existing TTL, GT and LT only move it
+// later or sooner, a key without TTL counting as never expiring
+func (h *RESPHandler) handleExpire(command string, args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs(command))
 	}
 
-	if args[0].Type != BulkString || args[1].Type != BulkString {
+	if !allBulk(args) {
 		return writer.WriteError(ERRWrongArgumentType)
 	}
 
-	key := args[0].Bulk
-	seconds, err :=strconv.Atoi(args[1].Bulk)
+	key := string(args[0].Bulk)
+	n, err := strconv.ParseInt(string(args[1].Bulk), 10, 64)
 	if err != nil {
 		return writer.WriteError("ERR value is not an integer or out of range")
 	}
 
-	ttl := time.Duration(seconds) * time.Second
-	err = h.cachesrv.Expire(key, ttl)
+	cond := internal.ExpireAlways
+	for _, arg := range args[2:] {
+		switch strings.ToUpper(string(arg.Bulk)) {
+		case "NX":
+			cond |= internal.ExpireIfNone
+		case "XX":
+			cond |= internal.ExpireIfSet
+		case "GT":
+			cond |= internal.ExpireIfLater
+		case "LT":
+			cond |= internal.ExpireIfSooner
+		default:
+			return writer.WriteError(fmt.Sprintf("ERR Unsupported option %s", arg.Bulk))
+		}
+	}
+	if cond&internal.ExpireIfNone != 0 && cond != internal.ExpireIfNone {
+		return writer.WriteError("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if cond&internal.ExpireIfLater != 0 && cond&internal.ExpireIfSooner != 0 {
+		return writer.WriteError("ERR GT and LT options at the same time are not compatible")
+	}
+
+	seconds := command == "EXPIRE" || command == "EXPIREAT"
+	relative := command == "EXPIRE" || command == "PEXPIRE"
+	ms, ok := expireMillis(n, seconds, relative)
+	if !ok {
+		return writer.WriteError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(command)))
+	}
+
+	changed, err := h.cachesrv.ExpireAt(key, time.UnixMilli(ms).UTC(), cond)
 	if err != nil {
-		if err == internal.ErrKeyNotFound {
-			return writer.WriteInteger(0)
+		return writer.WriteError(formatError(err))
+	}
+
+	if changed {
+		return writer.WriteInteger(1)
+	}
+	return writer.WriteInteger(0)
+}
+
+// expireMillis converts a time argument in seconds or milliseconds, and
+// relative to now when relative, to a unix time in milliseconds. It
+// reports false when that overflows.
+func expireMillis(n int64, seconds, relative bool) (int64, bool) {
+	if seconds {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+
+	if relative {
+		now := time.Now().UnixMilli()
+		if n > math.MaxInt64-now {
+			return 0, false
 		}
+		n += now
+	}
+	return n, true
+}
+
+// RESP: *2\r\n$7\r\nPERSIST\r\n$5\r\nmykey\r\n
+// Pattern: PERSIST key
+// Example: PERSIST mykey → 1 (TTL removed)
+// Example: PERSIST persistkey → 0 (no TTL to remove)
+func (h *RESPHandler) handlePersist(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("PERSIST"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	persisted, err := h.cachesrv.Persist(string(args[0].Bulk))
+	if err != nil {
 		return writer.WriteError(formatError(err))
 	}
 
-	return writer.WriteInteger(1)
+	if persisted {
+		return writer.WriteInteger(1)
+	}
+	return writer.WriteInteger(0)
 }
 
 // RESP: *2\r\n$3\r\nTTL\r\n$5\r\nmykey\r\n
 // Pattern: TTL key
 // Example: TTL mykey → 10 (10 seconds remaining)
+// Example: PTTL mykey → 9998 (in milliseconds)
 // Example: TTL nonexistent → -2 (key doesn't exist)
 // Example: TTL persistkey → -1 (key exists but has no TTL)
-// Returns: TTL in seconds, -2 if key doesn't exist, -1 if no TTL
-func (h *RESPHandler) handleTTL(args []Value, writer *RESPWriter) error {
+// Returns: TTL in seconds, rounded, or in milliseconds for PTTL, -2 if key
+// doesn't exist, -1 if no TTL
+func (h *RESPHandler) handleTTL(command string, args []Value, writer *RESPWriter) error {
 	if len(args) != 1 {
-		return writer.WriteError("ERR wrong number of arguments for 'TTL' command")
+		return writer.WriteError(wrongArgs(command))
 	}
 
 	if args[0].Type != BulkString {
 		return writer.WriteError(ERRWrongArgumentType)
 	}
 
-	key := args[0].Bulk
+	key := string(args[0].Bulk)
 	ttl, err := h.cachesrv.TTL(key)
 	if err != nil {
 		return writer.WriteError(formatError(err))
 	}
 
-	if ttl == -2 * time.Second {
+	if ttl == -2*time.Second {
+		return writer.WriteInteger(-2)
+	}
+	if ttl == -1*time.Second {
+		return writer.WriteInteger(-1)
+	}
+
+	ms := max(ttl.Milliseconds(), 0)
+	if command == "PTTL" {
+		return writer.WriteInteger(ms)
+	}
+	return writer.WriteInteger((ms + 500) / 1000)
+}
+
+// RESP: *2\r\n$10\r\nEXPIRETIME\r\n$5\r\nmykey\r\n
+// Pattern: EXPIRETIME key
+// Example: EXPIRETIME mykey → 1767225600
+// Example: PEXPIRETIME mykey → 1767225600000
+// Returns: the unix time at which the key expires, in milliseconds for
+// PEXPIRETIME, -2 if key doesn't exist, -1 if no TTL
+func (h *RESPHandler) handleExpireTime(command string, args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	expiresAt, exists, err := h.cachesrv.ExpireTime(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if !exists {
 		return writer.WriteInteger(-2)
 	}
-	if ttl == -1 * time.Second {
+	if expiresAt.IsZero() {
 		return writer.WriteInteger(-1)
 	}
 
-	seconds := int64(ttl.Seconds())
-	return writer.WriteInteger(seconds)
+	if command == "PEXPIRETIME" {
+		return writer.WriteInteger(expiresAt.UnixMilli())
+	}
+	return writer.WriteInteger(expiresAt.Unix())
+}
+
+// RESP: *2\r\n$4\r\nTYPE\r\n$5\r\nmykey\r\n
+// Pattern: TYPE key
+// Example: TYPE mykey → string
+// Example: TYPE nonexistent → none
+// Returns: type of the value stored at key
+func (h *RESPHandler) handleType(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("TYPE"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	typ, err := h.cachesrv.Type(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteSimpleString(typ)
 }
 
 // RESP: *2\r\n$4\r\nKEYS\r\n$1\r\n*\r\n
@@ -291,7 +515,7 @@ func (h *RESPHandler) handleKeys(args []Value, writer *RESPWriter) error {
 		return writer.WriteError(ERRWrongArgumentType)
 	}
 
-	pattern := args[0].Bulk
+	pattern := string(args[0].Bulk)
 	keys, err := h.cachesrv.Keys(pattern)
 	if err != nil {
 		return writer.WriteError(formatError(err))
@@ -310,6 +534,223 @@ func (h *RESPHandler) handleKeys(args []Value, writer *RESPWriter) error {
 	return nil
 }
 
+// RESP: *2\r\n$4\r\nSCAN\r\n$1\r\n0\r\n
+// Pattern: SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// Example: SCAN 0 MATCH user:* COUNT 100 → ["1729382256910270464", ["user:1", "user:7"]]
+// Example: SCAN 0 TYPE hash → ["0", ["profile"]]
+// Returns: next cursor, 0 when done, and the keys of this page. Every key
+// present for the whole iteration is returned at least once.
+func (h *RESPHandler) handleScan(args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs("SCAN"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	cursor, err := strconv.ParseUint(string(args[0].Bulk), 10, 64)
+	if err != nil {
+		return writer.WriteError("ERR invalid cursor")
+	}
+
+	opts, errMsg := parseScanOptions(args[1:])
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+	if opts.noValues {
+		return writer.WriteError(ERRSyntexError)
+	}
+
+	keys, next, err := h.cachesrv.Scan(cursor, opts.match, opts.count, opts.typ)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(2); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(strconv.FormatUint(next, 10)); err != nil {
+		return err
+	}
+	return writeStrings(writer, keys)
+}
+
+// RESP: *3\r\n$6\r\nMEMORY\r\n$5\r\nUSAGE\r\n$5\r\nmykey\r\n
+// RESP: *2\r\n$6\r\nMEMORY\r\n$5\r\nSTATS\r\n
+// Pattern: MEMORY USAGE key [SAMPLES count] | MEMORY STATS
+// Example: MEMORY USAGE mykey → 74 (approximate bytes)
+// Example: MEMORY USAGE nonexistent → (nil)
+// Example: MEMORY STATS → ["peak.allocated", 1024, "total.allocated", 512, ...]
+// Returns: bytes used by key, or a map of memory counters
+func (h *RESPHandler) handleMemory(args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError("ERR wrong number of arguments for 'MEMORY' command")
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	switch strings.ToUpper(string(args[0].Bulk)) {
+	case "USAGE":
+		// SAMPLES is accepted for compatibility; usage is tracked exactly.
+		if len(args) != 2 && len(args) != 4 {
+			return writer.WriteError(ERRSyntexError)
+		}
+
+		if !allBulk(args[1:]) {
+			return writer.WriteError(ERRWrongArgumentType)
+		}
+
+		if len(args) == 4 {
+			if !strings.EqualFold(string(args[2].Bulk), "SAMPLES") {
+				return writer.WriteError(ERRSyntexError)
+			}
+			if _, err := strconv.ParseInt(string(args[3].Bulk), 10, 64); err != nil {
+				return writer.WriteError(ERRSyntexError)
+			}
+		}
+
+		usage, exists, err := h.cachesrv.MemoryUsage(string(args[1].Bulk))
+		if err != nil {
+			return writer.WriteError(formatError(err))
+		}
+		if !exists {
+			return writer.WriteNull()
+		}
+		return writer.WriteInteger(usage)
+	case "STATS":
+		if len(args) != 1 {
+			return writer.WriteError("ERR wrong number of arguments for 'MEMORY|STATS' command")
+		}
+
+		stats := h.cachesrv.MemoryStats()
+		bytesPerKey := int64(0)
+		if stats.KeyCount > 0 {
+			bytesPerKey = stats.DatasetBytes / int64(stats.KeyCount)
+		}
+
+		counters := []struct {
+			name  string
+			value int64
+		}{
+			{"peak.allocated", stats.PeakMemory},
+			{"total.allocated", stats.UsedMemory},
+			{"overhead.total", stats.OverheadBytes},
+			{"dataset.bytes", stats.DatasetBytes},
+			{"keys.count", int64(stats.KeyCount)},
+			{"keys.bytes-per-key", bytesPerKey},
+			{"evicted.keys", stats.EvictedKeys},
+			{"maxmemory", stats.MaxMemory},
+		}
+
+		if err := writer.WriteMap(len(counters) + 1); err != nil {
+			return err
+		}
+		for _, c := range counters {
+			if err := writer.WriteBulkString(c.name); err != nil {
+				return err
+			}
+			if err := writer.WriteInteger(c.value); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteBulkString("maxmemory-policy"); err != nil {
+			return err
+		}
+		return writer.WriteBulkString(string(stats.EvictionPolicy))
+	default:
+		return writer.WriteError(fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk))
+	}
+}
+
+// RESP: *1\r\n$4\r\nSAVE\r\n
+// Pattern: SAVE
+// Example: SAVE → OK (snapshot written before replying)
+func (h *RESPHandler) handleSave(args []Value, writer *RESPWriter) error {
+	if len(args) != 0 {
+		return writer.WriteError("ERR wrong number of arguments for 'SAVE' command")
+	}
+
+	if err := h.cachesrv.Save(); err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *1\r\n$6\r\nBGSAVE\r\n
+// Pattern: BGSAVE [SCHEDULE]
+// Example: BGSAVE → Background saving started
+func (h *RESPHandler) handleBgSave(args []Value, writer *RESPWriter) error {
+	if len(args) > 1 {
+		return writer.WriteError("ERR wrong number of arguments for 'BGSAVE' command")
+	}
+
+	if err := h.cachesrv.BackgroundSave(); err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteSimpleString("Background saving started")
+}
+
+// RESP: *1\r\n$8\r\nLASTSAVE\r\n
+// Pattern: LASTSAVE
+// Example: LASTSAVE → 1700000000
+// Returns: Unix time of the last successful save
+func (h *RESPHandler) handleLastSave(args []Value, writer *RESPWriter) error {
+	if len(args) != 0 {
+		return writer.WriteError("ERR wrong number of arguments for 'LASTSAVE' command")
+	}
+
+	lastSave, err := h.cachesrv.LastSave()
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(lastSave.Unix())
+}
+
+// RESP: *1\r\n$12\r\nBGREWRITEAOF\r\n
+// Pattern: BGREWRITEAOF
+// Example: BGREWRITEAOF → Background append only file rewriting started
+func (h *RESPHandler) handleBgRewriteAOF(args []Value, writer *RESPWriter) error {
+	if len(args) != 0 {
+		return writer.WriteError("ERR wrong number of arguments for 'BGREWRITEAOF' command")
+	}
+
+	if err := h.cachesrv.RewriteAppendOnly(); err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteSimpleString("Background append only file rewriting started")
+}
+
 func formatError(err error) string {
+	if err == internal.ErrOutOfMemory {
+		return fmt.Sprintf("OOM %s", err.Error())
+	}
+	if err == internal.ErrWrongType {
+		return fmt.Sprintf("WRONGTYPE %s", err.Error())
+	}
+	if err == internal.ErrReadOnly {
+		return fmt.Sprintf("READONLY %s", err.Error())
+	}
 	return fmt.Sprintf("ERR %s", err.Error())
 }
+
+func wrongArgs(command string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", command)
+}
+
+// allBulk reports whether every argument is a bulk string, as commands are
+// always sent.
+func allBulk(args []Value) bool {
+	for _, arg := range args {
+		if arg.Type != BulkString {
+			return false
+		}
+	}
+	return true
+}
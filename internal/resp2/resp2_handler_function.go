@@ -0,0 +1,287 @@
+package resp2
+
+import (
+	"cago/internal"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RESP: *4\r\n$5\r\nFCALL\r\n$5\r\nhello\r\n$1\r\n1\r\n$6\r\nuser:1\r\n
+// Pattern: FCALL function numkeys [key ...] [arg ...]
+// Pattern: FCALL_RO function numkeys [key ...] [arg ...]
+// Example: FCALL hello 1 user:1 → "alice"
+// Returns: the value returned by the function, converted like the value of
+// EVAL. The function gets the keys and the arguments as two tables and runs
+// without any other client touching its keys in between; keys it uses but
+// was not given are not protected. FCALL_RO and read-only replicas only run
+// functions flagged no-writes
+func (h *RESPHandler) handleFcall(command string, args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	keys, argv, errMsg := scriptKeys(args[1:])
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+
+	writeErr := ""
+	if h.cachesrv.ReadOnly() {
+		writeErr = formatError(internal.ErrReadOnly)
+	}
+
+	name := string(args[0].Bulk)
+	return h.runScript(client, keys, func(call scriptCaller, writer *RESPWriter) error {
+		return h.scripts.fcall(name, keys, argv, command == "FCALL_RO", writeErr, call, writer)
+	})
+}
+
+// RESP: *3\r\n$8\r\nFUNCTION\r\n$4\r\nLOAD\r\n$74\r\n#!lua name=mylib\nredis.register_function('hello', function() return 1 end)\r\n
+// Pattern: FUNCTION LOAD [REPLACE] code | LIST [LIBRARYNAME pattern] [WITHCODE] |
+// DELETE library | DUMP | RESTORE payload [FLUSH|APPEND|REPLACE] |
+// FLUSH [ASYNC|SYNC] | KILL
+// Example: FUNCTION LOAD "#!lua name=mylib\n..." → "mylib"
+// Example: FUNCTION LIST → [{library_name: "mylib", engine: "LUA", functions: [...]}]
+// Example: FUNCTION DELETE mylib → OK
+// Returns: the name of the library loaded, the libraries with their
+// functions, a payload for RESTORE, or OK. Libraries are saved to
+// FunctionsFilename in the data directory before the command returns and
+// loaded again at startup. Every change is propagated to the append-only
+// log and to replicas as a RESTORE of all the libraries, and snapshots
+// carry them too. Read-only replicas refuse changes to them
+func (h *RESPHandler) handleFunction(args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs("FUNCTION"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	save := h.cachesrv.SaveFunctionLibraries
+
+	subcommand := strings.ToUpper(string(args[0].Bulk))
+	switch subcommand {
+	case "LOAD", "DELETE", "RESTORE", "FLUSH":
+		if h.cachesrv.ReadOnly() {
+			return writer.WriteError(formatError(internal.ErrReadOnly))
+		}
+	}
+
+	switch subcommand {
+	case "LOAD":
+		replace := len(args) == 3 && strings.EqualFold(string(args[1].Bulk), "REPLACE")
+		if len(args) != 2 && !replace {
+			if len(args) == 3 {
+				return writer.WriteError(fmt.Sprintf("ERR Unknown option given: %s", args[1].Bulk))
+			}
+			return writer.WriteError(wrongArgs("FUNCTION|LOAD"))
+		}
+
+		name, err := h.scripts.loadLibrary(string(args[len(args)-1].Bulk), replace, save)
+		if err != nil {
+			return writer.WriteError(err.Error())
+		}
+		return writer.WriteBulkString(name)
+	case "LIST":
+		return h.functionList(args[1:], writer)
+	case "DELETE":
+		if len(args) != 2 {
+			return writer.WriteError(wrongArgs("FUNCTION|DELETE"))
+		}
+
+		if err := h.scripts.deleteLibrary(string(args[1].Bulk), save); err != nil {
+			return writer.WriteError(err.Error())
+		}
+		return writer.WriteSimpleString("OK")
+	case "DUMP":
+		if len(args) != 1 {
+			return writer.WriteError(wrongArgs("FUNCTION|DUMP"))
+		}
+
+		libs := h.scripts.libraryList()
+		stored := make([]internal.FunctionLibrary, len(libs))
+		for i, lib := range libs {
+			stored[i] = internal.FunctionLibrary{Name: lib.name, Code: lib.code}
+		}
+		return writer.WriteBulk(internal.EncodeFunctions(stored))
+	case "RESTORE":
+		if len(args) != 2 && len(args) != 3 {
+			return writer.WriteError(wrongArgs("FUNCTION|RESTORE"))
+		}
+
+		mode := "APPEND"
+		if len(args) == 3 {
+			mode = strings.ToUpper(string(args[2].Bulk))
+			if mode != "FLUSH" && mode != "APPEND" && mode != "REPLACE" {
+				return writer.WriteError("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			}
+		}
+
+		stored, err := internal.DecodeFunctions(args[1].Bulk)
+		if err != nil {
+			return writer.WriteError("ERR payload version or checksum are wrong")
+		}
+		if err := h.scripts.restoreLibraries(stored, mode, save); err != nil {
+			return writer.WriteError(err.Error())
+		}
+		return writer.WriteSimpleString("OK")
+	case "FLUSH":
+		if len(args) > 2 {
+			return writer.WriteError(wrongArgs("FUNCTION|FLUSH"))
+		}
+		if len(args) == 2 {
+			if mode := strings.ToUpper(string(args[1].Bulk)); mode != "ASYNC" && mode != "SYNC" {
+				return writer.WriteError(ERRSyntexError)
+			}
+		}
+
+		if err := h.scripts.flushLibraries(save); err != nil {
+			return writer.WriteError(err.Error())
+		}
+		return writer.WriteSimpleString("OK")
+	case "KILL":
+		if len(args) != 1 {
+			return writer.WriteError(wrongArgs("FUNCTION|KILL"))
+		}
+
+		if errMsg := h.scripts.kill(); errMsg != "" {
+			return writer.WriteError(errMsg)
+		}
+		return writer.WriteSimpleString("OK")
+	default:
+		return writer.WriteError(fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk))
+	}
+}
+
+// functionList writes the libraries matching the LIBRARYNAME pattern, each
+// as a map of its name, engine, functions and, with WITHCODE, its code.
+func (h *RESPHandler) functionList(args []Value, writer *RESPWriter) error {
+	pattern := ""
+	withCode := false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Bulk)) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 == len(args) {
+				return writer.WriteError("ERR library name argument was not given")
+			}
+			i++
+			pattern = string(args[i].Bulk)
+		default:
+			return writer.WriteError(fmt.Sprintf("ERR Unknown argument %s", args[i].Bulk))
+		}
+	}
+
+	var libs []*functionLibrary
+	for _, lib := range h.scripts.libraryList() {
+		if pattern == "" || internal.MatchPattern(pattern, lib.name) {
+			libs = append(libs, lib)
+		}
+	}
+
+	if err := writer.WriteArray(len(libs)); err != nil {
+		return err
+	}
+	for _, lib := range libs {
+		if err := writeLibrary(writer, lib, withCode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLibrary(writer *RESPWriter, lib *functionLibrary, withCode bool) error {
+	fields := 3
+	if withCode {
+		fields++
+	}
+	if err := writer.WriteMap(fields); err != nil {
+		return err
+	}
+
+	if err := writer.WriteBulkString("library_name"); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(lib.name); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString("engine"); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString("LUA"); err != nil {
+		return err
+	}
+
+	if err := writer.WriteBulkString("functions"); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(lib.functions))
+	for name := range lib.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := writer.WriteArray(len(names)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := writeFunction(writer, lib.functions[name]); err != nil {
+			return err
+		}
+	}
+
+	if withCode {
+		if err := writer.WriteBulkString("library_code"); err != nil {
+			return err
+		}
+		if err := writer.WriteBulkString(lib.code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFunction(writer *RESPWriter, f *luaFunction) error {
+	if err := writer.WriteMap(3); err != nil {
+		return err
+	}
+
+	if err := writer.WriteBulkString("name"); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(f.name); err != nil {
+		return err
+	}
+
+	if err := writer.WriteBulkString("description"); err != nil {
+		return err
+	}
+	if f.description == "" {
+		if err := writer.WriteNull(); err != nil {
+			return err
+		}
+	} else if err := writer.WriteBulkString(f.description); err != nil {
+		return err
+	}
+
+	if err := writer.WriteBulkString("flags"); err != nil {
+		return err
+	}
+	if err := writer.WriteSet(len(f.flags)); err != nil {
+		return err
+	}
+	for _, flag := range f.flags {
+		if err := writer.WriteBulkString(flag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package resp2
+
+import (
+	"cago/internal"
+	"strconv"
+	"strings"
+)
+
+const (
+	ERRNotInteger = "ERR value is not an integer or out of range"
+	ERRNotFloat   = "ERR value is not a valid float"
+)
+
+// RESP: *4\r\n$4\r\nHSET\r\n$4\r\nuser\r\n$4\r\nname\r\n$3\r\nbob\r\n
+// Pattern: HSET key field value [field value ...]
+// Example: HSET user name "bob" age "42" → 2 (fields added)
+// Example: HSET user name "alice" → 0 (field updated)
+// Returns: number of fields that were added, HMSET replies OK instead
+func (h *RESPHandler) handleHSet(command string, args []Value, writer *RESPWriter) error {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	fields := make([]internal.HashField, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields = append(fields, internal.HashField{Field: string(args[i].Bulk), Value: args[i+1].Bulk})
+	}
+
+	added, err := h.cachesrv.HSet(string(args[0].Bulk), fields)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if command == "HMSET" {
+		return writer.WriteSimpleString("OK")
+	}
+	return writer.WriteInteger(int64(added))
+}
+
+// RESP: *4\r\n$6\r\nHSETNX\r\n$4\r\nuser\r\n$4\r\nname\r\n$3\r\nbob\r\n
+// Pattern: HSETNX key field value
+// Example: HSETNX user name "bob" → 1 (field set)
+// Example: HSETNX user name "alice" → 0 (field already exists)
+func (h *RESPHandler) handleHSetNX(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("HSETNX"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	added, err := h.cachesrv.HSetNX(string(args[0].Bulk), string(args[1].Bulk), args[2].Bulk)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if added {
+		return writer.WriteInteger(1)
+	}
+	return writer.WriteInteger(0)
+}
+
+// RESP: *3\r\n$4\r\nHGET\r\n$4\r\nuser\r\n$4\r\nname\r\n
+// Pattern: HGET key field
+// Example: HGET user name → "bob"
+// Example: HGET user nonexistent → (nil)
+func (h *RESPHandler) handleHGet(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("HGET"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	value, exists, err := h.cachesrv.HGet(string(args[0].Bulk), string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+	if !exists {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(value)
+}
+
+// RESP: *4\r\n$5\r\nHMGET\r\n$4\r\nuser\r\n$4\r\nname\r\n$3\r\nage\r\n
+// Pattern: HMGET key field [field ...]
+// Example: HMGET user name nonexistent → ["bob", (nil)]
+// Returns: values of the fields in the requested order
+func (h *RESPHandler) handleHMGet(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("HMGET"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	values, err := h.cachesrv.HMGet(string(args[0].Bulk), bulkStrings(args[1:]))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(len(values)); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if value == nil {
+			if err := writer.WriteNull(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writer.WriteBulk(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *3\r\n$4\r\nHDEL\r\n$4\r\nuser\r\n$4\r\nname\r\n
+// Pattern: HDEL key field [field ...]
+// Example: HDEL user name nonexistent → 1 (removed 1 out of 2)
+// Returns: number of fields removed, the key is removed with its last field
+func (h *RESPHandler) handleHDel(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("HDEL"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	removed, err := h.cachesrv.HDel(string(args[0].Bulk), bulkStrings(args[1:]))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(removed))
+}
+
+// RESP: *3\r\n$7\r\nHEXISTS\r\n$4\r\nuser\r\n$4\r\nname\r\n
+// Pattern: HEXISTS key field
+// Example: HEXISTS user name → 1
+// Example: HEXISTS user nonexistent → 0
+func (h *RESPHandler) handleHExists(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("HEXISTS"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	exists, err := h.cachesrv.HExists(string(args[0].Bulk), string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if exists {
+		return writer.WriteInteger(1)
+	}
+	return writer.WriteInteger(0)
+}
+
+// RESP: *2\r\n$4\r\nHLEN\r\n$4\r\nuser\r\n
+// Pattern: HLEN key
+// Example: HLEN user → 2
+// Example: HLEN nonexistent → 0
+func (h *RESPHandler) handleHLen(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("HLEN"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	length, err := h.cachesrv.HLen(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(length))
+}
+
+// RESP: *2\r\n$5\r\nHKEYS\r\n$4\r\nuser\r\n
+// Pattern: HKEYS key
+// Example: HKEYS user → ["name", "age"]
+func (h *RESPHandler) handleHKeys(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("HKEYS"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	keys, err := h.cachesrv.HKeys(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(len(keys)); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := writer.WriteBulkString(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *2\r\n$5\r\nHVALS\r\n$4\r\nuser\r\n
+// Pattern: HVALS key
+// Example: HVALS user → ["bob", "42"]
+func (h *RESPHandler) handleHVals(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("HVALS"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	values, err := h.cachesrv.HVals(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(len(values)); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := writer.WriteBulk(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *2\r\n$7\r\nHGETALL\r\n$4\r\nuser\r\n
+// Pattern: HGETALL key
+// Example: HGETALL user → {"name": "bob", "age": "42"}
+// Returns: map of fields to values, a flat field/value array in RESP2
+func (h *RESPHandler) handleHGetAll(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("HGETALL"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	fields, err := h.cachesrv.HGetAll(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteMap(len(fields)); err != nil {
+		return err
+	}
+	return writeHashFields(writer, fields)
+}
+
+// RESP: *4\r\n$7\r\nHINCRBY\r\n$4\r\nuser\r\n$3\r\nage\r\n$1\r\n1\r\n
+// Pattern: HINCRBY key field increment
+// Example: HINCRBY user age 1 → 43
+// Example: HINCRBY user name 1 → ERR hash value is not an integer
+func (h *RESPHandler) handleHIncrBy(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("HINCRBY"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	delta, err := strconv.ParseInt(string(args[2].Bulk), 10, 64)
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+
+	result, err := h.cachesrv.HIncrBy(string(args[0].Bulk), string(args[1].Bulk), delta)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(result)
+}
+
+// RESP: *4\r\n$12\r\nHINCRBYFLOAT\r\n$4\r\nuser\r\n$7\r\nbalance\r\n$3\r\n0.5\r\n
+// Pattern: HINCRBYFLOAT key field increment
+// Example: HINCRBYFLOAT user balance 0.5 → "10.5"
+// Returns: new value as a bulk string
+func (h *RESPHandler) handleHIncrByFloat(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("HINCRBYFLOAT"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	delta, ok := parseFloat(args[2].Bulk)
+	if !ok {
+		return writer.WriteError(ERRNotFloat)
+	}
+
+	result, err := h.cachesrv.HIncrByFloat(string(args[0].Bulk), string(args[1].Bulk), delta)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteBulk(result)
+}
+
+// RESP: *2\r\n$5\r\nHSCAN\r\n$4\r\nuser\r\n$1\r\n0\r\n
+// Pattern: HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+// Example: HSCAN user 0 → ["0", ["name", "bob", "age", "42"]]
+// Example: HSCAN user 0 MATCH n* NOVALUES → ["0", ["name"]]
+// Returns: next cursor, 0 when done, and a flat field/value array
+func (h *RESPHandler) handleHScan(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("HSCAN"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	cursor, err := strconv.ParseUint(string(args[1].Bulk), 10, 64)
+	if err != nil {
+		return writer.WriteError("ERR invalid cursor")
+	}
+
+	opts, errMsg := parseScanOptions(args[2:])
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+	if opts.typ != "" {
+		return writer.WriteError(ERRSyntexError)
+	}
+
+	fields, next, err := h.cachesrv.HScan(string(args[0].Bulk), cursor, opts.match, opts.count)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(2); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(strconv.FormatUint(next, 10)); err != nil {
+		return err
+	}
+
+	if opts.noValues {
+		if err := writer.WriteArray(len(fields)); err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := writer.WriteBulkString(f.Field); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := writer.WriteArray(len(fields) * 2); err != nil {
+		return err
+	}
+	return writeHashFields(writer, fields)
+}
+
+type scanOptions struct {
+	match    string
+	count    int
+	noValues bool
+	typ      string
+}
+
+// parseScanOptions parses the MATCH, COUNT, NOVALUES and TYPE options of
+// the SCAN family. It returns the error to reply with, empty when the options
+// are valid.
+func parseScanOptions(args []Value) (scanOptions, string) {
+	opts := scanOptions{match: "*", count: 10}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i].Bulk))
+		if option == "NOVALUES" {
+			opts.noValues = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, ERRSyntexError
+		}
+
+		switch option {
+		case "MATCH":
+			opts.match = string(args[i+1].Bulk)
+		case "COUNT":
+			count, err := strconv.Atoi(string(args[i+1].Bulk))
+			if err != nil {
+				return opts, ERRNotInteger
+			}
+			if count < 1 {
+				return opts, ERRSyntexError
+			}
+			opts.count = count
+		case "TYPE":
+			opts.typ = string(args[i+1].Bulk)
+		default:
+			return opts, ERRSyntexError
+		}
+		i++
+	}
+	return opts, ""
+}
+
+func writeHashFields(writer *RESPWriter, fields []internal.HashField) error {
+	for _, f := range fields {
+		if err := writer.WriteBulkString(f.Field); err != nil {
+			return err
+		}
+		if err := writer.WriteBulk(f.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bulkStrings(args []Value) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = string(arg.Bulk)
+	}
+	return strs
+}
+
+// parseFloat parses a float argument the way Redis does, rejecting NaN.
+func parseFloat(arg []byte) (float64, bool) {
+	val, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || val != val {
+		return 0, false
+	}
+	return val, true
+}
@@ -0,0 +1,527 @@
+package resp2
+
+import (
+	"cago/internal"
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ERRTimeoutNotFloat = "ERR timeout is not a float or out of range"
+	ERRTimeoutNegative = "ERR timeout is negative"
+	ERRNotPositive     = "ERR value is out of range, must be positive"
+)
+
+// RESP: *3\r\n$5\r\nLPUSH\r\n$4\r\njobs\r\n$1\r\na\r\n
+// Pattern: LPUSH key element [element ...]
+// Example: LPUSH jobs "a" "b" → 2 (list is now ["b", "a"])
+// Returns: length of the list after the push, RPUSH appends instead
+func (h *RESPHandler) handlePush(command string, args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	elems := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		elems[i] = arg.Bulk
+	}
+
+	key := string(args[0].Bulk)
+	var length int
+	var err error
+	if command == "LPUSH" {
+		length, err = h.cachesrv.LPush(key, elems)
+	} else {
+		length, err = h.cachesrv.RPush(key, elems)
+	}
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(length))
+}
+
+// RESP: *2\r\n$4\r\nLPOP\r\n$4\r\njobs\r\n
+// Pattern: LPOP key [count]
+// Example: LPOP jobs → "b"
+// Example: LPOP jobs 2 → ["b", "a"]
+// Example: LPOP nonexistent → (nil)
+// Returns: one element, or an array of up to count elements when count is
+// given, RPOP pops from the tail instead
+func (h *RESPHandler) handlePop(command string, args []Value, writer *RESPWriter) error {
+	if len(args) != 1 && len(args) != 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bulk))
+		if err != nil || n < 0 {
+			return writer.WriteError(ERRNotPositive)
+		}
+		count = n
+	}
+
+	key := string(args[0].Bulk)
+	var elems [][]byte
+	var err error
+	if command == "LPOP" {
+		elems, err = h.cachesrv.LPop(key, count)
+	} else {
+		elems, err = h.cachesrv.RPop(key, count)
+	}
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if len(args) == 2 {
+		if elems == nil {
+			return writer.WriteNullArray()
+		}
+		return writeBulkArray(writer, elems)
+	}
+
+	if len(elems) == 0 {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(elems[0])
+}
+
+// RESP: *2\r\n$4\r\nLLEN\r\n$4\r\njobs\r\n
+// Pattern: LLEN key
+// Example: LLEN jobs → 2
+// Example: LLEN nonexistent → 0
+func (h *RESPHandler) handleLLen(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("LLEN"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	length, err := h.cachesrv.LLen(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(length))
+}
+
+// RESP: *4\r\n$6\r\nLRANGE\r\n$4\r\njobs\r\n$1\r\n0\r\n$2\r\n-1\r\n
+// Pattern: LRANGE key start stop
+// Example: LRANGE jobs 0 -1 → ["b", "a"]
+// Returns: elements from start to stop inclusive, negative indexes count
+// from the tail
+func (h *RESPHandler) handleLRange(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("LRANGE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	start, err1 := strconv.Atoi(string(args[1].Bulk))
+	stop, err2 := strconv.Atoi(string(args[2].Bulk))
+	if err1 != nil || err2 != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+
+	elems, err := h.cachesrv.LRange(string(args[0].Bulk), start, stop)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writeBulkArray(writer, elems)
+}
+
+// RESP: *3\r\n$6\r\nLINDEX\r\n$4\r\njobs\r\n$2\r\n-1\r\n
+// Pattern: LINDEX key index
+// Example: LINDEX jobs -1 → "a"
+// Example: LINDEX jobs 10 → (nil)
+func (h *RESPHandler) handleLIndex(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("LINDEX"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	index, err := strconv.Atoi(string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+
+	elem, exists, err := h.cachesrv.LIndex(string(args[0].Bulk), index)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+	if !exists {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(elem)
+}
+
+// RESP: *4\r\n$4\r\nLSET\r\n$4\r\njobs\r\n$1\r\n0\r\n$1\r\nc\r\n
+// Pattern: LSET key index element
+// Example: LSET jobs 0 "c" → OK
+// Example: LSET jobs 10 "c" → ERR index out of range
+func (h *RESPHandler) handleLSet(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("LSET"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	index, err := strconv.Atoi(string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+
+	if err := h.cachesrv.LSet(string(args[0].Bulk), index, args[2].Bulk); err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *4\r\n$4\r\nLREM\r\n$4\r\njobs\r\n$1\r\n0\r\n$1\r\na\r\n
+// Pattern: LREM key count element
+// Example: LREM jobs 0 "a" → 2 (all occurrences removed)
+// Example: LREM jobs -1 "a" → 1 (last occurrence removed)
+// Returns: number of elements removed
+func (h *RESPHandler) handleLRem(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("LREM"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	count, err := strconv.Atoi(string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+
+	removed, err := h.cachesrv.LRem(string(args[0].Bulk), count, args[2].Bulk)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(removed))
+}
+
+// RESP: *4\r\n$5\r\nLTRIM\r\n$4\r\njobs\r\n$1\r\n0\r\n$2\r\n99\r\n
+// Pattern: LTRIM key start stop
+// Example: LTRIM jobs 0 99 → OK (keeps the first 100 elements)
+func (h *RESPHandler) handleLTrim(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("LTRIM"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	start, err1 := strconv.Atoi(string(args[1].Bulk))
+	stop, err2 := strconv.Atoi(string(args[2].Bulk))
+	if err1 != nil || err2 != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+
+	if err := h.cachesrv.LTrim(string(args[0].Bulk), start, stop); err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *5\r\n$7\r\nLINSERT\r\n$4\r\njobs\r\n$6\r\nBEFORE\r\n$1\r\na\r\n$1\r\nz\r\n
+// Pattern: LINSERT key BEFORE|AFTER pivot element
+// Example: LINSERT jobs BEFORE "a" "z" → 3
+// Example: LINSERT jobs BEFORE "missing" "z" → -1
+// Returns: new length, -1 when pivot is not found, 0 when the key does not
+// exist
+func (h *RESPHandler) handleLInsert(args []Value, writer *RESPWriter) error {
+	if len(args) != 4 {
+		return writer.WriteError(wrongArgs("LINSERT"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	var before bool
+	switch strings.ToUpper(string(args[1].Bulk)) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+	default:
+		return writer.WriteError(ERRSyntexError)
+	}
+
+	length, err := h.cachesrv.LInsert(string(args[0].Bulk), before, args[2].Bulk, args[3].Bulk)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(length))
+}
+
+// RESP: *3\r\n$4\r\nLPOS\r\n$4\r\njobs\r\n$1\r\na\r\n
+// Pattern: LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+// Example: LPOS jobs "a" → 1
+// Example: LPOS jobs "a" RANK -1 COUNT 0 → [3, 1] (all matches from the tail)
+// Returns: index of the match, or an array of indexes when COUNT is given
+func (h *RESPHandler) handleLPos(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return writer.WriteError(wrongArgs("LPOS"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	rank, count, maxLen := 1, 1, 0
+	withCount := false
+	for i := 2; i < len(args); i += 2 {
+		val, err := strconv.Atoi(string(args[i+1].Bulk))
+		if err != nil {
+			return writer.WriteError(ERRNotInteger)
+		}
+
+		switch strings.ToUpper(string(args[i].Bulk)) {
+		case "RANK":
+			if val == 0 {
+				return writer.WriteError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = val
+		case "COUNT":
+			if val < 0 {
+				return writer.WriteError("ERR COUNT can't be negative")
+			}
+			count, withCount = val, true
+		case "MAXLEN":
+			if val < 0 {
+				return writer.WriteError("ERR MAXLEN can't be negative")
+			}
+			maxLen = val
+		default:
+			return writer.WriteError(ERRSyntexError)
+		}
+	}
+
+	positions, err := h.cachesrv.LPos(string(args[0].Bulk), args[1].Bulk, rank, count, maxLen)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if !withCount {
+		if len(positions) == 0 {
+			return writer.WriteNull()
+		}
+		return writer.WriteInteger(int64(positions[0]))
+	}
+
+	if err := writer.WriteArray(len(positions)); err != nil {
+		return err
+	}
+	for _, pos := range positions {
+		if err := writer.WriteInteger(int64(pos)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *5\r\n$5\r\nLMOVE\r\n$4\r\njobs\r\n$4\r\ndone\r\n$5\r\nRIGHT\r\n$4\r\nLEFT\r\n
+// Pattern: LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+// Example: LMOVE jobs done RIGHT LEFT → "a"
+// Example: LMOVE nonexistent done RIGHT LEFT → (nil)
+// Returns: the element moved from source to destination
+func (h *RESPHandler) handleLMove(args []Value, writer *RESPWriter) error {
+	if len(args) != 4 {
+		return writer.WriteError(wrongArgs("LMOVE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	srcLeft, ok1 := parseListEnd(args[2].Bulk)
+	dstLeft, ok2 := parseListEnd(args[3].Bulk)
+	if !ok1 || !ok2 {
+		return writer.WriteError(ERRSyntexError)
+	}
+
+	elem, moved, err := h.cachesrv.LMove(string(args[0].Bulk), string(args[1].Bulk), srcLeft, dstLeft)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+	if !moved {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(elem)
+}
+
+// RESP: *3\r\n$5\r\nBLPOP\r\n$4\r\njobs\r\n$1\r\n0\r\n
+// Pattern: BLPOP key [key ...] timeout
+// Example: BLPOP jobs urgent 5 → ["jobs", "a"]
+// Example: BLPOP empty 0.5 → (nil) (after half a second)
+// Returns: key and element popped, waiting up to timeout seconds for a push
+// when all lists are empty, 0 waiting forever. Clients blocked on the same
+// key are served in the order they blocked. BRPOP pops from the tail.
+// Inside a transaction it returns (nil) at once instead of blocking
+func (h *RESPHandler) handleBlockingPop(command string, args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	timeout, errMsg := parseTimeout(args[len(args)-1].Bulk)
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+	if client.inExec {
+		timeout = internal.NoWait
+	}
+
+	ctx, stop := client.watchClose()
+	keys := bulkStrings(args[:len(args)-1])
+	var key string
+	var elem []byte
+	var ok bool
+	var err error
+	if command == "BLPOP" {
+		key, elem, ok, err = h.cachesrv.BLPop(ctx, keys, timeout)
+	} else {
+		key, elem, ok, err = h.cachesrv.BRPop(ctx, keys, timeout)
+	}
+	stop()
+
+	if err == context.Canceled {
+		return err
+	}
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+	if !ok {
+		return writer.WriteNullArray()
+	}
+
+	if err := writer.WriteArray(2); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(key); err != nil {
+		return err
+	}
+	return writer.WriteBulk(elem)
+}
+
+// RESP: *6\r\n$6\r\nBLMOVE\r\n$4\r\njobs\r\n$4\r\ndone\r\n$5\r\nRIGHT\r\n$4\r\nLEFT\r\n$1\r\n0\r\n
+// Pattern: BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+// Example: BLMOVE jobs done RIGHT LEFT 0 → "a"
+// Returns: the element moved, or (nil) once timeout seconds pass while
+// source stays empty
+func (h *RESPHandler) handleBLMove(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) != 5 {
+		return writer.WriteError(wrongArgs("BLMOVE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	srcLeft, ok1 := parseListEnd(args[2].Bulk)
+	dstLeft, ok2 := parseListEnd(args[3].Bulk)
+	if !ok1 || !ok2 {
+		return writer.WriteError(ERRSyntexError)
+	}
+
+	timeout, errMsg := parseTimeout(args[4].Bulk)
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+	if client.inExec {
+		timeout = internal.NoWait
+	}
+
+	ctx, stop := client.watchClose()
+	elem, moved, err := h.cachesrv.BLMove(ctx, string(args[0].Bulk), string(args[1].Bulk), srcLeft, dstLeft, timeout)
+	stop()
+
+	if err == context.Canceled {
+		return err
+	}
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+	if !moved {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(elem)
+}
+
+func writeBulkArray(writer *RESPWriter, elems [][]byte) error {
+	if err := writer.WriteArray(len(elems)); err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		if err := writer.WriteBulk(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseListEnd(arg []byte) (left bool, ok bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// parseTimeout parses a blocking timeout in seconds, which may be
+// fractional. It returns the error message to reply with when invalid.
+func parseTimeout(arg []byte) (time.Duration, string) {
+	seconds, ok := parseFloat(arg)
+	if !ok || math.IsInf(seconds, 0) || seconds > math.MaxInt64/float64(time.Second) {
+		return 0, ERRTimeoutNotFloat
+	}
+	if seconds < 0 {
+		return 0, ERRTimeoutNegative
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout == 0 && seconds > 0 {
+		// Rounded down to 0, which would block forever.
+		timeout = time.Nanosecond
+	}
+	return timeout, ""
+}
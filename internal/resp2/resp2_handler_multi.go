@@ -0,0 +1,214 @@
+package resp2
+
+import (
+	"bytes"
+	"cago/internal"
+	"fmt"
+)
+
+const ERRExecAbort = "EXECABORT Transaction discarded because of previous errors."
+
+// transaction is the state of a client between MULTI and EXEC.
+type transaction struct {
+	commands []queuedCommand
+	// aborted is set once a command could not be queued, which makes
+	// EXEC fail.
+	aborted bool
+}
+
+type queuedCommand struct {
+	name string
+	args []Value
+}
+
+// queueCommand adds command to the transaction of client. A command that
+// cannot run at all is answered with an error instead and aborts the
+// transaction.
+func (h *RESPHandler) queueCommand(command string, args []Value, client *Client) error {
+	writer := client.writer
+	tx := client.multi
+
+	cmd := commands[command]
+	switch {
+	case cmd == nil:
+		tx.aborted = true
+		return writer.WriteError(fmt.Sprintf("ERR unknown command '%s'", command))
+	case cmd.is(flagNoMulti):
+		tx.aborted = true
+		return writer.WriteError("ERR Command not allowed inside a transaction")
+	case !cmd.arityOK(len(args) + 1):
+		tx.aborted = true
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	tx.commands = append(tx.commands, queuedCommand{name: command, args: args})
+	return writer.WriteSimpleString("QUEUED")
+}
+
+// RESP: *1\r\n$5\r\nMULTI\r\n
+// Pattern: MULTI
+// Example: MULTI → OK
+// Returns: OK, then QUEUED for every command sent until EXEC or DISCARD
+func (h *RESPHandler) handleMulti(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) != 0 {
+		return writer.WriteError(wrongArgs("MULTI"))
+	}
+
+	if client.multi != nil {
+		return writer.WriteError("ERR MULTI calls can not be nested")
+	}
+
+	client.multi = &transaction{}
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *1\r\n$4\r\nEXEC\r\n
+// Pattern: EXEC
+// Example: EXEC → [OK, 2]
+// Example: EXEC → (nil) (a watched key was modified)
+// Returns: the reply of every queued command, run without any other client
+// in between. Errors of single commands are part of the replies and do not
+// roll back the others. Fails with EXECABORT when a command could not be
+// queued
+func (h *RESPHandler) handleExec(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) != 0 {
+		return writer.WriteError(wrongArgs("EXEC"))
+	}
+
+	tx, watched := client.multi, client.watched
+	if tx == nil {
+		return writer.WriteError("ERR EXEC without MULTI")
+	}
+	client.multi, client.watched = nil, nil
+
+	if tx.aborted {
+		return writer.WriteError(ERRExecAbort)
+	}
+
+	replies, ok, err := h.runTransaction(tx, watched, client)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return writer.WriteNullArray()
+	}
+	return writer.WriteRaw(replies)
+}
+
+// runTransaction runs the commands of tx while holding off the other
+// clients using the same shards, unless one of the watched keys was
+// modified. The replies are buffered and only written once the others can
+// go on, so a client slow to read them does not hold up the server.
+func (h *RESPHandler) runTransaction(tx *transaction, watched []internal.WatchedKey, client *Client) ([]byte, bool, error) {
+	unlock := h.exclusive(tx, watched)
+	defer unlock()
+
+	if h.cachesrv.Modified(watched) {
+		return nil, false, nil
+	}
+
+	var replies bytes.Buffer
+	writer := client.writer
+	client.writer = NewRESPWriter(&replies)
+	client.writer.SetProtocol(writer.Protocol())
+	client.inExec = true
+	defer func() {
+		client.writer = writer
+		client.inExec = false
+	}()
+
+	if err := client.writer.WriteArray(len(tx.commands)); err != nil {
+		return nil, false, err
+	}
+	for _, cmd := range tx.commands {
+		if err := h.dispatch(cmd.name, cmd.args, client); err != nil {
+			return nil, false, err
+		}
+	}
+	return replies.Bytes(), true, nil
+}
+
+// exclusive holds the storage gates of the keys of tx and of the watched
+// keys, which must not change between the check and the commands. A
+// command on the whole keyspace holds the gates of every shard.
+func (h *RESPHandler) exclusive(tx *transaction, watched []internal.WatchedKey) (unlock func()) {
+	keys := make([]string, 0, len(watched))
+	for _, w := range watched {
+		keys = append(keys, w.Key())
+	}
+	for _, cmd := range tx.commands {
+		spec := commands[cmd.name]
+		if spec == nil || spec.is(flagKeyspace) {
+			return h.cachesrv.ExclusiveAll()
+		}
+		keys = spec.keys.find(keys, cmd.args)
+	}
+	return h.cachesrv.Exclusive(keys...)
+}
+
+// RESP: *1\r\n$7\r\nDISCARD\r\n
+// Pattern: DISCARD
+// Example: DISCARD → OK
+// Returns: OK, dropping the queued commands and the watched keys
+func (h *RESPHandler) handleDiscard(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) != 0 {
+		return writer.WriteError(wrongArgs("DISCARD"))
+	}
+
+	if client.multi == nil {
+		return writer.WriteError("ERR DISCARD without MULTI")
+	}
+
+	client.multi, client.watched = nil, nil
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *3\r\n$5\r\nWATCH\r\n$7\r\nbalance\r\n$7\r\nhistory\r\n
+// Pattern: WATCH key [key ...]
+// Example: WATCH balance history → OK
+// Returns: OK. The next EXEC fails with (nil) if any of the keys is written,
+// deleted or expires in the meantime, by any client or the HTTP API
+func (h *RESPHandler) handleWatch(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs("WATCH"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	if client.multi != nil {
+		return writer.WriteError("ERR WATCH inside MULTI is not allowed")
+	}
+
+	watched, err := h.cachesrv.Watch(bulkStrings(args))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	client.watched = append(client.watched, watched...)
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *1\r\n$7\r\nUNWATCH\r\n
+// Pattern: UNWATCH
+// Example: UNWATCH → OK
+// Returns: OK, forgetting every watched key
+func (h *RESPHandler) handleUnwatch(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) != 0 {
+		return writer.WriteError(wrongArgs("UNWATCH"))
+	}
+
+	client.watched = nil
+	return writer.WriteSimpleString("OK")
+}
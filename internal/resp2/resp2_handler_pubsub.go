@@ -0,0 +1,227 @@
+package resp2
+
+import (
+	"cago/internal"
+	"fmt"
+	"strings"
+)
+
+// subscribeCommands are the commands a RESP2 client in subscribe mode may
+// send, as every other reply would be mistaken for a message.
+var subscribeCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+// RESP: *3\r\n$9\r\nSUBSCRIBE\r\n$4\r\nnews\r\n$6\r\nalerts\r\n
+// Pattern: SUBSCRIBE channel [channel ...]
+// Pattern: PSUBSCRIBE pattern [pattern ...]
+// Example: SUBSCRIBE news → ["subscribe", "news", 1]
+// Example: PSUBSCRIBE news.* → ["psubscribe", "news.*", 2]
+// Returns: one confirmation per channel or pattern with the number of
+// subscriptions of the client, then ["message", channel, payload] or
+// ["pmessage", pattern, channel, payload] for every message published
+func (h *RESPHandler) handleSubscribe(command string, args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	sub := client.subscriber(h.cachesrv, h.cfg.PubSubBuffer)
+	kind := strings.ToLower(command)
+	for _, arg := range args {
+		var count int
+		if command == "PSUBSCRIBE" {
+			count = sub.PSubscribe(string(arg.Bulk))
+		} else {
+			count = sub.Subscribe(string(arg.Bulk))
+		}
+
+		if err := writeSubscription(writer, kind, arg.Bulk, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *2\r\n$11\r\nUNSUBSCRIBE\r\n$4\r\nnews\r\n
+// Pattern: UNSUBSCRIBE [channel [channel ...]]
+// Pattern: PUNSUBSCRIBE [pattern [pattern ...]]
+// Example: UNSUBSCRIBE news → ["unsubscribe", "news", 0]
+// Example: PUNSUBSCRIBE → ["punsubscribe", (nil), 0] (no patterns)
+// Returns: one confirmation per channel or pattern left, every one of them
+// when none is given, with the number of subscriptions remaining
+func (h *RESPHandler) handleUnsubscribe(command string, args []Value, client *Client) error {
+	writer := client.writer
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	kind := strings.ToLower(command)
+	names := bulkStrings(args)
+	if len(names) == 0 && client.sub != nil {
+		if command == "PUNSUBSCRIBE" {
+			names = client.sub.Patterns()
+		} else {
+			names = client.sub.Channels()
+		}
+	}
+
+	if len(names) == 0 {
+		count := 0
+		if client.sub != nil {
+			count = client.sub.Count()
+		}
+		return writeSubscription(writer, kind, nil, count)
+	}
+
+	for _, name := range names {
+		count := 0
+		if client.sub != nil {
+			if command == "PUNSUBSCRIBE" {
+				count = client.sub.PUnsubscribe(name)
+			} else {
+				count = client.sub.Unsubscribe(name)
+			}
+		}
+
+		if err := writeSubscription(writer, kind, []byte(name), count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *3\r\n$7\r\nPUBLISH\r\n$4\r\nnews\r\n$5\r\nhello\r\n
+// Pattern: PUBLISH channel message
+// Example: PUBLISH news "hello" → 2
+// Returns: number of subscribers that received the message
+func (h *RESPHandler) handlePublish(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("PUBLISH"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	return writer.WriteInteger(int64(h.cachesrv.Publish(string(args[0].Bulk), args[1].Bulk)))
+}
+
+// RESP: *2\r\n$6\r\nPUBSUB\r\n$8\r\nCHANNELS\r\n
+// Pattern: PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+// Example: PUBSUB CHANNELS news.* → ["news.eu", "news.us"]
+// Example: PUBSUB NUMSUB news → ["news", 2]
+// Example: PUBSUB NUMPAT → 1
+// Returns: the active channels, the subscribers of each channel, or the
+// number of patterns subscribed to
+func (h *RESPHandler) handlePubSub(args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs("PUBSUB"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	switch strings.ToUpper(string(args[0].Bulk)) {
+	case "CHANNELS":
+		if len(args) > 2 {
+			return writer.WriteError(wrongArgs("PUBSUB|CHANNELS"))
+		}
+
+		pattern := ""
+		if len(args) == 2 {
+			pattern = string(args[1].Bulk)
+		}
+		return writeStrings(writer, h.cachesrv.PubSubChannels(pattern))
+	case "NUMSUB":
+		channels := bulkStrings(args[1:])
+		counts := h.cachesrv.PubSubNumSub(channels)
+
+		if err := writer.WriteMap(len(channels)); err != nil {
+			return err
+		}
+		for i, channel := range channels {
+			if err := writer.WriteBulkString(channel); err != nil {
+				return err
+			}
+			if err := writer.WriteInteger(int64(counts[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "NUMPAT":
+		if len(args) != 1 {
+			return writer.WriteError(wrongArgs("PUBSUB|NUMPAT"))
+		}
+		return writer.WriteInteger(int64(h.cachesrv.PubSubNumPat()))
+	default:
+		return writer.WriteError(fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk))
+	}
+}
+
+// writeSubscription writes the confirmation of a (un)subscription, with a
+// null name when there was nothing to unsubscribe from.
+func writeSubscription(writer *RESPWriter, kind string, name []byte, count int) error {
+	if err := writer.WritePush(3); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(kind); err != nil {
+		return err
+	}
+	if name == nil {
+		if err := writer.WriteNull(); err != nil {
+			return err
+		}
+	} else if err := writer.WriteBulk(name); err != nil {
+		return err
+	}
+	return writer.WriteInteger(int64(count))
+}
+
+// writeMessage writes msg as a message, or a pmessage when it was
+// delivered through a pattern.
+func writeMessage(writer *RESPWriter, msg internal.Message) error {
+	if msg.Pattern != "" {
+		if err := writer.WritePush(4); err != nil {
+			return err
+		}
+		if err := writer.WriteBulkString("pmessage"); err != nil {
+			return err
+		}
+		if err := writer.WriteBulkString(msg.Pattern); err != nil {
+			return err
+		}
+	} else {
+		if err := writer.WritePush(3); err != nil {
+			return err
+		}
+		if err := writer.WriteBulkString("message"); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.WriteBulkString(msg.Channel); err != nil {
+		return err
+	}
+	return writer.WriteBulk(msg.Payload)
+}
+
+// commandName returns the upper-cased name of cmd, false when cmd is not a
+// well-formed command.
+func commandName(cmd *Value) (string, bool) {
+	if cmd.Type != Array || len(cmd.Array) == 0 || cmd.Array[0].Type != BulkString {
+		return "", false
+	}
+	return strings.ToUpper(string(cmd.Array[0].Bulk)), true
+}
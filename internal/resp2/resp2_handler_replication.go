@@ -0,0 +1,107 @@
+package resp2
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RESP: *3\r\n$9\r\nREPLICAOF\r\n$9\r\n127.0.0.1\r\n$4\r\n6379\r\n
+// RESP: *3\r\n$9\r\nREPLICAOF\r\n$2\r\nNO\r\n$3\r\nONE\r\n
+// Pattern: REPLICAOF host port | REPLICAOF NO ONE
+// Example: REPLICAOF 10.0.0.1 6379 → OK (becomes a read-only replica)
+// Example: REPLICAOF NO ONE → OK (promoted to primary)
+func (h *RESPHandler) handleReplicaOf(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError("ERR wrong number of arguments for 'REPLICAOF' command")
+	}
+
+	if args[0].Type != BulkString || args[1].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	host := string(args[0].Bulk)
+	if strings.EqualFold(host, "NO") && strings.EqualFold(string(args[1].Bulk), "ONE") {
+		h.replication.PromoteToMaster()
+		return writer.WriteSimpleString("OK")
+	}
+
+	port, err := strconv.Atoi(string(args[1].Bulk))
+	if err != nil || port <= 0 || port > 65535 {
+		return writer.WriteError("ERR Invalid master port")
+	}
+
+	h.replication.ReplicaOf(host, port)
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$4\r\n6380\r\n
+// Pattern: REPLCONF option value [option value ...]
+// Example: REPLCONF listening-port 6380 → OK
+// Example: REPLCONF capa psync2 → OK
+// Sent by replicas during the handshake; ACKs are consumed by the stream.
+func (h *RESPHandler) handleReplConf(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) == 0 || len(args)%2 != 0 {
+		return writer.WriteError(ERRSyntexError)
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		if args[i].Type != BulkString || args[i+1].Type != BulkString {
+			return writer.WriteError(ERRWrongArgumentType)
+		}
+
+		switch strings.ToLower(string(args[i].Bulk)) {
+		case "listening-port":
+			port, err := strconv.Atoi(string(args[i+1].Bulk))
+			if err != nil || port <= 0 || port > 65535 {
+				return writer.WriteError("ERR Invalid listening port")
+			}
+			client.listeningPort = strconv.Itoa(port)
+		case "ack":
+			// Only meaningful on a replication link, never answered.
+			return nil
+		case "capa", "ip-address":
+		default:
+			return writer.WriteError("ERR Unrecognized REPLCONF option: " + string(args[i].Bulk))
+		}
+	}
+
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n
+// Pattern: PSYNC replicationid offset
+// Example: PSYNC ? -1 → +FULLRESYNC <replid> <offset>, snapshot, stream
+// Example: PSYNC <replid> 1234 → +CONTINUE <replid>, backlog, stream
+// The connection serves the replication stream until the replica leaves.
+func (h *RESPHandler) handlePsync(args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) != 2 {
+		return writer.WriteError("ERR wrong number of arguments for 'PSYNC' command")
+	}
+
+	if args[0].Type != BulkString || args[1].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	offset, err := strconv.ParseInt(string(args[1].Bulk), 10, 64)
+	if err != nil {
+		return writer.WriteError("ERR value is not an integer or out of range")
+	}
+
+	port := client.listeningPort
+	if port == "" {
+		_, port, _ = net.SplitHostPort(client.RemoteAddr().String())
+	}
+
+	if err := h.replication.ServeReplica(client, string(args[0].Bulk), offset, port); err != nil {
+		return err
+	}
+
+	// The connection was handed over to the replication stream, so it must
+	// not go back to serving commands.
+	return errReplicaDisconnected
+}
@@ -0,0 +1,213 @@
+package resp2
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// RESP: *5\r\n$4\r\nEVAL\r\n$35\r\nreturn redis.call('GET', KEYS[1])\r\n$1\r\n1\r\n$6\r\nuser:1\r\n
+// Pattern: EVAL script numkeys [key ...] [arg ...]
+// Pattern: EVALSHA sha1 numkeys [key ...] [arg ...]
+// Example: EVAL "return redis.call('GET', KEYS[1])" 1 user:1 → "alice"
+// Example: EVALSHA e0e1f9fabfc9d4800c877a703b823ac0578ff8db 0 → 1
+// Returns: the value returned by the script, with numbers as integers,
+// false as nil and {ok=...} or {err=...} tables as status or error replies.
+// The script runs without any other client touching its keys in between,
+// so every key it uses must be passed as a key: keys it reaches without
+// declaring them can be changed by other clients while it runs. EVALSHA
+// fails with NOSCRIPT unless the script was loaded before
+func (h *RESPHandler) handleEval(command string, args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	keys, argv, errMsg := scriptKeys(args[1:])
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+
+	var proto *lua.FunctionProto
+	if command == "EVAL" {
+		var err error
+		if _, proto, err = h.scripts.load(string(args[0].Bulk)); err != nil {
+			return writer.WriteError(err.Error())
+		}
+	} else if proto = h.scripts.lookup(string(args[0].Bulk)); proto == nil {
+		return writer.WriteError(ERRNoScript)
+	}
+
+	return h.runScript(client, keys, func(call scriptCaller, writer *RESPWriter) error {
+		return h.scripts.run(proto, keys, argv, call, writer)
+	})
+}
+
+// scriptKeys splits numkeys [key ...] [arg ...] into the keys and the
+// other arguments, or returns the error to reply with.
+func scriptKeys(args []Value) ([][]byte, [][]byte, string) {
+	numKeys, err := strconv.Atoi(string(args[0].Bulk))
+	if err != nil {
+		return nil, nil, ERRNotInteger
+	}
+	if numKeys < 0 {
+		return nil, nil, "ERR Number of keys can't be negative"
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, "ERR Number of keys can't be greater than number of args"
+	}
+
+	keys := make([][]byte, numKeys)
+	for i := range keys {
+		keys[i] = args[1+i].Bulk
+	}
+	argv := make([][]byte, len(args)-1-numKeys)
+	for i := range argv {
+		argv[i] = args[1+numKeys+i].Bulk
+	}
+	return keys, argv, ""
+}
+
+// runScript runs a script or function while holding off the other clients
+// using its keys, unless it is part of a transaction which already does.
+// Keys it uses without declaring them, which Redis asks scripts not to do,
+// are not protected. Like EXEC, the reply is only written once the others can
+// go on.
+func (h *RESPHandler) runScript(client *Client, keys [][]byte, run func(call scriptCaller, writer *RESPWriter) error) error {
+	var reply bytes.Buffer
+	writer := NewRESPWriter(&reply)
+	writer.SetProtocol(client.writer.Protocol())
+
+	err := func() error {
+		if !client.inExec {
+			names := make([]string, len(keys))
+			for i, key := range keys {
+				names[i] = string(key)
+			}
+			unlock := h.cachesrv.Exclusive(names...)
+			defer unlock()
+
+			// The script does not write to the connection until it is
+			// done, so messages for the client can go out meanwhile.
+			reacquire := client.releaseWrites()
+			defer reacquire()
+		}
+		return run(h.scriptCaller(client), writer)
+	}()
+	if err != nil {
+		return err
+	}
+	return client.writer.WriteRaw(reply.Bytes())
+}
+
+// scriptCaller runs the commands of redis.call on behalf of client, the way
+// a transaction runs them, and parses their RESP2 replies back.
+func (h *RESPHandler) scriptCaller(client *Client) scriptCaller {
+	var out bytes.Buffer
+	caller := &Client{
+		ID:     client.ID,
+		ctx:    client.ctx,
+		writer: NewRESPWriter(&out),
+		inExec: true,
+	}
+
+	return func(command string, args []Value) *Value {
+		out.Reset()
+		if err := h.dispatch(command, args, caller); err != nil {
+			return &Value{Type: Error, Str: "ERR " + err.Error()}
+		}
+
+		reply, err := NewRESPParser(&out).Parse()
+		if err != nil {
+			return &Value{Type: Error, Str: "ERR " + err.Error()}
+		}
+		return reply
+	}
+}
+
+// RESP: *3\r\n$6\r\nSCRIPT\r\n$4\r\nLOAD\r\n$8\r\nreturn 1\r\n
+// Pattern: SCRIPT LOAD script | EXISTS sha1 [sha1 ...] | FLUSH [ASYNC|SYNC] | KILL
+// Example: SCRIPT LOAD "return 1" → "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"
+// Example: SCRIPT EXISTS e0e1f9fabfc9d4800c877a703b823ac0578ff8db ffff → [1, 0]
+// Example: SCRIPT KILL → OK
+// Returns: the SHA1 to call a loaded script with, whether each script is
+// cached, or OK. KILL stops the running script unless it already wrote to
+// the dataset, and is the only command served while a script runs past the
+// time limit
+func (h *RESPHandler) handleScript(args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs("SCRIPT"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	switch strings.ToUpper(string(args[0].Bulk)) {
+	case "LOAD":
+		if len(args) != 2 {
+			return writer.WriteError(wrongArgs("SCRIPT|LOAD"))
+		}
+
+		sha, _, err := h.scripts.load(string(args[1].Bulk))
+		if err != nil {
+			return writer.WriteError(err.Error())
+		}
+		return writer.WriteBulkString(sha)
+	case "EXISTS":
+		if len(args) < 2 {
+			return writer.WriteError(wrongArgs("SCRIPT|EXISTS"))
+		}
+
+		if err := writer.WriteArray(len(args) - 1); err != nil {
+			return err
+		}
+		for _, arg := range args[1:] {
+			exists := int64(0)
+			if h.scripts.lookup(string(arg.Bulk)) != nil {
+				exists = 1
+			}
+			if err := writer.WriteInteger(exists); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "FLUSH":
+		if len(args) > 2 {
+			return writer.WriteError(wrongArgs("SCRIPT|FLUSH"))
+		}
+		if len(args) == 2 {
+			if mode := strings.ToUpper(string(args[1].Bulk)); mode != "ASYNC" && mode != "SYNC" {
+				return writer.WriteError(ERRSyntexError)
+			}
+		}
+
+		h.scripts.flush()
+		return writer.WriteSimpleString("OK")
+	case "KILL":
+		if len(args) != 1 {
+			return writer.WriteError(wrongArgs("SCRIPT|KILL"))
+		}
+
+		if errMsg := h.scripts.kill(); errMsg != "" {
+			return writer.WriteError(errMsg)
+		}
+		return writer.WriteSimpleString("OK")
+	default:
+		return writer.WriteError(fmt.Sprintf("ERR unknown subcommand '%s'", args[0].Bulk))
+	}
+}
+
+// scriptKill reports whether command is SCRIPT KILL or FUNCTION KILL, which
+// must get through while a script keeps the server busy.
+func scriptKill(command string, args []Value) bool {
+	return (command == "SCRIPT" || command == "FUNCTION") && len(args) > 0 && strings.EqualFold(string(args[0].Bulk), "KILL")
+}
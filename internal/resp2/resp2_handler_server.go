@@ -0,0 +1,185 @@
+package resp2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// serverVersion is reported by INFO and HELLO.
+const serverVersion = "0.1.0"
+
+// RESP: *1\r\n$4\r\nINFO\r\n
+// RESP: *2\r\n$4\r\nINFO\r\n$11\r\nreplication\r\n
+// Pattern: INFO [section ...]
+// Example: INFO → all sections
+// Example: INFO replication → "# Replication\r\nrole:master\r\n..."
+// Returns: bulk string of "field:value" lines grouped in sections
+func (h *RESPHandler) handleInfo(args []Value, writer *RESPWriter) error {
+	sections := map[string]func() string{
+		"server":      h.infoServer,
+		"memory":      h.infoMemory,
+		"persistence": h.infoPersistence,
+		"replication": h.replication.Info,
+	}
+	order := []string{"server", "memory", "persistence", "replication"}
+
+	requested := order
+	if len(args) > 0 {
+		requested = nil
+		for _, arg := range args {
+			if arg.Type != BulkString {
+				return writer.WriteError(ERRWrongArgumentType)
+			}
+
+			name := strings.ToLower(string(arg.Bulk))
+			if name == "all" || name == "default" || name == "everything" {
+				requested = order
+				break
+			}
+			if _, ok := sections[name]; ok {
+				requested = append(requested, name)
+			}
+		}
+	}
+
+	parts := make([]string, 0, len(requested))
+	for _, name := range requested {
+		parts = append(parts, sections[name]())
+	}
+
+	return writer.WriteVerbatim("txt", strings.Join(parts, "\r\n"))
+}
+
+// RESP: *2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n
+// Pattern: HELLO [protover [AUTH username password] [SETNAME clientname]]
+// Example: HELLO 3 → {"server": "cago", "version": "0.1.0", "proto": 3, ...}
+// Example: HELLO 4 → NOPROTO unsupported protocol version
+// Returns: map describing the server, in the newly selected protocol
+func (h *RESPHandler) handleHello(args []Value, client *Client) error {
+	writer := client.writer
+
+	for _, arg := range args {
+		if arg.Type != BulkString {
+			return writer.WriteError(ERRWrongArgumentType)
+		}
+	}
+
+	proto := writer.Protocol()
+	if len(args) > 0 {
+		version, err := strconv.Atoi(string(args[0].Bulk))
+		if err != nil {
+			return writer.WriteError("ERR Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			return writer.WriteError("NOPROTO unsupported protocol version")
+		}
+		proto = version
+	}
+
+	var name string
+	var setName bool
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Bulk)) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return writer.WriteError(ERRSyntexError)
+			}
+			return writer.WriteError("ERR AUTH <password> called without any password configured for the default user.")
+		case "SETNAME":
+			if i+1 >= len(args) {
+				return writer.WriteError(ERRSyntexError)
+			}
+			name = string(args[i+1].Bulk)
+			if strings.ContainsAny(name, " \n") {
+				return writer.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			setName = true
+			i++
+		default:
+			return writer.WriteError(ERRSyntexError)
+		}
+	}
+
+	writer.SetProtocol(proto)
+	if setName {
+		client.name = name
+	}
+
+	role := "master"
+	if h.cachesrv.ReadOnly() {
+		role = "replica"
+	}
+
+	if err := writer.WriteMap(7); err != nil {
+		return err
+	}
+	fields := []struct {
+		name  string
+		write func() error
+	}{
+		{"server", func() error { return writer.WriteBulkString("cago") }},
+		{"version", func() error { return writer.WriteBulkString(serverVersion) }},
+		{"proto", func() error { return writer.WriteInteger(int64(proto)) }},
+		{"id", func() error { return writer.WriteInteger(client.ID) }},
+		{"mode", func() error { return writer.WriteBulkString("standalone") }},
+		{"role", func() error { return writer.WriteBulkString(role) }},
+		{"modules", func() error { return writer.WriteArray(0) }},
+	}
+	for _, f := range fields {
+		if err := writer.WriteBulkString(f.name); err != nil {
+			return err
+		}
+		if err := f.write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *RESPHandler) infoServer() string {
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	fmt.Fprintf(&b, "cago_version:%s\r\n", serverVersion)
+	return b.String()
+}
+
+func (h *RESPHandler) infoMemory() string {
+	stats := h.cachesrv.MemoryStats()
+
+	var b strings.Builder
+	b.WriteString("# Memory\r\n")
+	fmt.Fprintf(&b, "used_memory:%d\r\n", stats.UsedMemory)
+	fmt.Fprintf(&b, "used_memory_peak:%d\r\n", stats.PeakMemory)
+	fmt.Fprintf(&b, "used_memory_dataset:%d\r\n", stats.DatasetBytes)
+	fmt.Fprintf(&b, "maxmemory:%d\r\n", stats.MaxMemory)
+	fmt.Fprintf(&b, "maxmemory_policy:%s\r\n", stats.EvictionPolicy)
+	fmt.Fprintf(&b, "evicted_keys:%d\r\n", stats.EvictedKeys)
+	return b.String()
+}
+
+func (h *RESPHandler) infoPersistence() string {
+	stats := h.cachesrv.PersistenceStats()
+
+	status := "ok"
+	if stats.LastSaveErr != nil {
+		status = "err"
+	}
+
+	var b strings.Builder
+	b.WriteString("# Persistence\r\n")
+	fmt.Fprintf(&b, "rdb_changes_since_last_save:%d\r\n", stats.Dirty)
+	fmt.Fprintf(&b, "rdb_bgsave_in_progress:%d\r\n", boolToInt(stats.SaveInProgress))
+	fmt.Fprintf(&b, "rdb_last_save_time:%d\r\n", stats.LastSave.Unix())
+	fmt.Fprintf(&b, "rdb_last_bgsave_status:%s\r\n", status)
+	fmt.Fprintf(&b, "aof_enabled:%d\r\n", boolToInt(stats.AOFEnabled))
+	fmt.Fprintf(&b, "aof_rewrite_in_progress:%d\r\n", boolToInt(stats.RewriteInProgress))
+	return b.String()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
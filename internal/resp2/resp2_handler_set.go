@@ -0,0 +1,359 @@
+package resp2
+
+import (
+	"cago/internal"
+	"strconv"
+)
+
+// RESP: *4\r\n$4\r\nSADD\r\n$4\r\ntags\r\n$2\r\ngo\r\n$5\r\nredis\r\n
+// Pattern: SADD key member [member ...]
+// Example: SADD tags "go" "redis" → 2
+// Example: SADD tags "go" → 0 (already a member)
+// Returns: number of members that were added
+func (h *RESPHandler) handleSAdd(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("SADD"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	added, err := h.cachesrv.SAdd(string(args[0].Bulk), bulkStrings(args[1:]))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(added))
+}
+
+// RESP: *3\r\n$4\r\nSREM\r\n$4\r\ntags\r\n$2\r\ngo\r\n
+// Pattern: SREM key member [member ...]
+// Example: SREM tags "go" "missing" → 1
+// Returns: number of members removed, the key is removed with its last
+// member
+func (h *RESPHandler) handleSRem(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("SREM"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	removed, err := h.cachesrv.SRem(string(args[0].Bulk), bulkStrings(args[1:]))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(removed))
+}
+
+// RESP: *2\r\n$8\r\nSMEMBERS\r\n$4\r\ntags\r\n
+// Pattern: SMEMBERS key
+// Example: SMEMBERS tags → ["go", "redis"]
+// Returns: all members in no particular order, a set in RESP3
+func (h *RESPHandler) handleSMembers(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("SMEMBERS"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	members, err := h.cachesrv.SMembers(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writeStringSet(writer, members)
+}
+
+// RESP: *3\r\n$9\r\nSISMEMBER\r\n$4\r\ntags\r\n$2\r\ngo\r\n
+// Pattern: SISMEMBER key member
+// Example: SISMEMBER tags "go" → 1
+// Example: SISMEMBER tags "rust" → 0
+func (h *RESPHandler) handleSIsMember(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("SISMEMBER"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	found, err := h.cachesrv.SIsMember(string(args[0].Bulk), string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if found {
+		return writer.WriteInteger(1)
+	}
+	return writer.WriteInteger(0)
+}
+
+// RESP: *4\r\n$10\r\nSMISMEMBER\r\n$4\r\ntags\r\n$2\r\ngo\r\n$4\r\nrust\r\n
+// Pattern: SMISMEMBER key member [member ...]
+// Example: SMISMEMBER tags "go" "rust" → [1, 0]
+func (h *RESPHandler) handleSMIsMember(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("SMISMEMBER"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	found, err := h.cachesrv.SMIsMember(string(args[0].Bulk), bulkStrings(args[1:]))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(len(found)); err != nil {
+		return err
+	}
+	for _, f := range found {
+		n := int64(0)
+		if f {
+			n = 1
+		}
+		if err := writer.WriteInteger(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *2\r\n$5\r\nSCARD\r\n$4\r\ntags\r\n
+// Pattern: SCARD key
+// Example: SCARD tags → 2
+// Example: SCARD nonexistent → 0
+func (h *RESPHandler) handleSCard(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("SCARD"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	card, err := h.cachesrv.SCard(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(card))
+}
+
+// RESP: *2\r\n$4\r\nSPOP\r\n$4\r\ntags\r\n
+// Pattern: SPOP key [count]
+// Example: SPOP tags → "redis"
+// Example: SPOP tags 5 → ["go"] (fewer members than requested)
+// Returns: a random member removed from the set, or a set of up to count
+// members when count is given
+func (h *RESPHandler) handleSPop(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 && len(args) != 2 {
+		return writer.WriteError(wrongArgs("SPOP"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bulk))
+		if err != nil || n < 0 {
+			return writer.WriteError(ERRNotPositive)
+		}
+		count = n
+	}
+
+	members, err := h.cachesrv.SPop(string(args[0].Bulk), count)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if len(args) == 2 {
+		return writeStringSet(writer, members)
+	}
+	if len(members) == 0 {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulkString(members[0])
+}
+
+// RESP: *3\r\n$11\r\nSRANDMEMBER\r\n$4\r\ntags\r\n$2\r\n-3\r\n
+// Pattern: SRANDMEMBER key [count]
+// Example: SRANDMEMBER tags → "go"
+// Example: SRANDMEMBER tags 5 → ["go", "redis"] (distinct members)
+// Example: SRANDMEMBER tags -3 → ["go", "go", "redis"] (may repeat)
+// Returns: random members left in place
+func (h *RESPHandler) handleSRandMember(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 && len(args) != 2 {
+		return writer.WriteError(wrongArgs("SRANDMEMBER"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bulk))
+		if err != nil {
+			return writer.WriteError(ERRNotInteger)
+		}
+		count = n
+	}
+
+	members, err := h.cachesrv.SRandMember(string(args[0].Bulk), count)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if len(args) == 2 {
+		return writeStrings(writer, members)
+	}
+	if len(members) == 0 {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulkString(members[0])
+}
+
+// RESP: *4\r\n$5\r\nSMOVE\r\n$4\r\ntags\r\n$4\r\nseen\r\n$2\r\ngo\r\n
+// Pattern: SMOVE source destination member
+// Example: SMOVE tags seen "go" → 1
+// Example: SMOVE tags seen "rust" → 0 (not a member of source)
+func (h *RESPHandler) handleSMove(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("SMOVE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	moved, err := h.cachesrv.SMove(string(args[0].Bulk), string(args[1].Bulk), string(args[2].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if moved {
+		return writer.WriteInteger(1)
+	}
+	return writer.WriteInteger(0)
+}
+
+// RESP: *3\r\n$6\r\nSINTER\r\n$4\r\ntags\r\n$4\r\nseen\r\n
+// Pattern: SINTER key [key ...]
+// Example: SINTER tags seen → ["go"]
+// Example: SDIFF tags seen → ["redis"] (members of tags only)
+// Returns: the intersection of the sets, a set in RESP3. SUNION and SDIFF
+// return the union and the members of the first set missing from the others
+func (h *RESPHandler) handleSetOp(command string, op internal.SetOp, args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	members, err := h.cachesrv.SetOp(op, bulkStrings(args))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writeStringSet(writer, members)
+}
+
+// RESP: *4\r\n$11\r\nSINTERSTORE\r\n$3\r\nout\r\n$4\r\ntags\r\n$4\r\nseen\r\n
+// Pattern: SINTERSTORE destination key [key ...]
+// Example: SINTERSTORE out tags seen → 1
+// Returns: size of the set stored under destination, which is overwritten
+// whatever it held, or removed when the result is empty. SUNIONSTORE and
+// SDIFFSTORE work the same
+func (h *RESPHandler) handleSetOpStore(command string, op internal.SetOp, args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	card, err := h.cachesrv.SetOpStore(op, string(args[0].Bulk), bulkStrings(args[1:]))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(card))
+}
+
+// RESP: *3\r\n$5\r\nSSCAN\r\n$4\r\ntags\r\n$1\r\n0\r\n
+// Pattern: SSCAN key cursor [MATCH pattern] [COUNT count]
+// Example: SSCAN tags 0 MATCH g* → ["0", ["go"]]
+// Returns: next cursor, 0 when done, and the members of this page
+func (h *RESPHandler) handleSScan(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("SSCAN"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	cursor, err := strconv.ParseUint(string(args[1].Bulk), 10, 64)
+	if err != nil {
+		return writer.WriteError("ERR invalid cursor")
+	}
+
+	opts, errMsg := parseScanOptions(args[2:])
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+	if opts.noValues || opts.typ != "" {
+		return writer.WriteError(ERRSyntexError)
+	}
+
+	members, next, err := h.cachesrv.SScan(string(args[0].Bulk), cursor, opts.match, opts.count)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(2); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(strconv.FormatUint(next, 10)); err != nil {
+		return err
+	}
+	return writeStrings(writer, members)
+}
+
+func writeStrings(writer *RESPWriter, strs []string) error {
+	if err := writer.WriteArray(len(strs)); err != nil {
+		return err
+	}
+	for _, str := range strs {
+		if err := writer.WriteBulkString(str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStringSet writes strs as a set, which RESP2 clients see as an array.
+func writeStringSet(writer *RESPWriter, strs []string) error {
+	if err := writer.WriteSet(len(strs)); err != nil {
+		return err
+	}
+	for _, str := range strs {
+		if err := writer.WriteBulkString(str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
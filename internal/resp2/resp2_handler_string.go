@@ -0,0 +1,377 @@
+package resp2
+
+import (
+	"cago/internal"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RESP: *2\r\n$4\r\nINCR\r\n$4\r\nhits\r\n
+// Pattern: INCR key
+// Pattern: INCRBY key increment
+// Example: INCR hits → 1 (missing keys start from 0)
+// Example: DECRBY hits 5 → -4
+// Returns: the new value. INCR and DECR add and subtract 1, INCRBY and
+// DECRBY the given amount, the key keeps its TTL
+func (h *RESPHandler) handleIncr(command string, args []Value, writer *RESPWriter) error {
+	withAmount := command == "INCRBY" || command == "DECRBY"
+	if (withAmount && len(args) != 2) || (!withAmount && len(args) != 1) {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	delta := int64(1)
+	if withAmount {
+		n, err := strconv.ParseInt(string(args[1].Bulk), 10, 64)
+		if err != nil {
+			return writer.WriteError(ERRNotInteger)
+		}
+		delta = n
+	}
+	if command == "DECR" || command == "DECRBY" {
+		if delta == math.MinInt64 {
+			return writer.WriteError("ERR decrement would overflow")
+		}
+		delta = -delta
+	}
+
+	result, err := h.cachesrv.IncrBy(string(args[0].Bulk), delta)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(result)
+}
+
+// RESP: *3\r\n$11\r\nINCRBYFLOAT\r\n$5\r\nprice\r\n$3\r\n0.1\r\n
+// Pattern: INCRBYFLOAT key increment
+// Example: INCRBYFLOAT price 0.1 → "10.6"
+// Returns: new value as a bulk string
+func (h *RESPHandler) handleIncrByFloat(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("INCRBYFLOAT"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	delta, ok := parseFloat(args[1].Bulk)
+	if !ok {
+		return writer.WriteError(ERRNotFloat)
+	}
+
+	result, err := h.cachesrv.IncrByFloat(string(args[0].Bulk), delta)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteBulk(result)
+}
+
+// RESP: *3\r\n$6\r\nAPPEND\r\n$3\r\nlog\r\n$5\r\nhello\r\n
+// Pattern: APPEND key value
+// Example: APPEND log "hello" → 5
+// Returns: length of the string after the append
+func (h *RESPHandler) handleAppend(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("APPEND"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	length, err := h.cachesrv.Append(string(args[0].Bulk), args[1].Bulk)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(length))
+}
+
+// RESP: *2\r\n$6\r\nSTRLEN\r\n$3\r\nlog\r\n
+// Pattern: STRLEN key
+// Example: STRLEN log → 5
+// Example: STRLEN nonexistent → 0
+func (h *RESPHandler) handleStrLen(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("STRLEN"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	length, err := h.cachesrv.StrLen(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(length))
+}
+
+// RESP: *4\r\n$8\r\nGETRANGE\r\n$3\r\nlog\r\n$1\r\n0\r\n$2\r\n-2\r\n
+// Pattern: GETRANGE key start end
+// Example: GETRANGE log 0 -2 → "hell"
+// Example: GETRANGE log 10 20 → "" (out of range)
+// Returns: the bytes from start to end inclusive, negative offsets count
+// from the end
+func (h *RESPHandler) handleGetRange(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("GETRANGE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	start, err := strconv.Atoi(string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+	end, err := strconv.Atoi(string(args[2].Bulk))
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+
+	value, err := h.cachesrv.GetRange(string(args[0].Bulk), start, end)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if value == nil {
+		value = []byte{}
+	}
+	return writer.WriteBulk(value)
+}
+
+// RESP: *4\r\n$8\r\nSETRANGE\r\n$3\r\nlog\r\n$1\r\n6\r\n$5\r\nworld\r\n
+// Pattern: SETRANGE key offset value
+// Example: SETRANGE log 6 "world" → 11 (zero bytes fill the gap)
+// Returns: length of the string after the write
+func (h *RESPHandler) handleSetRange(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("SETRANGE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	offset, err := strconv.Atoi(string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+	if offset < 0 {
+		return writer.WriteError("ERR offset is out of range")
+	}
+
+	length, err := h.cachesrv.SetRange(string(args[0].Bulk), offset, args[2].Bulk)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(length))
+}
+
+// RESP: *3\r\n$4\r\nMGET\r\n$4\r\nkey1\r\n$4\r\nkey2\r\n
+// Pattern: MGET key [key ...]
+// Example: MGET key1 nonexistent → ["hello", (nil)]
+// Returns: the value of every key in order, null for keys that don't exist
+// or hold another type
+func (h *RESPHandler) handleMGet(args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs("MGET"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	values, err := h.cachesrv.MGet(bulkStrings(args))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if err := writer.WriteArray(len(values)); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if value == nil {
+			if err := writer.WriteNull(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writer.WriteBulk(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *5\r\n$4\r\nMSET\r\n$4\r\nkey1\r\n$1\r\na\r\n$4\r\nkey2\r\n$1\r\nb\r\n
+// Pattern: MSET key value [key value ...]
+// Example: MSET key1 "a" key2 "b" → OK
+func (h *RESPHandler) handleMSet(args []Value, writer *RESPWriter) error {
+	pairs, errMsg := parseKeyValues("MSET", args)
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+
+	if err := h.cachesrv.MSet(pairs); err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteSimpleString("OK")
+}
+
+// RESP: *5\r\n$6\r\nMSETNX\r\n$4\r\nkey1\r\n$1\r\na\r\n$4\r\nkey3\r\n$1\r\nc\r\n
+// Pattern: MSETNX key value [key value ...]
+// Example: MSETNX key3 "c" key4 "d" → 1
+// Example: MSETNX key1 "a" key5 "e" → 0 (key1 exists, nothing is set)
+// Returns: 1 if all keys were set, 0 if none was because one already
+// existed
+func (h *RESPHandler) handleMSetNX(args []Value, writer *RESPWriter) error {
+	pairs, errMsg := parseKeyValues("MSETNX", args)
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+
+	written, err := h.cachesrv.MSetNX(pairs)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if written {
+		return writer.WriteInteger(1)
+	}
+	return writer.WriteInteger(0)
+}
+
+// RESP: *2\r\n$6\r\nGETDEL\r\n$5\r\nmykey\r\n
+// Pattern: GETDEL key
+// Example: GETDEL mykey → "hello" (and the key is deleted)
+// Example: GETDEL nonexistent → (nil)
+func (h *RESPHandler) handleGetDel(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("GETDEL"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	value, exists, err := h.cachesrv.GetDel(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if !exists {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(value)
+}
+
+// RESP: *4\r\n$5\r\nGETEX\r\n$5\r\nmykey\r\n$2\r\nEX\r\n$2\r\n60\r\n
+// Pattern: GETEX key [EX seconds|PX milliseconds|EXAT unix-seconds|PXAT unix-milliseconds|PERSIST]
+// Example: GETEX mykey EX 60 → "hello" (expires in 60s)
+// Example: GETEX mykey PERSIST → "hello" (TTL removed)
+// Returns: the value like GET, changing the TTL of the key as asked
+func (h *RESPHandler) handleGetEx(args []Value, writer *RESPWriter) error {
+	if len(args) < 1 {
+		return writer.WriteError(wrongArgs("GETEX"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	var expiresAt time.Time
+	update := false
+
+	for i := 1; i < len(args); i++ {
+		if update {
+			return writer.WriteError(ERRSyntexError)
+		}
+		update = true
+
+		option := strings.ToUpper(string(args[i].Bulk))
+		if option == "PERSIST" {
+			continue
+		}
+		if (option != "EX" && option != "PX" && option != "EXAT" && option != "PXAT") || i+1 >= len(args) {
+			return writer.WriteError(ERRSyntexError)
+		}
+
+		n, err := strconv.ParseInt(string(args[i+1].Bulk), 10, 64)
+		if err != nil {
+			return writer.WriteError(ERRNotInteger)
+		}
+
+		seconds := option == "EX" || option == "EXAT"
+		relative := option == "EX" || option == "PX"
+		ms, ok := expireMillis(n, seconds, relative)
+		if n <= 0 || !ok {
+			return writer.WriteError("ERR invalid expire time in 'getex' command")
+		}
+		expiresAt = time.UnixMilli(ms).UTC()
+		i++
+	}
+
+	value, exists, err := h.cachesrv.GetEx(string(args[0].Bulk), expiresAt, update)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if !exists {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(value)
+}
+
+// RESP: *3\r\n$6\r\nGETSET\r\n$5\r\nmykey\r\n$5\r\nworld\r\n
+// Pattern: GETSET key value
+// Example: GETSET mykey "world" → "hello"
+// Returns: the previous value, or null when there was none
+func (h *RESPHandler) handleGetSet(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("GETSET"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	old, existed, err := h.cachesrv.GetSet(string(args[0].Bulk), args[1].Bulk)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if !existed {
+		return writer.WriteNull()
+	}
+	return writer.WriteBulk(old)
+}
+
+func parseKeyValues(command string, args []Value) ([]internal.KeyValue, string) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, wrongArgs(command)
+	}
+
+	if !allBulk(args) {
+		return nil, ERRWrongArgumentType
+	}
+
+	pairs := make([]internal.KeyValue, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		pairs = append(pairs, internal.KeyValue{Key: string(args[i].Bulk), Value: args[i+1].Bulk})
+	}
+	return pairs, ""
+}
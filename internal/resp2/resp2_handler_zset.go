@@ -0,0 +1,625 @@
+package resp2
+
+import (
+	"cago/internal"
+	"context"
+	"strconv"
+	"strings"
+)
+
+const (
+	ERRMinMaxNotFloat = "ERR min or max is not a float"
+	ERRMinMaxNotLex   = "ERR min or max not valid string range item"
+)
+
+// RESP: *4\r\n$4\r\nZADD\r\n$5\r\nboard\r\n$2\r\n10\r\n$5\r\nalice\r\n
+// Pattern: ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+// Example: ZADD board 10 "alice" 20 "bob" → 2
+// Example: ZADD board XX CH 15 "alice" → 1 (updated, nothing added)
+// Example: ZADD board INCR 5 "alice" → "20"
+// Returns: number of members added, or also updated with CH. With INCR the
+// new score, or null when the options prevented the update
+func (h *RESPHandler) handleZAdd(args []Value, writer *RESPWriter) error {
+	if len(args) < 3 {
+		return writer.WriteError(wrongArgs("ZADD"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	var opts internal.ZAddOptions
+	incr := false
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Bulk)) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			opts.CH = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return writer.WriteError(ERRSyntexError)
+	}
+	if opts.NX && opts.XX {
+		return writer.WriteError("ERR XX and NX options at the same time are not compatible")
+	}
+	if (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		return writer.WriteError("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) > 2 {
+		return writer.WriteError("ERR INCR option supports a single increment-element pair")
+	}
+
+	members := make([]internal.ZMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseFloat(pairs[j].Bulk)
+		if !ok {
+			return writer.WriteError(ERRNotFloat)
+		}
+		members = append(members, internal.ZMember{Member: string(pairs[j+1].Bulk), Score: score})
+	}
+
+	key := string(args[0].Bulk)
+	if incr {
+		score, ok, err := h.cachesrv.ZAddIncr(key, members[0].Member, members[0].Score, opts)
+		if err != nil {
+			return writer.WriteError(formatError(err))
+		}
+		if !ok {
+			return writer.WriteNull()
+		}
+		return writer.WriteDouble(score)
+	}
+
+	n, err := h.cachesrv.ZAdd(key, members, opts)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(n))
+}
+
+// RESP: *3\r\n$4\r\nZREM\r\n$5\r\nboard\r\n$5\r\nalice\r\n
+// Pattern: ZREM key member [member ...]
+// Example: ZREM board "alice" "missing" → 1
+// Returns: number of members removed, the key is removed with its last
+// member
+func (h *RESPHandler) handleZRem(args []Value, writer *RESPWriter) error {
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs("ZREM"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	removed, err := h.cachesrv.ZRem(string(args[0].Bulk), bulkStrings(args[1:]))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(removed))
+}
+
+// RESP: *3\r\n$6\r\nZSCORE\r\n$5\r\nboard\r\n$5\r\nalice\r\n
+// Pattern: ZSCORE key member
+// Example: ZSCORE board "alice" → "10"
+// Example: ZSCORE board "missing" → (nil)
+// Returns: the score, a double in RESP3
+func (h *RESPHandler) handleZScore(args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs("ZSCORE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	score, found, err := h.cachesrv.ZScore(string(args[0].Bulk), string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if !found {
+		return writer.WriteNull()
+	}
+	return writer.WriteDouble(score)
+}
+
+// RESP: *4\r\n$7\r\nZINCRBY\r\n$5\r\nboard\r\n$3\r\n2.5\r\n$5\r\nalice\r\n
+// Pattern: ZINCRBY key increment member
+// Example: ZINCRBY board 2.5 "alice" → "12.5"
+// Returns: the new score, members missing are added with increment
+func (h *RESPHandler) handleZIncrBy(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("ZINCRBY"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	delta, ok := parseFloat(args[1].Bulk)
+	if !ok {
+		return writer.WriteError(ERRNotFloat)
+	}
+
+	score, err := h.cachesrv.ZIncrBy(string(args[0].Bulk), string(args[2].Bulk), delta)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteDouble(score)
+}
+
+// RESP: *2\r\n$5\r\nZCARD\r\n$5\r\nboard\r\n
+// Pattern: ZCARD key
+// Example: ZCARD board → 2
+// Example: ZCARD nonexistent → 0
+func (h *RESPHandler) handleZCard(args []Value, writer *RESPWriter) error {
+	if len(args) != 1 {
+		return writer.WriteError(wrongArgs("ZCARD"))
+	}
+
+	if args[0].Type != BulkString {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	card, err := h.cachesrv.ZCard(string(args[0].Bulk))
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(card))
+}
+
+// RESP: *3\r\n$5\r\nZRANK\r\n$5\r\nboard\r\n$3\r\nbob\r\n
+// Pattern: ZRANK key member
+// Example: ZRANK board "bob" → 1
+// Example: ZREVRANK board "bob" → 0 (highest score first)
+// Returns: 0-based position of member by ascending score, or null when it
+// is missing. ZREVRANK counts from the highest score
+func (h *RESPHandler) handleZRank(command string, rev bool, args []Value, writer *RESPWriter) error {
+	if len(args) != 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	rank, found, err := h.cachesrv.ZRank(string(args[0].Bulk), string(args[1].Bulk), rev)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if !found {
+		return writer.WriteNull()
+	}
+	return writer.WriteInteger(int64(rank))
+}
+
+// RESP: *5\r\n$6\r\nZRANGE\r\n$5\r\nboard\r\n$1\r\n0\r\n$2\r\n-1\r\n$10\r\nWITHSCORES\r\n
+// Pattern: ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// Example: ZRANGE board 0 -1 WITHSCORES → ["alice", "10", "bob", "20"]
+// Example: ZRANGE board (10 +inf BYSCORE → ["bob"]
+// Example: ZRANGE board +inf -inf BYSCORE REV LIMIT 0 1 → ["bob"]
+// Example: ZRANGE names [a (c BYLEX → ["alice", "bob"]
+// Returns: members in the range by rank, score or member, ascending unless
+// REV, which also takes the range from max to min. With WITHSCORES each
+// member is followed by its score, or paired with it in RESP3
+func (h *RESPHandler) handleZRange(args []Value, writer *RESPWriter) error {
+	if len(args) < 3 {
+		return writer.WriteError(wrongArgs("ZRANGE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	spec, withScores, errMsg := parseZRange(args[1:], true)
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+
+	members, err := h.cachesrv.ZRange(string(args[0].Bulk), spec)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writeZMembers(writer, members, withScores)
+}
+
+// RESP: *5\r\n$11\r\nZRANGESTORE\r\n$3\r\ntop\r\n$5\r\nboard\r\n$1\r\n0\r\n$1\r\n9\r\n
+// Pattern: ZRANGESTORE dst src start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+// Example: ZRANGESTORE top board 0 9 REV → 2
+// Returns: size of the sorted set stored under dst, which is overwritten
+// whatever it held, or removed when the range is empty
+func (h *RESPHandler) handleZRangeStore(args []Value, writer *RESPWriter) error {
+	if len(args) < 4 {
+		return writer.WriteError(wrongArgs("ZRANGESTORE"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	spec, _, errMsg := parseZRange(args[2:], false)
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+
+	card, err := h.cachesrv.ZRangeStore(string(args[0].Bulk), string(args[1].Bulk), spec)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(card))
+}
+
+// RESP: *4\r\n$6\r\nZCOUNT\r\n$5\r\nboard\r\n$2\r\n10\r\n$4\r\n+inf\r\n
+// Pattern: ZCOUNT key min max
+// Example: ZCOUNT board 10 +inf → 2
+// Example: ZCOUNT board (10 20 → 1 (10 excluded)
+func (h *RESPHandler) handleZCount(args []Value, writer *RESPWriter) error {
+	if len(args) != 3 {
+		return writer.WriteError(wrongArgs("ZCOUNT"))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	r, ok := parseScoreRange(args[1].Bulk, args[2].Bulk)
+	if !ok {
+		return writer.WriteError(ERRMinMaxNotFloat)
+	}
+
+	count, err := h.cachesrv.ZCount(string(args[0].Bulk), r)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(count))
+}
+
+// RESP: *2\r\n$7\r\nZPOPMIN\r\n$5\r\nboard\r\n
+// Pattern: ZPOPMIN key [count]
+// Example: ZPOPMIN board → ["alice", "10"]
+// Example: ZPOPMAX board 5 → ["bob", "20"] (fewer members than requested)
+// Returns: the members with the lowest scores, each followed by its score,
+// removed from the set. ZPOPMAX pops the highest scores
+func (h *RESPHandler) handleZPop(command string, max bool, args []Value, writer *RESPWriter) error {
+	if len(args) != 1 && len(args) != 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1].Bulk))
+		if err != nil || n < 0 {
+			return writer.WriteError(ERRNotPositive)
+		}
+		count = n
+	}
+
+	members, err := h.cachesrv.ZPop(string(args[0].Bulk), max, count)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	if len(args) == 2 {
+		return writeZMembers(writer, members, true)
+	}
+
+	// A single pop is a flat pair even in RESP3.
+	if err := writer.WriteArray(2 * len(members)); err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err := writer.WriteBulkString(m.Member); err != nil {
+			return err
+		}
+		if err := writer.WriteDouble(m.Score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RESP: *3\r\n$8\r\nBZPOPMIN\r\n$5\r\nboard\r\n$1\r\n5\r\n
+// Pattern: BZPOPMIN key [key ...] timeout
+// Example: BZPOPMIN board 5 → ["board", "alice", "10"]
+// Example: BZPOPMIN empty 0.1 → (nil) (after 100ms)
+// Returns: the key, member and score popped from the first non-empty
+// sorted set, waiting up to timeout seconds, 0 for ever, when all are
+// empty. BZPOPMAX pops the highest score
+func (h *RESPHandler) handleBZPop(command string, max bool, args []Value, client *Client) error {
+	writer := client.writer
+
+	if len(args) < 2 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	timeout, errMsg := parseTimeout(args[len(args)-1].Bulk)
+	if errMsg != "" {
+		return writer.WriteError(errMsg)
+	}
+	if client.inExec {
+		timeout = internal.NoWait
+	}
+
+	ctx, stop := client.watchClose()
+	key, m, ok, err := h.cachesrv.BZPop(ctx, bulkStrings(args[:len(args)-1]), max, timeout)
+	stop()
+
+	if err == context.Canceled {
+		return err
+	}
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+	if !ok {
+		return writer.WriteNullArray()
+	}
+
+	if err := writer.WriteArray(3); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(key); err != nil {
+		return err
+	}
+	if err := writer.WriteBulkString(m.Member); err != nil {
+		return err
+	}
+	return writer.WriteDouble(m.Score)
+}
+
+// RESP: *5\r\n$11\r\nZUNIONSTORE\r\n$3\r\nout\r\n$1\r\n2\r\n$5\r\nboard\r\n$6\r\nbonus\r\n
+// Pattern: ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+// Example: ZUNIONSTORE out 2 board bonus WEIGHTS 1 2 → 3
+// Example: ZINTERSTORE out 2 board bonus AGGREGATE MAX → 1
+// Returns: size of the sorted set stored under destination, which is
+// overwritten whatever it held. Scores are multiplied by the weight of
+// their key and summed, or reduced by AGGREGATE, across keys. ZINTERSTORE
+// keeps only members found under every key
+func (h *RESPHandler) handleZSetOpStore(command string, op internal.SetOp, args []Value, writer *RESPWriter) error {
+	if len(args) < 3 {
+		return writer.WriteError(wrongArgs(command))
+	}
+
+	if !allBulk(args) {
+		return writer.WriteError(ERRWrongArgumentType)
+	}
+
+	numKeys, err := strconv.Atoi(string(args[1].Bulk))
+	if err != nil {
+		return writer.WriteError(ERRNotInteger)
+	}
+	if numKeys < 1 {
+		return writer.WriteError("ERR at least 1 input key is needed for '" + strings.ToLower(command) + "' command")
+	}
+	if numKeys > len(args)-2 {
+		return writer.WriteError(ERRSyntexError)
+	}
+	keys := bulkStrings(args[2 : 2+numKeys])
+
+	var weights []float64
+	agg := internal.ZAggSum
+	for i := 2 + numKeys; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i].Bulk)) {
+		case "WEIGHTS":
+			if i+numKeys >= len(args) {
+				return writer.WriteError(ERRSyntexError)
+			}
+			weights = make([]float64, numKeys)
+			for j := range weights {
+				w, ok := parseFloat(args[i+1+j].Bulk)
+				if !ok {
+					return writer.WriteError("ERR weight value is not a float")
+				}
+				weights[j] = w
+			}
+			i += numKeys
+		case "AGGREGATE":
+			if i+1 >= len(args) {
+				return writer.WriteError(ERRSyntexError)
+			}
+			switch strings.ToUpper(string(args[i+1].Bulk)) {
+			case "SUM":
+				agg = internal.ZAggSum
+			case "MIN":
+				agg = internal.ZAggMin
+			case "MAX":
+				agg = internal.ZAggMax
+			default:
+				return writer.WriteError(ERRSyntexError)
+			}
+			i++
+		default:
+			return writer.WriteError(ERRSyntexError)
+		}
+	}
+
+	card, err := h.cachesrv.ZSetOpStore(op, string(args[0].Bulk), keys, weights, agg)
+	if err != nil {
+		return writer.WriteError(formatError(err))
+	}
+
+	return writer.WriteInteger(int64(card))
+}
+
+// parseZRange parses the start, stop and options of ZRANGE and ZRANGESTORE,
+// which has no WITHSCORES.
+func parseZRange(args []Value, allowScores bool) (internal.ZRangeSpec, bool, string) {
+	spec := internal.ZRangeSpec{Count: -1}
+	withScores, limited := false, false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i].Bulk)); {
+		case option == "BYSCORE":
+			spec.By = internal.ZByScore
+		case option == "BYLEX":
+			spec.By = internal.ZByLex
+		case option == "REV":
+			spec.Rev = true
+		case option == "WITHSCORES" && allowScores:
+			withScores = true
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err := strconv.Atoi(string(args[i+1].Bulk))
+			if err != nil {
+				return spec, false, ERRNotInteger
+			}
+			count, err := strconv.Atoi(string(args[i+2].Bulk))
+			if err != nil {
+				return spec, false, ERRNotInteger
+			}
+			spec.Offset, spec.Count = offset, count
+			limited = true
+			i += 2
+		default:
+			return spec, false, ERRSyntexError
+		}
+	}
+
+	if limited && spec.By == internal.ZByRank {
+		return spec, false, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"
+	}
+	if withScores && spec.By == internal.ZByLex {
+		return spec, false, "ERR syntax error, WITHSCORES not supported in combination with BYLEX"
+	}
+
+	// With REV, score and lex ranges are given from max to min.
+	minArg, maxArg := args[0].Bulk, args[1].Bulk
+	if spec.Rev && spec.By != internal.ZByRank {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	switch spec.By {
+	case internal.ZByScore:
+		r, ok := parseScoreRange(minArg, maxArg)
+		if !ok {
+			return spec, false, ERRMinMaxNotFloat
+		}
+		spec.Score = r
+	case internal.ZByLex:
+		lexMin, okMin := parseLexBound(minArg)
+		lexMax, okMax := parseLexBound(maxArg)
+		if !okMin || !okMax {
+			return spec, false, ERRMinMaxNotLex
+		}
+		spec.Lex = internal.LexRange{Min: lexMin, Max: lexMax}
+	default:
+		start, err := strconv.Atoi(string(minArg))
+		if err != nil {
+			return spec, false, ERRNotInteger
+		}
+		stop, err := strconv.Atoi(string(maxArg))
+		if err != nil {
+			return spec, false, ERRNotInteger
+		}
+		spec.Start, spec.Stop = start, stop
+	}
+	return spec, withScores, ""
+}
+
+// parseScoreRange parses min and max scores, each exclusive when prefixed
+// with "(". Infinities are written -inf and +inf.
+func parseScoreRange(minArg, maxArg []byte) (internal.ScoreRange, bool) {
+	var r internal.ScoreRange
+	var okMin, okMax bool
+	r.Min, r.MinEx, okMin = parseScoreBound(minArg)
+	r.Max, r.MaxEx, okMax = parseScoreBound(maxArg)
+	return r, okMin && okMax
+}
+
+func parseScoreBound(arg []byte) (float64, bool, bool) {
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, ok := parseFloat(arg)
+	return score, exclusive, ok
+}
+
+// parseLexBound parses "[member" or "(member", inclusive and exclusive, or
+// "-" and "+" for the ends of the set.
+func parseLexBound(arg []byte) (internal.LexBound, bool) {
+	if len(arg) == 0 {
+		return internal.LexBound{}, false
+	}
+
+	switch arg[0] {
+	case '-':
+		return internal.LexBound{Inf: -1}, len(arg) == 1
+	case '+':
+		return internal.LexBound{Inf: 1}, len(arg) == 1
+	case '[':
+		return internal.LexBound{Value: string(arg[1:])}, true
+	case '(':
+		return internal.LexBound{Value: string(arg[1:]), Exclusive: true}, true
+	}
+	return internal.LexBound{}, false
+}
+
+// writeZMembers writes members, each followed by its score when withScores,
+// as a flat array in RESP2 and as [member, score] pairs in RESP3.
+func writeZMembers(writer *RESPWriter, members []internal.ZMember, withScores bool) error {
+	if !withScores {
+		if err := writer.WriteArray(len(members)); err != nil {
+			return err
+		}
+		for _, m := range members {
+			if err := writer.WriteBulkString(m.Member); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	pairs := writer.Protocol() == 3
+	if pairs {
+		if err := writer.WriteArray(len(members)); err != nil {
+			return err
+		}
+	} else if err := writer.WriteArray(2 * len(members)); err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		if pairs {
+			if err := writer.WriteArray(2); err != nil {
+				return err
+			}
+		}
+		if err := writer.WriteBulkString(m.Member); err != nil {
+			return err
+		}
+		if err := writer.WriteDouble(m.Score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
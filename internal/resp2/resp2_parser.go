@@ -2,16 +2,30 @@ package resp2
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
-	"strings"
 )
 
 var (
-	ErrInvalidProtocol = errors.New("invalid RESP2 protocol")
-	ErrInvalidType     = errors.New("invalid RESP2 type")
+	ErrInvalidProtocol = errors.New("invalid RESP protocol")
+	ErrInvalidType     = errors.New("invalid RESP type")
+)
+
+// maxBulkLen caps the size of a single bulk string, like Redis'
+// proto-max-bulk-len, so a bogus length cannot trigger a huge allocation.
+const maxBulkLen = 512 << 20
+
+// maxAggregateLen caps the number of elements of an aggregate, like the
+// multibulk length limit of Redis, and maxPrealloc the elements allocated
+// before any of them was read. Past that the elements are allocated as
+// they arrive, so memory grows with the input rather than with the header.
+const (
+	maxAggregateLen = math.MaxInt32
+	maxPrealloc     = 1024
 )
 
 const (
@@ -22,15 +36,37 @@ const (
 	Array        = '*'
 )
 
+// RESP3 types, used once a connection negotiated protocol 3 with HELLO.
+const (
+	Null           = '_'
+	Boolean        = '#'
+	Double         = ','
+	BigNumber      = '('
+	BulkError      = '!'
+	VerbatimString = '='
+	Map            = '%'
+	Set            = '~'
+	Push           = '>'
+)
+
+// Value is a parsed RESP value. Maps keep their keys and values interleaved
+// in Array. BigNumber keeps its digits in Str, and VerbatimString keeps its
+// format, such as "txt", in Str and the text in Bulk.
 type Value struct {
 	Type   byte
 	Str    string
 	Int    int64
-	Bulk   string
+	Bulk   []byte
 	Array  []Value
 	IsNull bool
+	Bool   bool
+	Double float64
 }
 
+// RESPParser reads RESP values from a buffered connection. Header lines are
+// read in place from the buffer and their numbers decoded from the bytes,
+// and the elements of an aggregate are decoded straight into its array, so
+// the only copies made are of the payloads the value keeps.
 type RESPParser struct {
 	reader *bufio.Reader
 }
@@ -42,129 +78,391 @@ func NewRESPParser(r io.Reader) *RESPParser {
 }
 
 func (p *RESPParser) Parse() (*Value, error) {
+	v := &Value{}
+	if err := p.parseValue(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Buffered returns how many bytes were received but not parsed yet. Once
+// it is 0 the client is waiting for the replies to what it sent.
+func (p *RESPParser) Buffered() int {
+	return p.reader.Buffered()
+}
+
+func (p *RESPParser) parseValue(v *Value) error {
 	typeByte, err := p.reader.ReadByte()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	switch typeByte {
 	case SimpleString:
-		return p.parseSimpleString()
+		return p.parseSimpleString(v)
 	case Error:
-		return p.parseError()
+		return p.parseError(v)
 	case Integer:
-		return p.parseInt()
+		return p.parseInt(v)
 	case BulkString:
-		return p.parseBulkString()
+		return p.parseBulkString(v)
 	case Array:
-		return p.parseArray()
+		return p.parseArray(v)
+	case Null:
+		return p.parseNull(v)
+	case Boolean:
+		return p.parseBoolean(v)
+	case Double:
+		return p.parseDouble(v)
+	case BigNumber:
+		return p.parseBigNumber(v)
+	case BulkError:
+		return p.parseBulkError(v)
+	case VerbatimString:
+		return p.parseVerbatimString(v)
+	case Map:
+		return p.parseAggregate(v, Map, 2)
+	case Set:
+		return p.parseAggregate(v, Set, 1)
+	case Push:
+		return p.parseAggregate(v, Push, 1)
 	default:
-		return nil, fmt.Errorf("%w: unknown type %c", ErrInvalidType, typeByte)
+		return fmt.Errorf("%w: unknown type %c", ErrInvalidType, typeByte)
 	}
 }
 
-func (p *RESPParser) readLine() (string, error) {
-	line, err := p.reader.ReadString('\n')
+// waitInput blocks until more input is available without consuming it.
+func (p *RESPParser) waitInput() error {
+	_, err := p.reader.Peek(1)
+	return err
+}
+
+// readLine returns the next line without its line ending. The line points
+// into the read buffer and is only valid until the next read.
+func (p *RESPParser) readLine() ([]byte, error) {
+	line, err := p.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// Only simple strings and errors can be longer than the buffer.
+		long := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			line, err = p.reader.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		line = long
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	line = strings.TrimSuffix(line, "\r\n")
-	line = strings.TrimSuffix(line, "\n")
+	line = bytes.TrimSuffix(line, []byte("\r\n"))
+	line = bytes.TrimSuffix(line, []byte("\n"))
 	return line, nil
 }
 
-func (p *RESPParser) parseSimpleString() (*Value, error) {
+// readNumber reads a line holding a decimal integer, such as a length.
+func (p *RESPParser) readNumber() (int64, bool, error) {
 	line, err := p.readLine()
 	if err != nil {
-		return nil, err
+		return 0, false, err
 	}
 
-	return &Value{Type: SimpleString, Str: line}, nil
+	num, ok := parseNumber(line)
+	return num, ok, nil
+}
+
+// parseNumber decodes the decimal integer in b like strconv.ParseInt,
+// without turning it into a string first.
+func parseNumber(b []byte) (int64, bool) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg || (len(b) > 0 && b[0] == '+') {
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 19 {
+		return 0, false
+	}
+
+	var num uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		num = num*10 + uint64(c-'0')
+	}
+
+	if neg {
+		if num > 1<<63 {
+			return 0, false
+		}
+		return -int64(num), true
+	}
+	if num > math.MaxInt64 {
+		return 0, false
+	}
+	return int64(num), true
 }
 
-func (p *RESPParser) parseError() (*Value, error) {
+func (p *RESPParser) parseSimpleString(v *Value) error {
 	line, err := p.readLine()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &Value{Type: Error, Str: line}, nil
+	*v = Value{Type: SimpleString, Str: string(line)}
+	return nil
 }
 
-func (p *RESPParser) parseInt() (*Value, error) {
+func (p *RESPParser) parseError(v *Value) error {
 	line, err := p.readLine()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	num, err := strconv.ParseInt(line, 10, 64)
+	*v = Value{Type: Error, Str: string(line)}
+	return nil
+}
+
+func (p *RESPParser) parseInt(v *Value) error {
+	num, ok, err := p.readNumber()
 	if err != nil {
-		return nil, fmt.Errorf("%w: invalid integer", ErrInvalidProtocol)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: invalid integer", ErrInvalidProtocol)
 	}
 
-	return &Value{Type: Integer, Int: num}, nil
+	*v = Value{Type: Integer, Int: num}
+	return nil
 }
 
-func (p *RESPParser) parseBulkString() (*Value, error) {
-	line, err := p.readLine()
+func (p *RESPParser) parseBulkString(v *Value) error {
+	bulk, isNull, err := p.readBulk()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if isNull {
+		*v = Value{Type: BulkString, IsNull: true}
+		return nil
 	}
 
-	length, err := strconv.Atoi(line)
+	*v = Value{Type: BulkString, Bulk: bulk}
+	return nil
+}
+
+// readBulk reads the length-prefixed payload shared by bulk strings, bulk
+// errors and verbatim strings. The payload is copied out of the read
+// buffer, as commands keep their arguments, e.g. as the value of a key.
+func (p *RESPParser) readBulk() ([]byte, bool, error) {
+	length, ok, err := p.readNumber()
 	if err != nil {
-		return nil, fmt.Errorf("%w: invalid bulk string length", ErrInvalidProtocol)
+		return nil, false, err
+	}
+
+	if !ok {
+		return nil, false, fmt.Errorf("%w: invalid bulk string length", ErrInvalidProtocol)
 	}
 
 	if length == -1 {
-		return &Value{Type: BulkString, IsNull: true}, nil
+		return nil, true, nil
 	}
 
 	if length < 0 {
-		return nil, fmt.Errorf("%w: negative bulk string length", ErrInvalidProtocol)
+		return nil, false, fmt.Errorf("%w: negative bulk string length", ErrInvalidProtocol)
+	}
+
+	if length > maxBulkLen {
+		return nil, false, fmt.Errorf("%w: bulk string length exceeds %d bytes", ErrInvalidProtocol, maxBulkLen)
 	}
 
 	bulk := make([]byte, length)
 	_, err = io.ReadFull(p.reader, bulk)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	_, err = p.readLine()
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	return bulk, false, nil
+}
+
+func (p *RESPParser) parseNull(v *Value) error {
+	if _, err := p.readLine(); err != nil {
+		return err
 	}
 
-	return &Value{Type: BulkString, Bulk: string(bulk)}, nil
+	*v = Value{Type: Null, IsNull: true}
+	return nil
 }
 
-func (p *RESPParser) parseArray() (*Value, error) {
+func (p *RESPParser) parseBoolean(v *Value) error {
 	line, err := p.readLine()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	count, err := strconv.Atoi(line)
+	switch string(line) {
+	case "t":
+		*v = Value{Type: Boolean, Bool: true}
+	case "f":
+		*v = Value{Type: Boolean, Bool: false}
+	default:
+		return fmt.Errorf("%w: invalid boolean", ErrInvalidProtocol)
+	}
+	return nil
+}
+
+func (p *RESPParser) parseDouble(v *Value) error {
+	line, err := p.readLine()
 	if err != nil {
-		return nil, fmt.Errorf("%w: invalid array length", ErrInvalidProtocol)
+		return err
 	}
 
-	if count == -1 {
-		return &Value{Type: Array, IsNull: true}, nil
+	// ParseFloat accepts "inf", "-inf" and "nan" as RESP3 spells them.
+	num, err := strconv.ParseFloat(string(line), 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid double", ErrInvalidProtocol)
 	}
 
-	if count < 0 {
-		return nil, fmt.Errorf("%w: negative array length", ErrInvalidProtocol)
+	*v = Value{Type: Double, Double: num}
+	return nil
+}
+
+func (p *RESPParser) parseBigNumber(v *Value) error {
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+
+	digits := bytes.TrimPrefix(line, []byte("-"))
+	if len(digits) == 0 || len(bytes.Trim(digits, "0123456789")) != 0 {
+		return fmt.Errorf("%w: invalid big number", ErrInvalidProtocol)
+	}
+
+	*v = Value{Type: BigNumber, Str: string(line)}
+	return nil
+}
+
+func (p *RESPParser) parseBulkError(v *Value) error {
+	bulk, isNull, err := p.readBulk()
+	if err != nil {
+		return err
 	}
+	if isNull {
+		return fmt.Errorf("%w: null bulk error", ErrInvalidProtocol)
+	}
+
+	*v = Value{Type: BulkError, Str: string(bulk)}
+	return nil
+}
 
-	array := make([]Value, count)
-	for i := range count {
-		val, err := p.Parse()
-		if err != nil {
+func (p *RESPParser) parseVerbatimString(v *Value) error {
+	bulk, isNull, err := p.readBulk()
+	if err != nil {
+		return err
+	}
+	if isNull || len(bulk) < 4 || bulk[3] != ':' {
+		return fmt.Errorf("%w: invalid verbatim string", ErrInvalidProtocol)
+	}
+
+	*v = Value{Type: VerbatimString, Str: string(bulk[:3]), Bulk: bulk[4:]}
+	return nil
+}
+
+// parseAggregate reads a map, set or push frame. Each of the count entries
+// is made of per values, two for maps.
+func (p *RESPParser) parseAggregate(v *Value, typ byte, per int) error {
+	count, ok, err := p.readNumber()
+	if err != nil {
+		return err
+	}
+
+	if !ok || count < 0 || count > maxAggregateLen {
+		return fmt.Errorf("%w: invalid aggregate length", ErrInvalidProtocol)
+	}
+
+	array, err := p.parseElements(int(count) * per)
+	if err != nil {
+		return err
+	}
+	*v = Value{Type: typ, Array: array}
+	return nil
+}
+
+// parseElements reads the n values of an aggregate.
+func (p *RESPParser) parseElements(n int) ([]Value, error) {
+	array := make([]Value, 0, min(n, maxPrealloc))
+	for range n {
+		array = append(array, Value{})
+		if err := p.parseValue(&array[len(array)-1]); err != nil {
 			return nil, err
 		}
-		array[i] = *val
 	}
-	return &Value{Type: Array, Array: array}, nil
+	return array, nil
+}
+
+func (p *RESPParser) parseArray(v *Value) error {
+	count, ok, err := p.readNumber()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: invalid array length", ErrInvalidProtocol)
+	}
+
+	if count == -1 {
+		*v = Value{Type: Array, IsNull: true}
+		return nil
+	}
+
+	if count < 0 {
+		return fmt.Errorf("%w: negative array length", ErrInvalidProtocol)
+	}
+
+	if count > maxAggregateLen {
+		return fmt.Errorf("%w: invalid array length", ErrInvalidProtocol)
+	}
+
+	array, err := p.parseElements(int(count))
+	if err != nil {
+		return err
+	}
+	*v = Value{Type: Array, Array: array}
+	return nil
+}
+
+// ReadBulkStream reads a bulk string header and hands its payload to fn as a
+// stream, so payloads larger than maxBulkLen, such as a replication
+// snapshot, never have to be buffered whole. Unread payload is discarded.
+func (p *RESPParser) ReadBulkStream(fn func(r io.Reader) error) error {
+	typeByte, err := p.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if typeByte != BulkString {
+		return fmt.Errorf("%w: expected bulk string, got %c", ErrInvalidType, typeByte)
+	}
+
+	length, ok, err := p.readNumber()
+	if err != nil {
+		return err
+	}
+	if !ok || length < 0 {
+		return fmt.Errorf("%w: invalid bulk string length", ErrInvalidProtocol)
+	}
+
+	payload := io.LimitReader(p.reader, length)
+	if err := fn(payload); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return err
+	}
+
+	_, err = p.readLine()
+	return err
 }
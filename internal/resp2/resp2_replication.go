@@ -0,0 +1,668 @@
+package resp2
+
+import (
+	"bytes"
+	"cago/internal"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// replPingInterval is how often a primary with replicas writes a PING
+	// into the stream, so replicas can tell an idle link from a dead one.
+	replPingInterval = 10 * time.Second
+	replTimeout      = 60 * time.Second
+	replAckInterval  = time.Second
+	replRetryDelay   = time.Second
+	// replReplicaQueue is how many stream chunks may be pending for one
+	// replica before it is considered too slow and disconnected.
+	replReplicaQueue = 16384
+)
+
+var (
+	ErrReplicaTooSlow = errors.New("replica output buffer overflow")
+	ErrNotReplicaLink = errors.New("master returned an unexpected reply")
+
+	// errReplicaDisconnected ends a connection that was serving a replica.
+	errReplicaDisconnected = errors.New("replica disconnected")
+)
+
+// Replication keeps the replication state of this server in either role.
+// As a primary it implements internal.Propagator: every write is appended
+// to the backlog and streamed to the connected replicas. As a replica it
+// runs a link to its primary and applies the received stream.
+type Replication struct {
+	cfg      *internal.Config
+	cachesrv *internal.CacheService
+	ctx      context.Context
+
+	// streamMu is held by a replica while it applies one command from its
+	// primary, and while a snapshot for a sub-replica is taken, so the
+	// snapshot never contains a command its offset does not cover.
+	streamMu sync.Mutex
+
+	mu sync.Mutex
+	// replID identifies the history the offsets belong to. replID2 is the
+	// history this server followed before being promoted, valid for partial
+	// syncs up to secondOffset.
+	replID       string
+	replID2      string
+	secondOffset int64
+	offset       int64
+	backlog      *replBacklog
+	replicas     map[*replica]struct{}
+
+	// Replica side; masterHost is empty while this server is a primary.
+	masterHost string
+	masterPort int
+	linkUp     bool
+	syncing    bool
+	lastIO     time.Time
+	stopLink   context.CancelFunc
+}
+
+// replica is a connected replica as seen from the primary.
+type replica struct {
+	addr      string
+	out       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu        sync.Mutex
+	ackOffset int64
+	ackTime   time.Time
+}
+
+func NewReplication(cfg *internal.Config, cachesrv *internal.CacheService, ctx context.Context) *Replication {
+	return &Replication{
+		cfg:          cfg,
+		cachesrv:     cachesrv,
+		ctx:          ctx,
+		replID:       newReplID(),
+		secondOffset: -1,
+		backlog:      newReplBacklog(cfg.ReplBacklogSize),
+		replicas:     make(map[*replica]struct{}),
+	}
+}
+
+// Run pings the replicas while this server is a primary.
+func (r *Replication) Run() {
+	ticker := time.NewTicker(replPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			if r.masterHost == "" && len(r.replicas) > 0 {
+				r.feedLocked(encodeCommand([][]byte{[]byte("PING")}))
+			}
+			r.mu.Unlock()
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Replication) Propagate(args [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// A replica forwards the stream received from its primary verbatim,
+	// so its own writes must not be fed a second time.
+	if r.masterHost != "" {
+		return
+	}
+
+	r.feedLocked(encodeCommand(args))
+}
+
+// feedLocked appends data to the backlog and queues it for every replica.
+func (r *Replication) feedLocked(data []byte) {
+	r.backlog.write(data)
+	r.offset += int64(len(data))
+
+	for rep := range r.replicas {
+		select {
+		case rep.out <- data:
+		default:
+			fmt.Printf("Disconnecting replica %s: %v\n", rep.addr, ErrReplicaTooSlow)
+			r.dropReplicaLocked(rep)
+		}
+	}
+}
+
+func (r *Replication) dropReplicaLocked(rep *replica) {
+	delete(r.replicas, rep)
+	rep.closeOnce.Do(func() { close(rep.done) })
+}
+
+// ReplicaOf starts replicating from host:port. The current dataset is kept
+// until the first full sync replaces it.
+func (r *Replication) ReplicaOf(host string, port int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.masterHost == host && r.masterPort == port {
+		return
+	}
+
+	r.stopLinkLocked()
+	for rep := range r.replicas {
+		r.dropReplicaLocked(rep)
+	}
+
+	r.masterHost = host
+	r.masterPort = port
+	r.cachesrv.SetReadOnly(true)
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.stopLink = cancel
+	go r.runLink(ctx, net.JoinHostPort(host, strconv.Itoa(port)))
+
+	fmt.Printf("Replicating from %s:%d\n", host, port)
+}
+
+// PromoteToMaster stops replicating and accepts writes again. The history
+// followed so far stays available to partial syncs under replID2, so the
+// other replicas of the old primary can switch over without a full sync.
+func (r *Replication) PromoteToMaster() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.masterHost == "" {
+		return
+	}
+
+	r.stopLinkLocked()
+	r.masterHost = ""
+	r.masterPort = 0
+	r.replID2 = r.replID
+	r.secondOffset = r.offset + 1
+	r.replID = newReplID()
+	r.cachesrv.SetReadOnly(false)
+
+	fmt.Println("Replication stopped, now a primary")
+}
+
+func (r *Replication) stopLinkLocked() {
+	if r.stopLink != nil {
+		r.stopLink()
+		r.stopLink = nil
+	}
+	r.linkUp = false
+	r.syncing = false
+}
+
+// ServeReplica answers PSYNC and turns the client connection into a
+// replication stream. It returns when the replica goes away.
+func (r *Replication) ServeReplica(client *Client, replID string, psyncOffset int64, listeningPort string) error {
+	host, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	rep := &replica{
+		addr: net.JoinHostPort(host, listeningPort),
+		out:  make(chan []byte, replReplicaQueue),
+		done: make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	if r.masterHost != "" && !r.linkUp {
+		r.mu.Unlock()
+		return client.writer.WriteError("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+
+	if pending, ok := r.backlogFromLocked(replID, psyncOffset); ok {
+		r.replicas[rep] = struct{}{}
+		id := r.replID
+		r.mu.Unlock()
+
+		fmt.Printf("Partial resynchronization with replica %s from offset %d\n", rep.addr, psyncOffset)
+		if err := client.writer.WriteSimpleString("CONTINUE " + id); err != nil {
+			return r.forgetReplica(rep, err)
+		}
+		if err := client.writer.WriteRaw(pending); err != nil {
+			return r.forgetReplica(rep, err)
+		}
+	} else {
+		r.mu.Unlock()
+
+		snapshot, size, id, offset, err := r.dumpForReplica(rep)
+		if err != nil {
+			return r.forgetReplica(rep, err)
+		}
+
+		fmt.Printf("Full resynchronization with replica %s at offset %d\n", rep.addr, offset)
+		err = client.writer.WriteSimpleString(fmt.Sprintf("FULLRESYNC %s %d", id, offset))
+		if err == nil {
+			err = client.writer.WriteBulkStream(snapshot, size)
+		}
+		snapshot.Close()
+		os.Remove(snapshot.Name())
+		if err != nil {
+			return r.forgetReplica(rep, err)
+		}
+	}
+
+	if err := client.writer.Flush(); err != nil {
+		return r.forgetReplica(rep, err)
+	}
+
+	go r.readAcks(client, rep)
+
+	for {
+		select {
+		case data := <-rep.out:
+			if err := client.writer.WriteRaw(data); err != nil {
+				return r.forgetReplica(rep, err)
+			}
+			// Writes queued meanwhile go out along with this one.
+			if len(rep.out) == 0 {
+				if err := client.writer.Flush(); err != nil {
+					return r.forgetReplica(rep, err)
+				}
+			}
+		case <-rep.done:
+			return r.forgetReplica(rep, nil)
+		case <-r.ctx.Done():
+			return r.forgetReplica(rep, nil)
+		}
+	}
+}
+
+// dumpForReplica takes the snapshot for the full sync of rep and registers
+// it, returning the snapshot as a temporary file in the data directory read
+// from the start, so a large dataset is neither held in memory nor sent
+// while the replication stream is frozen. The caller closes and removes the
+// file.
+func (r *Replication) dumpForReplica(rep *replica) (snapshot *os.File, size int64, id string, offset int64, err error) {
+	tmp, err := os.CreateTemp(r.cfg.Dir, "temp-sync-*.snap")
+	if err != nil {
+		return nil, 0, "", 0, err
+	}
+
+	// Registering the replica inside the frozen window makes the stream
+	// it receives start exactly where the snapshot ends.
+	r.streamMu.Lock()
+	err = r.cachesrv.DumpSnapshot(tmp, func() {
+		r.mu.Lock()
+		id, offset = r.replID, r.offset
+		r.replicas[rep] = struct{}{}
+		r.mu.Unlock()
+	})
+	r.streamMu.Unlock()
+
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, "", 0, err
+	}
+	return tmp, size, id, offset, nil
+}
+
+// backlogFromLocked returns the stream from psyncOffset on when the replica
+// asks for a history this server still holds in its backlog.
+func (r *Replication) backlogFromLocked(replID string, psyncOffset int64) ([]byte, bool) {
+	if replID != r.replID && (replID != r.replID2 || psyncOffset > r.secondOffset) {
+		return nil, false
+	}
+	return r.backlog.from(psyncOffset)
+}
+
+func (r *Replication) forgetReplica(rep *replica, err error) error {
+	r.mu.Lock()
+	r.dropReplicaLocked(rep)
+	r.mu.Unlock()
+
+	fmt.Printf("Replica %s disconnected\n", rep.addr)
+	return err
+}
+
+// readAcks consumes REPLCONF ACK <offset> sent by the replica.
+func (r *Replication) readAcks(client *Client, rep *replica) {
+	for {
+		cmd, err := client.parser.Parse()
+		if err != nil {
+			r.mu.Lock()
+			r.dropReplicaLocked(rep)
+			r.mu.Unlock()
+			return
+		}
+
+		if cmd.Type != Array || len(cmd.Array) != 3 {
+			continue
+		}
+		if !strings.EqualFold(string(cmd.Array[0].Bulk), "REPLCONF") || !strings.EqualFold(string(cmd.Array[1].Bulk), "ACK") {
+			continue
+		}
+
+		offset, err := strconv.ParseInt(string(cmd.Array[2].Bulk), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		rep.mu.Lock()
+		rep.ackOffset = offset
+		rep.ackTime = time.Now()
+		rep.mu.Unlock()
+	}
+}
+
+// runLink keeps a replica connected to its primary, reconnecting after
+// failures until ctx is cancelled.
+func (r *Replication) runLink(ctx context.Context, addr string) {
+	for {
+		err := r.syncWithMaster(ctx, addr)
+
+		r.mu.Lock()
+		r.linkUp = false
+		r.syncing = false
+		r.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("Replication link to %s lost: %v\n", addr, err)
+
+		select {
+		case <-time.After(replRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Replication) syncWithMaster(ctx context.Context, addr string) error {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	master := NewClient(ctx, 0, conn)
+	// The few commands sent to the primary must each go out at once.
+	master.writer = NewRESPWriter(conn)
+	conn.SetDeadline(time.Now().Add(replTimeout))
+
+	if err := r.handshake(master, "PING"); err != nil {
+		return err
+	}
+	if err := r.handshake(master, "REPLCONF", "listening-port", strconv.Itoa(r.cfg.Port)); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	replID, psyncOffset := r.replID, r.offset+1
+	r.syncing = true
+	r.mu.Unlock()
+
+	if err := master.writer.WriteCommand([]byte("PSYNC"), []byte(replID), []byte(strconv.FormatInt(psyncOffset, 10))); err != nil {
+		return err
+	}
+
+	reply, err := master.parser.Parse()
+	if err != nil {
+		return err
+	}
+	if reply.Type != SimpleString {
+		return fmt.Errorf("%w: %s", ErrNotReplicaLink, reply.Str)
+	}
+
+	fields := strings.Fields(reply.Str)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrNotReplicaLink, reply.Str)
+		}
+
+		// The snapshot can outlive the usual timeout on large datasets.
+		conn.SetDeadline(time.Time{})
+		loaded := 0
+		err = master.parser.ReadBulkStream(func(payload io.Reader) error {
+			n, err := r.cachesrv.RestoreSnapshot(payload)
+			loaded = n
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.replID = fields[1]
+		r.replID2 = ""
+		r.secondOffset = -1
+		r.offset = offset
+		r.backlog.reset(offset + 1)
+		r.mu.Unlock()
+
+		// The local log no longer matches the dataset, so rebuild it.
+		if err := r.cachesrv.RewriteAppendOnly(); err != nil && err != internal.ErrPersistenceDisabled {
+			fmt.Printf("AOF rewrite after full sync failed: %v\n", err)
+		}
+
+		fmt.Printf("Full sync from %s done, loaded %d keys\n", addr, loaded)
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		r.mu.Lock()
+		if len(fields) == 2 && fields[1] != r.replID {
+			r.replID2 = r.replID
+			r.secondOffset = r.offset + 1
+			r.replID = fields[1]
+		}
+		r.mu.Unlock()
+
+		fmt.Printf("Partial sync from %s accepted\n", addr)
+	default:
+		return fmt.Errorf("%w: %s", ErrNotReplicaLink, reply.Str)
+	}
+
+	r.mu.Lock()
+	r.syncing = false
+	r.linkUp = true
+	r.lastIO = time.Now()
+	r.mu.Unlock()
+
+	go r.sendAcks(ctx, master)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+
+		cmd, err := master.parser.Parse()
+		if err != nil {
+			return err
+		}
+		if cmd.Type != Array || len(cmd.Array) == 0 {
+			return fmt.Errorf("%w: stream is not a command", ErrNotReplicaLink)
+		}
+
+		args := make([][]byte, len(cmd.Array))
+		for i, arg := range cmd.Array {
+			args[i] = arg.Bulk
+		}
+
+		r.streamMu.Lock()
+		if !strings.EqualFold(string(args[0]), "PING") {
+			unlock := shared(r.cachesrv, commands[strings.ToUpper(string(args[0]))], cmd.Array[1:])
+			if err := r.cachesrv.ApplyCommand(args); err != nil {
+				fmt.Printf("Replication apply error: %v\n", err)
+			}
+			unlock()
+		}
+
+		r.mu.Lock()
+		// The primary encodes commands canonically, so re-encoding yields
+		// the exact bytes received and keeps offsets in step with it.
+		r.feedLocked(encodeCommand(args))
+		r.lastIO = time.Now()
+		r.mu.Unlock()
+		r.streamMu.Unlock()
+	}
+}
+
+func (r *Replication) handshake(master *Client, args ...string) error {
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+
+	if err := master.writer.WriteCommand(cmd...); err != nil {
+		return err
+	}
+
+	reply, err := master.parser.Parse()
+	if err != nil {
+		return err
+	}
+	if reply.Type == Error {
+		return fmt.Errorf("%w: %s", ErrNotReplicaLink, reply.Str)
+	}
+	return nil
+}
+
+func (r *Replication) sendAcks(ctx context.Context, master *Client) {
+	ticker := time.NewTicker(replAckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			offset := r.offset
+			r.mu.Unlock()
+
+			err := master.writer.WriteCommand([]byte("REPLCONF"), []byte("ACK"), []byte(strconv.FormatInt(offset, 10)))
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Info renders the replication section of INFO.
+func (r *Replication) Info() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# Replication\r\n")
+
+	if r.masterHost == "" {
+		b.WriteString("role:master\r\n")
+		fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(r.replicas))
+
+		i := 0
+		for rep := range r.replicas {
+			rep.mu.Lock()
+			host, port, _ := net.SplitHostPort(rep.addr)
+			lag := int64(-1)
+			if !rep.ackTime.IsZero() {
+				lag = int64(time.Since(rep.ackTime).Seconds())
+			}
+			fmt.Fprintf(&b, "slave%d:ip=%s,port=%s,state=online,offset=%d,lag=%d,offset_lag=%d\r\n",
+				i, host, port, rep.ackOffset, lag, r.offset-rep.ackOffset)
+			rep.mu.Unlock()
+			i++
+		}
+	} else {
+		linkStatus := "down"
+		if r.linkUp {
+			linkStatus = "up"
+		}
+		lastIO := int64(-1)
+		if !r.lastIO.IsZero() {
+			lastIO = int64(time.Since(r.lastIO).Seconds())
+		}
+		syncing := 0
+		if r.syncing {
+			syncing = 1
+		}
+
+		b.WriteString("role:slave\r\n")
+		fmt.Fprintf(&b, "master_host:%s\r\n", r.masterHost)
+		fmt.Fprintf(&b, "master_port:%d\r\n", r.masterPort)
+		fmt.Fprintf(&b, "master_link_status:%s\r\n", linkStatus)
+		fmt.Fprintf(&b, "master_last_io_seconds_ago:%d\r\n", lastIO)
+		fmt.Fprintf(&b, "master_sync_in_progress:%d\r\n", syncing)
+		fmt.Fprintf(&b, "slave_repl_offset:%d\r\n", r.offset)
+		b.WriteString("slave_read_only:1\r\n")
+		fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(r.replicas))
+	}
+
+	replID2 := r.replID2
+	if replID2 == "" {
+		replID2 = strings.Repeat("0", 40)
+	}
+
+	fmt.Fprintf(&b, "master_replid:%s\r\n", r.replID)
+	fmt.Fprintf(&b, "master_replid2:%s\r\n", replID2)
+	fmt.Fprintf(&b, "master_repl_offset:%d\r\n", r.offset)
+	fmt.Fprintf(&b, "second_repl_offset:%d\r\n", r.secondOffset)
+	b.WriteString("repl_backlog_active:1\r\n")
+	fmt.Fprintf(&b, "repl_backlog_size:%d\r\n", r.backlog.size)
+	fmt.Fprintf(&b, "repl_backlog_first_byte_offset:%d\r\n", r.backlog.firstOffset)
+	fmt.Fprintf(&b, "repl_backlog_histlen:%d\r\n", len(r.backlog.data))
+	return b.String()
+}
+
+func newReplID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// replBacklog keeps the last size bytes of the replication stream so a
+// replica that lost its link briefly can resume with a partial sync.
+type replBacklog struct {
+	size        int
+	data        []byte
+	firstOffset int64
+}
+
+func newReplBacklog(size int) *replBacklog {
+	return &replBacklog{size: size, firstOffset: 1}
+}
+
+func (b *replBacklog) write(p []byte) {
+	b.data = append(b.data, p...)
+
+	// Trim only once the buffer doubled, to amortize the copy.
+	if len(b.data) > 2*b.size {
+		drop := len(b.data) - b.size
+		b.data = append(b.data[:0], b.data[drop:]...)
+		b.firstOffset += int64(drop)
+	}
+}
+
+// from returns a copy of the stream starting at offset, if still held.
+func (b *replBacklog) from(offset int64) ([]byte, bool) {
+	if offset < b.firstOffset || offset > b.firstOffset+int64(len(b.data)) {
+		return nil, false
+	}
+	return bytes.Clone(b.data[offset-b.firstOffset:]), true
+}
+
+// reset empties the backlog, whose next byte will have stream offset next.
+func (b *replBacklog) reset(next int64) {
+	b.data = b.data[:0]
+	b.firstOffset = next
+}
@@ -0,0 +1,485 @@
+package resp2
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	ERRNoScript     = "NOSCRIPT No matching script. Please use EVAL."
+	ERRBusy         = "BUSY Busy running a script. You can only call SCRIPT KILL."
+	ERRNotBusy      = "NOTBUSY No scripts in execution right now."
+	ERRUnkillable   = "UNKILLABLE Sorry the script already executed write commands against the dataset. You can wait for the script to terminate."
+	ERRScriptKilled = "ERR Script killed by user with SCRIPT KILL..."
+	ERRStackLimit   = "ERR reached lua stack limit"
+)
+
+// maxLuaDepth is how deeply the tables returned by a script may nest.
+const maxLuaDepth = 1000
+
+// scriptCaller runs a command for redis.call and returns its reply.
+type scriptCaller func(command string, args []Value) *Value
+
+// scriptEngine runs Lua scripts and functions in a single interpreter, one
+// at a time, and caches scripts compiled by the SHA1 of their source.
+// Scripts get the base, table, string and math libraries and the redis
+// table, but no access to files, and cannot create global variables.
+type scriptEngine struct {
+	timeLimit time.Duration
+
+	// mu guards the interpreter, current, the script it runs, and the
+	// function libraries loaded into it.
+	mu        sync.Mutex
+	L         *lua.LState
+	current   *scriptRun
+	loading   *functionLibrary
+	libraries map[string]*functionLibrary
+	functions map[string]*luaFunction
+
+	// running is current, for the other clients checking on it.
+	running atomic.Pointer[scriptRun]
+
+	cacheMu sync.RWMutex
+	cache   map[string]*lua.FunctionProto
+}
+
+// scriptRun is one execution of a script.
+type scriptRun struct {
+	started time.Time
+	cancel  context.CancelFunc
+	call    scriptCaller
+	// readOnly is set for functions flagged no-writes.
+	readOnly bool
+
+	mu     sync.Mutex
+	wrote  bool
+	killed bool
+}
+
+func newScriptEngine(timeLimit time.Duration) *scriptEngine {
+	e := &scriptEngine{
+		timeLimit: timeLimit,
+		cache:     make(map[string]*lua.FunctionProto),
+		libraries: make(map[string]*functionLibrary),
+		functions: make(map[string]*luaFunction),
+	}
+	e.L = e.newState()
+	return e
+}
+
+// load compiles src unless it is cached already, and returns its SHA1.
+func (e *scriptEngine) load(src string) (string, *lua.FunctionProto, error) {
+	sum := sha1.Sum([]byte(src))
+	sha := hex.EncodeToString(sum[:])
+
+	if proto := e.lookup(sha); proto != nil {
+		return sha, proto, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(src), "user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+
+	e.cacheMu.Lock()
+	e.cache[sha] = proto
+	e.cacheMu.Unlock()
+	return sha, proto, nil
+}
+
+// lookup returns the cached script with the given SHA1, nil if unknown.
+func (e *scriptEngine) lookup(sha string) *lua.FunctionProto {
+	e.cacheMu.RLock()
+	defer e.cacheMu.RUnlock()
+
+	return e.cache[strings.ToLower(sha)]
+}
+
+func (e *scriptEngine) flush() {
+	e.cacheMu.Lock()
+	defer e.cacheMu.Unlock()
+
+	clear(e.cache)
+}
+
+// busy reports whether a script has been running for longer than the time
+// limit, during which other clients are told to wait or kill it.
+func (e *scriptEngine) busy() bool {
+	run := e.running.Load()
+	return run != nil && time.Since(run.started) > e.timeLimit
+}
+
+// kill stops the running script unless it already wrote to the dataset. It
+// returns the error to reply with, if any.
+func (e *scriptEngine) kill() string {
+	run := e.running.Load()
+	if run == nil {
+		return ERRNotBusy
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if run.wrote {
+		return ERRUnkillable
+	}
+	run.killed = true
+	run.cancel()
+	return ""
+}
+
+// run calls proto with KEYS and ARGV set, sending redis.call through call,
+// and writes what it returns. The caller must keep other clients out of the
+// dataset meanwhile.
+func (e *scriptEngine) run(proto *lua.FunctionProto, keys, argv [][]byte, call scriptCaller, writer *RESPWriter) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	L := e.L
+	L.G.Global.RawSetString("KEYS", luaStrings(L, keys))
+	L.G.Global.RawSetString("ARGV", luaStrings(L, argv))
+
+	return e.exec(L.NewFunctionFromProto(proto), nil, false, call, writer)
+}
+
+// exec calls fn with args as the current script. The caller must hold mu.
+func (e *scriptEngine) exec(fn *lua.LFunction, args []lua.LValue, readOnly bool, call scriptCaller, writer *RESPWriter) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	run := &scriptRun{started: time.Now(), cancel: cancel, call: call, readOnly: readOnly}
+	e.current = run
+	e.running.Store(run)
+	defer func() {
+		e.running.Store(nil)
+		e.current = nil
+	}()
+
+	L := e.L
+	L.SetContext(ctx)
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	err := L.PCall(len(args), 1, nil)
+	L.RemoveContext()
+
+	if err != nil {
+		if run.killed {
+			// The interpreter was stopped at an arbitrary point.
+			e.reset()
+			return writer.WriteError(ERRScriptKilled)
+		}
+		return writer.WriteError(scriptError(err))
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	if luaHeight(ret, 0, map[*lua.LTable]int{}) < 0 {
+		return writer.WriteError(ERRStackLimit)
+	}
+	return writeLua(writer, ret)
+}
+
+// reset replaces the interpreter with a fresh one and loads the function
+// libraries into it again. The caller must hold mu.
+func (e *scriptEngine) reset() {
+	e.L.Close()
+	e.L = e.newState()
+
+	for name, lib := range e.libraries {
+		reloaded, err := e.register(lib.code)
+		if err != nil {
+			fmt.Printf("Function library %s failed to reload: %v\n", name, err)
+			delete(e.libraries, name)
+			continue
+		}
+		e.libraries[name] = reloaded
+	}
+	e.functions = indexFunctions(e.libraries)
+}
+
+func (e *scriptEngine) newState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "module", "require", "_printregs"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":              func(L *lua.LState) int { return e.redisCall(L, false) },
+		"pcall":             func(L *lua.LState) int { return e.redisCall(L, true) },
+		"error_reply":       func(L *lua.LState) int { return pushReplyTable(L, "err", L.CheckString(1)) },
+		"status_reply":      func(L *lua.LState) int { return pushReplyTable(L, "ok", L.CheckString(1)) },
+		"sha1hex":           luaSha1Hex,
+		"register_function": e.registerFunction,
+		"log":               luaLog,
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redis.RawSetString(level, lua.LNumber(i))
+	}
+	L.SetGlobal("redis", redis)
+
+	guard := L.NewTable()
+	guard.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to create global variable '%s'", L.CheckString(2))
+		return 0
+	}))
+	guard.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.CheckString(2))
+		return 0
+	}))
+	L.SetMetatable(L.G.Global, guard)
+	return L
+}
+
+// redisCall implements redis.call, and redis.pcall when protected, which
+// returns errors as {err=...} tables instead of raising them.
+func (e *scriptEngine) redisCall(L *lua.LState, protected bool) int {
+	run := e.current
+
+	fail := func(msg string) int {
+		if protected {
+			return pushReplyTable(L, "err", msg)
+		}
+		tbl := L.NewTable()
+		tbl.RawSetString("err", lua.LString(msg))
+		L.Error(tbl, 1)
+		return 0
+	}
+
+	if e.loading != nil {
+		return fail("ERR redis.call can not be used while loading a library")
+	}
+	if L.GetTop() == 0 {
+		return fail("ERR Please specify at least one argument for this redis lib call")
+	}
+
+	args := make([]Value, L.GetTop())
+	for i := range args {
+		switch arg := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = Value{Type: BulkString, Bulk: []byte(arg)}
+		case lua.LNumber:
+			args[i] = Value{Type: BulkString, Bulk: []byte(arg.String())}
+		default:
+			return fail("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+
+	command := strings.ToUpper(string(args[0].Bulk))
+	cmd := commands[command]
+	switch {
+	case cmd == nil:
+		return fail("ERR Unknown Redis command called from script")
+	case cmd.is(flagNoScript):
+		return fail("ERR This Redis command is not allowed from script")
+	case !cmd.arityOK(len(args)):
+		return fail("ERR Wrong number of args calling Redis command from script")
+	}
+
+	if cmd.is(flagWrite) {
+		if run.readOnly {
+			return fail("ERR Write commands are not allowed from read-only scripts")
+		}
+
+		run.mu.Lock()
+		killed := run.killed
+		run.wrote = !killed
+		run.mu.Unlock()
+
+		if killed {
+			L.RaiseError("script killed")
+		}
+	}
+
+	reply := run.call(command, args[1:])
+	if reply.Type == Error {
+		return fail(reply.Str)
+	}
+	L.Push(luaValue(L, reply))
+	return 1
+}
+
+func pushReplyTable(L *lua.LState, field, msg string) int {
+	tbl := L.NewTable()
+	tbl.RawSetString(field, lua.LString(msg))
+	L.Push(tbl)
+	return 1
+}
+
+func luaSha1Hex(L *lua.LState) int {
+	sum := sha1.Sum([]byte(L.CheckString(1)))
+	L.Push(lua.LString(hex.EncodeToString(sum[:])))
+	return 1
+}
+
+func luaLog(L *lua.LState) int {
+	L.CheckInt(1)
+	parts := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	fmt.Printf("Script log: %s\n", strings.Join(parts, " "))
+	return 0
+}
+
+func luaStrings(L *lua.LState, vals [][]byte) *lua.LTable {
+	tbl := L.CreateTable(len(vals), 0)
+	for _, val := range vals {
+		tbl.Append(lua.LString(val))
+	}
+	return tbl
+}
+
+// luaValue converts a command reply for a script: integers to numbers,
+// nil to false, status replies to {ok=...} tables and arrays to tables.
+func luaValue(L *lua.LState, v *Value) lua.LValue {
+	switch v.Type {
+	case Integer:
+		return lua.LNumber(v.Int)
+	case BulkString:
+		if v.IsNull {
+			return lua.LFalse
+		}
+		return lua.LString(v.Bulk)
+	case SimpleString:
+		tbl := L.NewTable()
+		tbl.RawSetString("ok", lua.LString(v.Str))
+		return tbl
+	case Array:
+		if v.IsNull {
+			return lua.LFalse
+		}
+		tbl := L.CreateTable(len(v.Array), 0)
+		for i := range v.Array {
+			tbl.Append(luaValue(L, &v.Array[i]))
+		}
+		return tbl
+	}
+	return lua.LFalse
+}
+
+// writeLua writes the value returned by a script, converted the way back
+// from luaValue. Numbers are truncated to integers, true is 1 and tables
+// are read as arrays up to their first nil.
+func writeLua(writer *RESPWriter, lv lua.LValue) error {
+	switch v := lv.(type) {
+	case lua.LString:
+		return writer.WriteBulk([]byte(v))
+	case lua.LNumber:
+		return writer.WriteInteger(int64(v))
+	case lua.LBool:
+		if v {
+			return writer.WriteInteger(1)
+		}
+		return writer.WriteNull()
+	case *lua.LTable:
+		if ok, isStr := v.RawGetString("ok").(lua.LString); isStr {
+			return writer.WriteSimpleString(oneLine(string(ok)))
+		}
+		if msg, isStr := v.RawGetString("err").(lua.LString); isStr {
+			return writer.WriteError(oneLine(string(msg)))
+		}
+
+		n := 0
+		for v.RawGetInt(n+1) != lua.LNil {
+			n++
+		}
+		if err := writer.WriteArray(n); err != nil {
+			return err
+		}
+		for i := 1; i <= n; i++ {
+			if err := writeLua(writer, v.RawGetInt(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writer.WriteNull()
+}
+
+// luaHeight returns how deeply the tables under lv nest, as writeLua reads
+// them, or -1 when they nest past maxLuaDepth or refer back to themselves.
+// heights remembers the tables already measured, so shared tables are only
+// walked once.
+func luaHeight(lv lua.LValue, depth int, heights map[*lua.LTable]int) int {
+	tbl, ok := lv.(*lua.LTable)
+	if !ok {
+		return 0
+	}
+	if _, isStr := tbl.RawGetString("ok").(lua.LString); isStr {
+		return 0
+	}
+	if _, isStr := tbl.RawGetString("err").(lua.LString); isStr {
+		return 0
+	}
+	if h, seen := heights[tbl]; seen {
+		// -1 is also the mark of a table still being measured: a cycle.
+		if h < 0 || depth+h > maxLuaDepth {
+			return -1
+		}
+		return h
+	}
+	if depth >= maxLuaDepth {
+		return -1
+	}
+
+	heights[tbl] = -1
+	h := 1
+	for i := 1; tbl.RawGetInt(i) != lua.LNil; i++ {
+		sub := luaHeight(tbl.RawGetInt(i), depth+1, heights)
+		if sub < 0 {
+			return -1
+		}
+		h = max(h, sub+1)
+	}
+	heights[tbl] = h
+	return h
+}
+
+// scriptError returns the reply for a script that failed: the error of
+// redis.call as is, or the Lua error message.
+func scriptError(err error) string {
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return "ERR " + oneLine(err.Error())
+	}
+	if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+		if msg, ok := tbl.RawGetString("err").(lua.LString); ok {
+			return oneLine(string(msg))
+		}
+	}
+	return "ERR " + oneLine(apiErr.Object.String())
+}
+
+// oneLine joins the lines of msg, which must fit a status or error reply.
+func oneLine(msg string) string {
+	return strings.Join(strings.Fields(msg), " ")
+}
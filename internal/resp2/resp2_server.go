@@ -7,21 +7,29 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type RESPServer struct {
-	cfg     *internal.Config
-	handler *RESPHandler
-	wg      sync.WaitGroup
-	ctx     context.Context
+	cfg         *internal.Config
+	handler     *RESPHandler
+	replication *Replication
+	wg          sync.WaitGroup
+	ctx         context.Context
+	nextID      atomic.Int64
 }
 
 func NewRESP2Server(cfg *internal.Config, cacheSrv *internal.CacheService, ctx context.Context) *RESPServer {
+	replication := NewReplication(cfg, cacheSrv, ctx)
+	cacheSrv.AddPropagator(replication)
+
 	return &RESPServer{
-		cfg:     cfg,
-		handler: NewRESPHandler(cacheSrv),
-		ctx:     ctx,
+		cfg:         cfg,
+		handler:     NewRESPHandler(cfg, cacheSrv, replication),
+		replication: replication,
+		ctx:         ctx,
 	}
 }
 
@@ -34,7 +42,14 @@ func (s *RESPServer) Run() error {
 
 	defer listener.Close()
 
-	fmt.Printf("RESP2 server listening on %s\n", addr)
+	fmt.Printf("RESP server listening on %s\n", addr)
+
+	go s.replication.Run()
+	if s.cfg.ReplicaOf != "" {
+		if err := s.startReplicaOf(s.cfg.ReplicaOf); err != nil {
+			fmt.Printf("Invalid replicaof %q: %v\n", s.cfg.ReplicaOf, err)
+		}
+	}
 
 	go func() {
 		<-s.ctx.Done()
@@ -64,8 +79,10 @@ func (s *RESPServer) handleConnection(conn net.Conn) {
 
 	fmt.Printf("Client connected: %s\n", conn.RemoteAddr())
 
-	parser := NewRESPParser(conn)
-	writer := NewRESPWriter(conn)
+	client := NewClient(s.ctx, s.nextID.Add(1), conn)
+	defer client.close()
+	parser := client.parser
+	writer := client.writer
 
 	for {
 		select {
@@ -82,11 +99,32 @@ func (s *RESPServer) handleConnection(conn net.Conn) {
 			}
 
 			fmt.Printf("Parse error: %v\n", err)
+			client.writeMu.Lock()
 			writer.WriteError(fmt.Sprintf("ERR protocol error: %v", err))
+			writer.Flush()
+			client.writeMu.Unlock()
 			return
 		}
 
-		if err := s.handler.HandleCommand(cmd, writer); err != nil {
+		client.lockWrites()
+		if name, ok := commandName(cmd); ok && client.subscribed() && writer.Protocol() == 2 && !subscribeCommands[name] {
+			err = writer.WriteError(fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context", strings.ToLower(name)))
+		} else {
+			err = s.handler.HandleCommand(cmd, client)
+		}
+		// Replies are held while more pipelined commands are waiting, so
+		// the whole batch goes out in one write.
+		if err == nil && parser.Buffered() == 0 {
+			err = writer.Flush()
+		}
+		client.unlockWrites()
+
+		if err != nil {
+			// A replica took over the connection, or a blocked client
+			// went away.
+			if err == errReplicaDisconnected || err == context.Canceled {
+				return
+			}
 			fmt.Printf("Handler error: %v\n", err)
 			return
 
@@ -94,6 +132,22 @@ func (s *RESPServer) handleConnection(conn net.Conn) {
 	}
 }
 
+// startReplicaOf applies the "host port" replicaof setting.
+func (s *RESPServer) startReplicaOf(replicaOf string) error {
+	fields := strings.Fields(replicaOf)
+	if len(fields) != 2 {
+		return fmt.Errorf("expected \"host port\"")
+	}
+
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %q", fields[1])
+	}
+
+	s.replication.ReplicaOf(fields[0], port)
+	return nil
+}
+
 func (s *RESPServer) Shutdown() {
 	s.wg.Wait()
 	fmt.Println("RESP server shutdown complete")
@@ -1,49 +1,306 @@
 package resp2
 
 import (
-	"fmt"
 	"io"
+	"math"
+	"strconv"
 )
 
+// writeBufferSize is how much a buffered writer holds before it sends the
+// replies on, so a pipeline with large replies does not grow it unbounded.
+// Payloads of that size or more skip the buffer altogether.
+const writeBufferSize = 64 << 10
+
+// RESPWriter encodes replies in the protocol negotiated by the connection.
+// The RESP3 only types fall back to their RESP2 equivalents while the
+// protocol is 2, so handlers can use them unconditionally.
+//
+// Replies are encoded into a reused buffer with the strconv Append
+// functions, so writing them does not allocate. A writer made with
+// NewRESPWriter sends each of them on right away, one Write per call, while
+// one made with NewBufferedRESPWriter keeps them until Flush.
 type RESPWriter struct {
-	writer io.Writer
+	writer   io.Writer
+	proto    int
+	buf      []byte
+	buffered bool
 }
 
 func NewRESPWriter(w io.Writer) *RESPWriter {
-	return &RESPWriter{writer: w}
+	return &RESPWriter{writer: w, proto: 2}
 }
 
-func (w *RESPWriter) WriteSimpleString(val string) error {
-	_, err := fmt.Fprintf(w.writer, "+%s\r\n", val)
+// NewBufferedRESPWriter returns a writer that only writes to w on Flush, or
+// once writeBufferSize bytes are waiting. The connection loop flushes once
+// it has handled every command the client pipelined, so a batch of replies
+// goes out in a single write.
+func NewBufferedRESPWriter(w io.Writer) *RESPWriter {
+	return &RESPWriter{writer: w, proto: 2, buf: make([]byte, 0, 4096), buffered: true}
+}
+
+// SetProtocol switches the encoding to RESP2 or RESP3.
+func (w *RESPWriter) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+func (w *RESPWriter) Protocol() int {
+	return w.proto
+}
+
+// Flush writes the replies waiting in the buffer.
+func (w *RESPWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	_, err := w.writer.Write(w.buf)
+	if cap(w.buf) > writeBufferSize {
+		// Drop a buffer grown by a large reply rather than keep it for
+		// the life of the connection.
+		w.buf = make([]byte, 0, 4096)
+	} else {
+		w.buf = w.buf[:0]
+	}
 	return err
 }
 
+// done ends a write, sending the buffer on unless the writer is buffered
+// and there is room left in it.
+func (w *RESPWriter) done() error {
+	if w.buffered && len(w.buf) < writeBufferSize {
+		return nil
+	}
+	return w.Flush()
+}
+
+// writeLine writes typ followed by val and CRLF.
+func (w *RESPWriter) writeLine(typ byte, val string) error {
+	w.buf = append(w.buf, typ)
+	w.buf = append(w.buf, val...)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.done()
+}
+
+// writeHeader writes typ followed by the length or count n and CRLF.
+func (w *RESPWriter) writeHeader(typ byte, n int64) error {
+	w.buf = append(w.buf, typ)
+	w.buf = strconv.AppendInt(w.buf, n, 10)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.done()
+}
+
+func (w *RESPWriter) WriteSimpleString(val string) error {
+	return w.writeLine(SimpleString, val)
+}
+
 func (w *RESPWriter) WriteError(val string) error {
-	_, err := fmt.Fprintf(w.writer, "-%s\r\n", val)
-	return err
+	return w.writeLine(Error, val)
 }
 
 func (w *RESPWriter) WriteInteger(val int64) error {
-	_, err := fmt.Fprintf(w.writer, ":%d\r\n", val)
-	return err
+	return w.writeHeader(Integer, val)
 }
 
 func (w *RESPWriter) WriteBulkString(val string) error {
-	_, err := fmt.Fprintf(w.writer, "$%d\r\n%s\r\n", len(val), val)
+	w.buf = append(w.buf, BulkString)
+	w.buf = strconv.AppendInt(w.buf, int64(len(val)), 10)
+	w.buf = append(w.buf, '\r', '\n')
+	w.buf = append(w.buf, val...)
+	w.buf = append(w.buf, '\r', '\n')
+	return w.done()
+}
+
+// WriteBulk writes val as a bulk string without any formatting, so it may
+// contain arbitrary bytes including CR and LF.
+func (w *RESPWriter) WriteBulk(val []byte) error {
+	w.buf = append(w.buf, BulkString)
+	w.buf = strconv.AppendInt(w.buf, int64(len(val)), 10)
+	w.buf = append(w.buf, '\r', '\n')
+	if err := w.writePayload(val); err != nil {
+		return err
+	}
+	w.buf = append(w.buf, '\r', '\n')
+	return w.done()
+}
+
+// writePayload adds data to the buffer, or writes it straight to the
+// connection after what is buffered when it would not fit.
+func (w *RESPWriter) writePayload(data []byte) error {
+	if len(data) < writeBufferSize {
+		w.buf = append(w.buf, data...)
+		return nil
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(data)
 	return err
 }
 
 func (w *RESPWriter) WriteNull() error {
-	_, err := fmt.Fprintf(w.writer, "$-1\r\n")
-	return err
+	if w.proto == 3 {
+		return w.writeLine(Null, "")
+	}
+
+	return w.writeLine(BulkString, "-1")
 }
 
 func (w *RESPWriter) WriteArray(val int) error {
-	_, err := fmt.Fprintf(w.writer, "*%d\r\n", val)
-	return err
+	return w.writeHeader(Array, int64(val))
 }
 
 func (w *RESPWriter) WriteNullArray() error {
-	_, err := fmt.Fprintf(w.writer, "*-1\r\n")
-	return err
+	if w.proto == 3 {
+		return w.writeLine(Null, "")
+	}
+
+	return w.writeLine(Array, "-1")
+}
+
+// WriteMap starts a map of val key-value pairs, written as a flat array of
+// 2*val elements in RESP2.
+func (w *RESPWriter) WriteMap(val int) error {
+	if w.proto == 3 {
+		return w.writeHeader(Map, int64(val))
+	}
+
+	return w.WriteArray(val * 2)
+}
+
+func (w *RESPWriter) WriteSet(val int) error {
+	if w.proto == 3 {
+		return w.writeHeader(Set, int64(val))
+	}
+
+	return w.WriteArray(val)
+}
+
+// WritePush starts an out-of-band push frame such as a pubsub message.
+func (w *RESPWriter) WritePush(val int) error {
+	if w.proto == 3 {
+		return w.writeHeader(Push, int64(val))
+	}
+
+	return w.WriteArray(val)
+}
+
+// WriteDouble writes val as a double, or as a bulk string in RESP2.
+func (w *RESPWriter) WriteDouble(val float64) error {
+	if w.proto == 3 {
+		w.buf = append(w.buf, Double)
+		w.buf = appendDouble(w.buf, val)
+		w.buf = append(w.buf, '\r', '\n')
+		return w.done()
+	}
+
+	var num [32]byte
+	return w.WriteBulk(appendDouble(num[:0], val))
+}
+
+// WriteBoolean writes val as a boolean, or as 1 or 0 in RESP2.
+func (w *RESPWriter) WriteBoolean(val bool) error {
+	if w.proto == 3 {
+		if val {
+			return w.writeLine(Boolean, "t")
+		}
+		return w.writeLine(Boolean, "f")
+	}
+
+	if val {
+		return w.WriteInteger(1)
+	}
+	return w.WriteInteger(0)
+}
+
+// WriteBigNumber writes the decimal digits in val, as a bulk string in RESP2.
+func (w *RESPWriter) WriteBigNumber(val string) error {
+	if w.proto == 3 {
+		return w.writeLine(BigNumber, val)
+	}
+
+	return w.WriteBulkString(val)
+}
+
+// WriteVerbatim writes text tagged with a three letter format such as "txt"
+// or "mkd", as a plain bulk string in RESP2.
+func (w *RESPWriter) WriteVerbatim(format, val string) error {
+	if w.proto == 3 {
+		w.buf = append(w.buf, VerbatimString)
+		w.buf = strconv.AppendInt(w.buf, int64(len(val)+4), 10)
+		w.buf = append(w.buf, '\r', '\n')
+		w.buf = append(w.buf, format...)
+		w.buf = append(w.buf, ':')
+		w.buf = append(w.buf, val...)
+		w.buf = append(w.buf, '\r', '\n')
+		return w.done()
+	}
+
+	return w.WriteBulkString(val)
+}
+
+func formatDouble(val float64) string {
+	return string(appendDouble(nil, val))
+}
+
+func appendDouble(buf []byte, val float64) []byte {
+	switch {
+	case math.IsInf(val, 1):
+		return append(buf, "inf"...)
+	case math.IsInf(val, -1):
+		return append(buf, "-inf"...)
+	case math.IsNaN(val):
+		return append(buf, "nan"...)
+	}
+	return strconv.AppendFloat(buf, val, 'g', -1, 64)
+}
+
+// WriteRaw writes already encoded RESP data, such as a replication stream.
+func (w *RESPWriter) WriteRaw(data []byte) error {
+	if err := w.writePayload(data); err != nil {
+		return err
+	}
+	return w.done()
+}
+
+// WriteBulkStream writes the n bytes read from r as a bulk string, copying
+// them straight to the connection instead of through the buffer.
+func (w *RESPWriter) WriteBulkStream(r io.Reader, n int64) error {
+	w.buf = append(w.buf, BulkString)
+	w.buf = strconv.AppendInt(w.buf, n, 10)
+	w.buf = append(w.buf, '\r', '\n')
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w.writer, r, n); err != nil {
+		return err
+	}
+	w.buf = append(w.buf, '\r', '\n')
+	return w.done()
+}
+
+// WriteCommand encodes args as an array of bulk strings, the form clients
+// use to send commands.
+func (w *RESPWriter) WriteCommand(args ...[]byte) error {
+	w.buf = appendCommand(w.buf, args)
+	return w.done()
+}
+
+func encodeCommand(args [][]byte) []byte {
+	return appendCommand(nil, args)
+}
+
+func appendCommand(buf []byte, args [][]byte) []byte {
+	buf = append(buf, Array)
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, BulkString)
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
 }
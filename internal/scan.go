@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"container/heap"
+	"math/bits"
+	"slices"
+)
+
+// scanTableMin is the number of buckets a scanTable starts with.
+const scanTableMin = 16
+
+// scanPage returns up to count of names, in the order of their 64-bit FNV-1a
+// hash, starting at the first name hashing to cursor or above, along with
+// the cursor of the next page, 0 once every name was returned. Ordering by
+// hash rather than position gives SCAN-like guarantees across calls: a name
+// present for the whole iteration is returned, and returned at most once,
+// however the collection changes in between. Names sharing a hash always
+// end up in the same page, so a page may exceed count.
+func scanPage(names []string, cursor uint64, count int) ([]string, uint64) {
+	if count <= 0 {
+		count = 10
+	}
+
+	// Keep the count lowest hashes from cursor on, the highest on top.
+	lowest := make(hashHeap, 0, count)
+	for _, name := range names {
+		h := scanHash(name)
+		switch {
+		case h < cursor:
+		case len(lowest) < count:
+			heap.Push(&lowest, hashedName{name, h})
+		case h < lowest[0].hash:
+			lowest[0] = hashedName{name, h}
+			heap.Fix(&lowest, 0)
+		}
+	}
+	if len(lowest) == 0 {
+		return nil, 0
+	}
+
+	// The page ends with the names sharing the highest hash kept, and the
+	// next one starts at the lowest hash above it.
+	last := lowest[0].hash
+	page := make([]string, 0, len(lowest))
+	next := uint64(0)
+	for _, name := range names {
+		h := scanHash(name)
+		switch {
+		case h < cursor:
+		case h <= last:
+			page = append(page, name)
+		case next == 0 || h < next:
+			next = h
+		}
+	}
+	return page, next
+}
+
+type hashedName struct {
+	name string
+	hash uint64
+}
+
+// hashHeap is a max-heap of names by hash.
+type hashHeap []hashedName
+
+func (h hashHeap) Len() int           { return len(h) }
+func (h hashHeap) Less(i, j int) bool { return h[i].hash > h[j].hash }
+func (h hashHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x any)        { *h = append(*h, x.(hashedName)) }
+func (h *hashHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Scan returns a page of the keys of the whole keyspace like SCAN. The
+// shards are walked one after another, each locked only while its page is
+// taken, so the high bits of the cursor hold the shard and the low bits the
+// cursor within it, see scanTable. count bounds the keys looked at rather
+// than returned, as only keys matching pattern, and of type typ when it is
+// not empty, are kept.
+func (s *Storage) Scan(cursor uint64, pattern string, count int, typ string) ([]string, uint64) {
+	if count <= 0 {
+		count = 10
+	}
+
+	shardBits := uint(bits.Len32(s.mask))
+	posBits := 64 - shardBits
+	index := cursor >> posBits
+	pos := cursor & (1<<posBits - 1)
+
+	keys := make([]string, 0)
+	for index < uint64(len(s.shards)) {
+		var next uint64
+		keys, next, count = s.scanShard(s.shards[index], keys, pos, pattern, count, typ)
+		if next != 0 {
+			return keys, index<<posBits | next
+		}
+
+		index++
+		pos = 0
+		if count == 0 {
+			break
+		}
+	}
+
+	if index == uint64(len(s.shards)) {
+		return keys, 0
+	}
+	return keys, index << posBits
+}
+
+// scanShard appends to keys the matching keys of the buckets of sh from pos
+// on, until count keys were looked at. It returns the position of the
+// following buckets, 0 once sh is done, and what is left of count.
+func (s *Storage) scanShard(sh *storageShard, keys []string, pos uint64, pattern string, count int, typ string) ([]string, uint64, int) {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	now := utcNow()
+	next, seen := sh.keys.scan(pos, count, func(key string) {
+		item := sh.data[key]
+		if checkIfExpired(&item.ExpiresAt, now) {
+			return
+		}
+		if typ != "" && item.Type.String() != typ {
+			return
+		}
+		if pattern == "*" || matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	})
+	return keys, next, max(count-seen, 0)
+}
+
+// scanTable holds the keys of a shard spread over a power of two number of
+// buckets by hash, which SCAN walks with the reverse binary cursor of the
+// Redis dict. Buckets are visited in the order of their index with its bits
+// reversed, so the buckets already visited map onto buckets already visited
+// when the table doubles or halves in between. A key present for the whole
+// iteration is then always returned, though after the table shrinks it may
+// be returned twice.
+type scanTable struct {
+	buckets [][]string
+	count   int
+}
+
+// add inserts key, which must not be in t yet, doubling the buckets once
+// there are as many keys.
+func (t *scanTable) add(key string) {
+	if t.count >= len(t.buckets) {
+		t.resize(max(2*len(t.buckets), scanTableMin))
+	}
+
+	i := t.bucket(key)
+	t.buckets[i] = append(t.buckets[i], key)
+	t.count++
+}
+
+// remove deletes key, halving the buckets once they are mostly empty.
+func (t *scanTable) remove(key string) {
+	if t.count == 0 {
+		return
+	}
+
+	i := t.bucket(key)
+	bucket := t.buckets[i]
+	at := slices.Index(bucket, key)
+	if at < 0 {
+		return
+	}
+	last := len(bucket) - 1
+	bucket[at] = bucket[last]
+	bucket[last] = ""
+	t.buckets[i] = bucket[:last]
+	t.count--
+
+	if len(t.buckets) > scanTableMin && t.count < len(t.buckets)/8 {
+		t.resize(len(t.buckets) / 2)
+	}
+}
+
+func (t *scanTable) bucket(key string) uint64 {
+	return scanHash(key) & uint64(len(t.buckets)-1)
+}
+
+func (t *scanTable) resize(n int) {
+	old := t.buckets
+	t.buckets = make([][]string, n)
+	for _, bucket := range old {
+		for _, key := range bucket {
+			i := t.bucket(key)
+			t.buckets[i] = append(t.buckets[i], key)
+		}
+	}
+}
+
+// scan calls fn with the keys of the buckets from cursor on, a bucket at a
+// time, until count keys were seen. It returns the cursor of the next
+// bucket, 0 once every bucket was visited, and the number of keys seen.
+func (t *scanTable) scan(cursor uint64, count int, fn func(key string)) (uint64, int) {
+	if len(t.buckets) == 0 {
+		return 0, 0
+	}
+
+	mask := uint64(len(t.buckets) - 1)
+	seen := 0
+	for {
+		for _, key := range t.buckets[cursor&mask] {
+			fn(key)
+			seen++
+		}
+
+		// Increment the reversed bits of the index.
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || seen >= count {
+			return cursor, seen
+		}
+	}
+}
+
+func scanHash(name string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint64(name[i])
+		hash *= 1099511628211
+	}
+	return hash
+}
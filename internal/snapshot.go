@@ -0,0 +1,573 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"maps"
+	"math"
+	"time"
+)
+
+// Snapshot file layout:
+//
+//	"CAGOSNAP" | version (1 byte) | record... | opEOF | CRC-64 (8 bytes, LE)
+//
+// Every record starts with an opcode byte. Strings and byte slices are
+// written as a uvarint length followed by the raw bytes, and expirations as a
+// varint absolute Unix time in milliseconds, 0 meaning no expiry. The
+// checksum covers everything before it.
+//
+//	opString:    key | expiry | content type | value
+//	opHash:      key | expiry | uvarint field count | (field | value)...
+//	opList:      key | expiry | uvarint element count | element...
+//	opSet:       key | expiry | uvarint member count | member...
+//	opZSet:      key | expiry | uvarint member count | (member | score)...
+//	opFunctions: uvarint library count | (name | code)...
+//
+// Scores are stored as the 8 little-endian bytes of the float64. Since
+// version 2 the function libraries follow the keys in a single opFunctions
+// record, which version 1 snapshots lack.
+const (
+	snapshotMagic   = "CAGOSNAP"
+	snapshotVersion = 2
+
+	opString    byte = 0x01
+	opHash      byte = 0x02
+	opList      byte = 0x03
+	opSet       byte = 0x04
+	opZSet      byte = 0x05
+	opFunctions byte = 0x06
+	opEOF       byte = 0xFF
+
+	// maxSnapshotBulk bounds a single length prefix so a corrupt file
+	// cannot trigger a huge allocation.
+	maxSnapshotBulk = 512 << 20
+)
+
+var (
+	ErrSnapshotCorrupt  = errors.New("snapshot is corrupt")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// snapshotEntry is a detached copy of a key, safe to serialize while the
+// shards are unlocked. Aggregates are copied, as they are modified in place.
+type snapshotEntry struct {
+	key         string
+	typ         ValueType
+	value       []byte
+	contentType string
+	hash        map[string][]byte
+	list        [][]byte
+	set         map[string]struct{}
+	zset        []ZMember
+	expiresAt   time.Time
+}
+
+func newSnapshotEntry(key string, item *StorageItem) snapshotEntry {
+	e := snapshotEntry{
+		key:         key,
+		typ:         item.Type,
+		value:       item.Value,
+		contentType: item.ContentType,
+		hash:        maps.Clone(item.Hash),
+		set:         maps.Clone(item.Set),
+		expiresAt:   item.ExpiresAt,
+	}
+	if item.List != nil {
+		e.list = item.List.all()
+	}
+	if item.ZSet != nil {
+		e.zset = item.ZSet.all()
+	}
+	return e
+}
+
+// item rebuilds the stored item, including the accounted element size.
+func (e snapshotEntry) item() *StorageItem {
+	item := &StorageItem{
+		Type:        e.typ,
+		Value:       e.value,
+		ContentType: e.contentType,
+		Hash:        e.hash,
+		Set:         e.set,
+		ExpiresAt:   e.expiresAt,
+	}
+	for field, val := range e.hash {
+		item.size += hashFieldSize(field, val)
+	}
+	for m := range e.set {
+		item.size += setMemberSize(m)
+	}
+	if e.typ == TypeZSet {
+		item.ZSet = newSortedSet()
+		for _, m := range e.zset {
+			item.ZSet.set(m.Member, m.Score)
+			item.size += zsetMemberSize(m.Member)
+		}
+	}
+	if e.typ == TypeList {
+		item.List = newList(e.list)
+		for _, elem := range e.list {
+			item.size += listElemSize(elem)
+		}
+	}
+	return item
+}
+
+// WriteSnapshot serializes every live key and the function libraries to w,
+// as of the moment the dump begins. Shards are copied and written one at a time, see walkSnapshot, so
+// writers are only held off the shard being copied. If mark is not nil it
+// runs when the dump begins, which lines it up with a position in the
+// propagated command stream. It returns the write counter observed then.
+func (s *Storage) WriteSnapshot(w io.Writer, mark func()) (int64, error) {
+	buf := bufio.NewWriter(w)
+	hash := crc64.New(crcTable)
+	out := io.MultiWriter(buf, hash)
+
+	header := append([]byte(snapshotMagic), snapshotVersion)
+	if _, err := out.Write(header); err != nil {
+		return 0, err
+	}
+
+	var record []byte
+	dirty, libs, err := s.walkSnapshot(mark, func(e snapshotEntry) error {
+		record = encodeEntry(record[:0], e)
+		_, err := out.Write(record)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	record = appendLibraries(append(record[:0], opFunctions), libs)
+	record = append(record, opEOF)
+	if _, err := out.Write(record); err != nil {
+		return 0, err
+	}
+
+	sum := binary.LittleEndian.AppendUint64(nil, hash.Sum64())
+	if _, err := buf.Write(sum); err != nil {
+		return 0, err
+	}
+
+	return dirty, buf.Flush()
+}
+
+// LoadSnapshot stores the keys read from r on top of the current dataset,
+// skipping keys that expired while the snapshot was at rest. The whole
+// snapshot is validated before any key is stored, and loaded keys do not
+// count as unsaved writes. The function libraries of the snapshot are only
+// used when there was no library file to load, see FunctionStore.
+func (s *Storage) LoadSnapshot(r io.Reader) (int, error) {
+	entries, libs, err := decodeSnapshot(bufio.NewReader(r))
+	if err != nil {
+		return 0, err
+	}
+
+	if libs != nil && s.functions != nil {
+		if err := s.functions.fallback(libs); err != nil {
+			return 0, err
+		}
+	}
+	return s.restoreEntries(entries), nil
+}
+
+// ReplaceWithSnapshot is like LoadSnapshot, but drops the current dataset
+// once the snapshot has been validated. It returns the function libraries
+// of the snapshot, nil for a version 1 snapshot, for the caller to load.
+func (s *Storage) ReplaceWithSnapshot(r io.Reader) (int, []FunctionLibrary, error) {
+	entries, libs, err := decodeSnapshot(bufio.NewReader(r))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	s.Flush()
+	return s.restoreEntries(entries), libs, nil
+}
+
+func (s *Storage) restoreEntries(entries []snapshotEntry) int {
+	now := utcNow()
+	loaded := 0
+	for _, e := range entries {
+		if checkIfExpired(&e.expiresAt, now) {
+			continue
+		}
+
+		sh := s.shardFor(e.key)
+		sh.lock()
+		s.storeLocked(sh, e.key, e.item(), now)
+		sh.mu.Unlock()
+		loaded++
+	}
+
+	s.dirty.Add(-int64(loaded))
+	return loaded
+}
+
+// Dirty returns the number of writes since the last snapshot.
+func (s *Storage) Dirty() int64 {
+	return s.dirty.Load()
+}
+
+// snapshotSaved discounts the writes that made it into a snapshot taken when
+// the counter was at dirty.
+func (s *Storage) snapshotSaved(dirty int64) {
+	s.dirty.Add(-dirty)
+}
+
+// shardCopy is the part of a dump in progress covering one shard. The shard
+// is copied when the dump reaches it, or before that by the first writer to
+// lock it, so the dump holds the dataset as of the moment it began without
+// copying it all at once.
+type shardCopy struct {
+	now     *time.Time
+	entries []snapshotEntry
+}
+
+// lock locks sh for writing. When a dump in progress has not copied sh yet,
+// it is copied first, before anything in it changes.
+func (sh *storageShard) lock() {
+	sh.mu.Lock()
+	if c := sh.pending; c != nil {
+		sh.pending = nil
+		c.entries = sh.entries(c.now)
+	}
+}
+
+// entries copies the live keys of sh. The caller must hold sh.mu.
+func (sh *storageShard) entries(now *time.Time) []snapshotEntry {
+	entries := make([]snapshotEntry, 0, len(sh.data))
+	for key, item := range sh.data {
+		if checkIfExpired(&item.ExpiresAt, now) {
+			continue
+		}
+
+		entries = append(entries, newSnapshotEntry(key, item))
+	}
+	return entries
+}
+
+// walkSnapshot calls fn with every live key as of the moment mark runs,
+// which lets callers line the dump up with a position in the propagated
+// command stream. Shards are copied one at a time and fn runs with none of
+// them locked, so a shard is only held for its own copy. It returns the
+// write counter and the function libraries observed at mark.
+func (s *Storage) walkSnapshot(mark func(), fn func(snapshotEntry) error) (int64, []FunctionLibrary, error) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	// Nothing changes while every shard is read-locked, so this is the
+	// moment the dump holds. Writers can only read pending once they lock
+	// the shard, after it is set.
+	now := utcNow()
+	copies := make([]*shardCopy, len(s.shards))
+	for i, sh := range s.shards {
+		sh.mu.RLock()
+		copies[i] = &shardCopy{now: now}
+		sh.pending = copies[i]
+	}
+	var libs []FunctionLibrary
+	if fs := s.functions; fs != nil {
+		fs.mu.Lock()
+		libs = fs.libs
+	}
+	if mark != nil {
+		mark()
+	}
+	if fs := s.functions; fs != nil {
+		fs.mu.Unlock()
+	}
+	dirty := s.dirty.Load()
+	for _, sh := range s.shards {
+		sh.mu.RUnlock()
+	}
+
+	walked := 0
+	defer func() {
+		// After a failure, the shards left must not be copied anymore.
+		for _, sh := range s.shards[walked:] {
+			sh.mu.Lock()
+			sh.pending = nil
+			sh.mu.Unlock()
+		}
+	}()
+
+	for i, sh := range s.shards {
+		sh.lock()
+		entries := copies[i].entries
+		copies[i] = nil
+		sh.mu.Unlock()
+		walked++
+
+		for _, e := range entries {
+			if err := fn(e); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	return dirty, libs, nil
+}
+
+func encodeEntry(buf []byte, e snapshotEntry) []byte {
+	switch e.typ {
+	case TypeHash:
+		buf = append(buf, opHash)
+		buf = appendBytes(buf, []byte(e.key))
+		buf = appendExpiry(buf, e.expiresAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.hash)))
+		for field, val := range e.hash {
+			buf = appendBytes(buf, []byte(field))
+			buf = appendBytes(buf, val)
+		}
+	case TypeList:
+		buf = append(buf, opList)
+		buf = appendBytes(buf, []byte(e.key))
+		buf = appendExpiry(buf, e.expiresAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.list)))
+		for _, elem := range e.list {
+			buf = appendBytes(buf, elem)
+		}
+	case TypeSet:
+		buf = append(buf, opSet)
+		buf = appendBytes(buf, []byte(e.key))
+		buf = appendExpiry(buf, e.expiresAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.set)))
+		for m := range e.set {
+			buf = appendBytes(buf, []byte(m))
+		}
+	case TypeZSet:
+		buf = append(buf, opZSet)
+		buf = appendBytes(buf, []byte(e.key))
+		buf = appendExpiry(buf, e.expiresAt)
+		buf = binary.AppendUvarint(buf, uint64(len(e.zset)))
+		for _, m := range e.zset {
+			buf = appendBytes(buf, []byte(m.Member))
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(m.Score))
+		}
+	default:
+		buf = append(buf, opString)
+		buf = appendBytes(buf, []byte(e.key))
+		buf = appendExpiry(buf, e.expiresAt)
+		buf = appendBytes(buf, []byte(e.contentType))
+		buf = appendBytes(buf, e.value)
+	}
+	return buf
+}
+
+func appendBytes(buf, val []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(val)))
+	return append(buf, val...)
+}
+
+func appendExpiry(buf []byte, expiresAt time.Time) []byte {
+	if expiresAt.IsZero() {
+		return binary.AppendVarint(buf, 0)
+	}
+	return binary.AppendVarint(buf, expiresAt.UnixMilli())
+}
+
+func decodeSnapshot(r *bufio.Reader) ([]snapshotEntry, []FunctionLibrary, error) {
+	dec := &snapshotDecoder{r: r, hash: crc64.New(crcTable)}
+
+	header := dec.read(len(snapshotMagic) + 1)
+	if dec.err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, dec.err)
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], []byte(snapshotMagic)) {
+		return nil, nil, fmt.Errorf("%w: bad magic", ErrSnapshotCorrupt)
+	}
+	if version := header[len(snapshotMagic)]; version < 1 || version > snapshotVersion {
+		return nil, nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+
+	var entries []snapshotEntry
+	var libs []FunctionLibrary
+	for {
+		op, err := dec.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+		}
+
+		if op == opEOF {
+			break
+		}
+
+		switch op {
+		case opString:
+			entries = append(entries, snapshotEntry{
+				key:         string(dec.bytes()),
+				expiresAt:   dec.expiry(),
+				contentType: string(dec.bytes()),
+				value:       dec.bytes(),
+			})
+		case opHash:
+			e := snapshotEntry{
+				key:       string(dec.bytes()),
+				typ:       TypeHash,
+				expiresAt: dec.expiry(),
+			}
+			count := dec.count()
+			e.hash = make(map[string][]byte, min(count, 1024))
+			for range count {
+				field := string(dec.bytes())
+				e.hash[field] = dec.bytes()
+			}
+			entries = append(entries, e)
+		case opList:
+			e := snapshotEntry{
+				key:       string(dec.bytes()),
+				typ:       TypeList,
+				expiresAt: dec.expiry(),
+			}
+			count := dec.count()
+			e.list = make([][]byte, 0, min(count, 1024))
+			for range count {
+				e.list = append(e.list, dec.bytes())
+			}
+			entries = append(entries, e)
+		case opSet:
+			e := snapshotEntry{
+				key:       string(dec.bytes()),
+				typ:       TypeSet,
+				expiresAt: dec.expiry(),
+			}
+			count := dec.count()
+			e.set = make(map[string]struct{}, min(count, 1024))
+			for range count {
+				e.set[string(dec.bytes())] = struct{}{}
+			}
+			entries = append(entries, e)
+		case opZSet:
+			e := snapshotEntry{
+				key:       string(dec.bytes()),
+				typ:       TypeZSet,
+				expiresAt: dec.expiry(),
+			}
+			count := dec.count()
+			e.zset = make([]ZMember, 0, min(count, 1024))
+			for range count {
+				member := string(dec.bytes())
+				e.zset = append(e.zset, ZMember{Member: member, Score: dec.score()})
+			}
+			entries = append(entries, e)
+		case opFunctions:
+			libs = dec.libraries()
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown opcode 0x%02x", ErrSnapshotCorrupt, op)
+		}
+
+		if dec.err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, dec.err)
+		}
+	}
+
+	expected := dec.hash.Sum64()
+	sum := make([]byte, 8)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrSnapshotCorrupt, err)
+	}
+	if binary.LittleEndian.Uint64(sum) != expected {
+		return nil, nil, ErrSnapshotChecksum
+	}
+
+	return entries, libs, nil
+}
+
+// snapshotDecoder hashes every byte it consumes and remembers the first
+// error, so records can be decoded without checking each field.
+type snapshotDecoder struct {
+	r    *bufio.Reader
+	hash hash.Hash64
+	err  error
+}
+
+func (d *snapshotDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.hash.Write([]byte{b})
+	return b, nil
+}
+
+func (d *snapshotDecoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		d.err = err
+		return nil
+	}
+	d.hash.Write(buf)
+	return buf
+}
+
+func (d *snapshotDecoder) bytes() []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	n, err := binary.ReadUvarint(d)
+	if err != nil {
+		d.err = err
+		return nil
+	}
+	if n > maxSnapshotBulk {
+		d.err = fmt.Errorf("length %d out of range", n)
+		return nil
+	}
+	return d.read(int(n))
+}
+
+// count reads the number of elements of an aggregate.
+func (d *snapshotDecoder) count() int {
+	if d.err != nil {
+		return 0
+	}
+
+	n, err := binary.ReadUvarint(d)
+	if err != nil {
+		d.err = err
+		return 0
+	}
+	if n > maxSnapshotBulk {
+		d.err = fmt.Errorf("count %d out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *snapshotDecoder) score() float64 {
+	buf := d.read(8)
+	if buf == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf))
+}
+
+func (d *snapshotDecoder) expiry() time.Time {
+	if d.err != nil {
+		return time.Time{}
+	}
+
+	ms, err := binary.ReadVarint(d)
+	if err != nil {
+		d.err = err
+		return time.Time{}
+	}
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// snapshotOf writes a snapshot of a storage holding entries.
+func snapshotOf(t *testing.T, entries ...snapshotEntry) []byte {
+	t.Helper()
+
+	s := NewStorage(&Config{ShardCount: 4})
+	s.restoreEntries(entries)
+
+	var buf bytes.Buffer
+	if _, err := s.WriteSnapshot(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decode(data []byte) ([]snapshotEntry, error) {
+	entries, _, err := decodeSnapshot(bufio.NewReader(bytes.NewReader(data)))
+	return entries, err
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	expiresAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli()).UTC()
+
+	tests := []struct {
+		name  string
+		entry snapshotEntry
+	}{
+		{"string", snapshotEntry{key: "s", typ: TypeString, value: []byte("value"), contentType: "text/plain"}},
+		{"binary string", snapshotEntry{key: "b", typ: TypeString, value: []byte{0, '\r', '\n', 0xff}}},
+		{"expiring string", snapshotEntry{key: "e", typ: TypeString, value: []byte("v"), expiresAt: expiresAt}},
+		{"hash", snapshotEntry{key: "h", typ: TypeHash, hash: map[string][]byte{"f1": []byte("v1"), "f2": []byte("")}}},
+		{"list", snapshotEntry{key: "l", typ: TypeList, list: [][]byte{[]byte("a"), []byte("b"), []byte("a")}}},
+		{"set", snapshotEntry{key: "st", typ: TypeSet, set: map[string]struct{}{"a": {}, "b": {}}}},
+		{"zset", snapshotEntry{key: "z", typ: TypeZSet, zset: []ZMember{{"a", -1.5}, {"b", 0}, {"c", 2}}, expiresAt: expiresAt}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := decode(snapshotOf(t, tt.entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			if !reflect.DeepEqual(entries[0], tt.entry) {
+				t.Errorf("got %+v, want %+v", entries[0], tt.entry)
+			}
+		})
+	}
+}
+
+func TestSnapshotFunctions(t *testing.T) {
+	tests := []struct {
+		name string
+		libs []FunctionLibrary
+	}{
+		{"none", []FunctionLibrary{}},
+		{"some", []FunctionLibrary{
+			{Name: "a", Code: "#!lua name=a\nredis.register_function('f', function() return 1 end)"},
+			{Name: "b", Code: "#!lua name=b\n"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStorage(&Config{ShardCount: 4})
+			s.functions = &FunctionStore{libs: tt.libs}
+			s.restoreEntries([]snapshotEntry{{key: "s", value: []byte("v")}})
+
+			var buf bytes.Buffer
+			if _, err := s.WriteSnapshot(&buf, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, libs, err := decodeSnapshot(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("got %d entries, want 1", len(entries))
+			}
+			if !reflect.DeepEqual(libs, tt.libs) {
+				t.Errorf("got libraries %+v, want %+v", libs, tt.libs)
+			}
+		})
+	}
+}
+
+// TestSnapshotVersion1 checks that snapshots written before the function
+// libraries were added still load, without libraries.
+func TestSnapshotVersion1(t *testing.T) {
+	body := append([]byte(snapshotMagic), 1)
+	body = append(body, encodeEntry(nil, snapshotEntry{key: "s", value: []byte("v")})...)
+	body = append(body, opEOF)
+	data := binary.LittleEndian.AppendUint64(body, crc64.Checksum(body, crcTable))
+
+	entries, libs, err := decodeSnapshot(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].key != "s" {
+		t.Errorf("got %+v, want the key s", entries)
+	}
+	if libs != nil {
+		t.Errorf("got libraries %+v, want none", libs)
+	}
+}
+
+func TestSnapshotRejectsTruncated(t *testing.T) {
+	data := snapshotOf(t,
+		snapshotEntry{key: "s", value: []byte("value")},
+		snapshotEntry{key: "l", typ: TypeList, list: [][]byte{[]byte("a"), []byte("b")}},
+	)
+
+	for n := range len(data) {
+		_, err := decode(data[:n])
+		if !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Errorf("truncated to %d bytes: got %v, want %v", n, err, ErrSnapshotCorrupt)
+		}
+	}
+}
+
+func TestSnapshotRejectsBitFlip(t *testing.T) {
+	data := snapshotOf(t,
+		snapshotEntry{key: "s", value: []byte("value")},
+		snapshotEntry{key: "z", typ: TypeZSet, zset: []ZMember{{"a", 1}}},
+	)
+
+	for i := range len(data) {
+		for bit := range 8 {
+			flipped := bytes.Clone(data)
+			flipped[i] ^= 1 << bit
+
+			_, err := decode(flipped)
+			if !errors.Is(err, ErrSnapshotCorrupt) && !errors.Is(err, ErrSnapshotChecksum) && !errors.Is(err, ErrSnapshotVersion) {
+				t.Errorf("bit %d of byte %d flipped: got %v, want an error", bit, i, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var ErrSaveInProgress = errors.New("background save already in progress")
+
+// SaveRule triggers a background save once at least Changes writes happened
+// and Interval passed since the last successful save.
+type SaveRule struct {
+	Interval time.Duration
+	Changes  int64
+}
+
+type Snapshotter struct {
+	cfg     *Config
+	storage *Storage
+
+	saving   atomic.Bool
+	lastSave atomic.Int64
+	lastErr  atomic.Pointer[error]
+}
+
+func NewSnapshotter(cfg *Config, storage *Storage) *Snapshotter {
+	sn := &Snapshotter{
+		cfg:     cfg,
+		storage: storage,
+	}
+	sn.lastSave.Store(time.Now().Unix())
+	return sn
+}
+
+func (sn *Snapshotter) Path() string {
+	return filepath.Join(sn.cfg.Dir, sn.cfg.DbFilename)
+}
+
+// Load reads the snapshot file into storage. A missing file is not an error.
+func (sn *Snapshotter) Load() (int, error) {
+	f, err := os.Open(sn.Path())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	return sn.storage.LoadSnapshot(f)
+}
+
+// Save writes a snapshot in the calling goroutine.
+func (sn *Snapshotter) Save() error {
+	if !sn.saving.CompareAndSwap(false, true) {
+		return ErrSaveInProgress
+	}
+	defer sn.saving.Store(false)
+
+	return sn.save()
+}
+
+// BackgroundSave starts a snapshot in its own goroutine and returns
+// immediately.
+func (sn *Snapshotter) BackgroundSave() error {
+	if !sn.saving.CompareAndSwap(false, true) {
+		return ErrSaveInProgress
+	}
+
+	go func() {
+		defer sn.saving.Store(false)
+
+		fmt.Println("Background saving started")
+		if err := sn.save(); err != nil {
+			fmt.Printf("Background saving error: %v\n", err)
+			return
+		}
+		fmt.Println("Background saving terminated with success")
+	}()
+
+	return nil
+}
+
+func (sn *Snapshotter) InProgress() bool {
+	return sn.saving.Load()
+}
+
+// LastSave returns the time of the last successful save, or of startup when
+// nothing was saved yet.
+func (sn *Snapshotter) LastSave() time.Time {
+	return time.Unix(sn.lastSave.Load(), 0)
+}
+
+// LastError returns the error of the last save attempt, nil when it
+// succeeded.
+func (sn *Snapshotter) LastError() error {
+	if err := sn.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Run checks the save rules every second and starts a background save when
+// one of them is satisfied.
+func (sn *Snapshotter) Run(ctx context.Context) {
+	if len(sn.cfg.SaveRules) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if sn.InProgress() {
+				continue
+			}
+
+			dirty := sn.storage.Dirty()
+			elapsed := time.Since(sn.LastSave())
+			for _, rule := range sn.cfg.SaveRules {
+				if dirty >= rule.Changes && elapsed >= rule.Interval {
+					fmt.Printf("%d changes in %v. Saving...\n", dirty, rule.Interval)
+					sn.BackgroundSave()
+					break
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (sn *Snapshotter) save() error {
+	err := sn.writeFile()
+	sn.lastErr.Store(&err)
+	return err
+}
+
+// writeFile writes to a temporary file in the target directory and renames
+// it over the previous snapshot, so a crash never leaves a truncated file.
+func (sn *Snapshotter) writeFile() error {
+	tmp, err := os.CreateTemp(sn.cfg.Dir, "temp-*.snap")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	dirty, err := sn.storage.WriteSnapshot(tmp, nil)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), sn.Path()); err != nil {
+		return err
+	}
+
+	sn.storage.snapshotSaved(dirty)
+	sn.lastSave.Store(time.Now().Unix())
+	return nil
+}
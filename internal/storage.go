@@ -1,176 +1,712 @@
 package internal
 
 import (
+	"errors"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrOutOfMemory = errors.New("command not allowed when used memory > 'maxmemory'")
+	ErrWrongType   = errors.New("Operation against a key holding the wrong kind of value")
+)
+
+// itemOverhead approximates the bytes taken by a map entry and its
+// StorageItem on top of the raw key and value.
+const itemOverhead = 64
+
 type Storage struct {
+	shards []*storageShard
+	mask   uint32
+
+	maxMemory   int64
+	policy      EvictionPolicy
+	usedMemory  atomic.Int64
+	peakMemory  atomic.Int64
+	evictedKeys atomic.Int64
+
+	expireCursor int
+
+	// dirty counts writes since the last successful snapshot.
+	dirty atomic.Int64
+
+	propagators []Propagator
+
+	// pubsub receives the keyspace notifications enabled in notifyFlags.
+	pubsub      *PubSub
+	notifyFlags atomic.Uint32
+
+	// functions keeps the function libraries, which dumps carry and whose
+	// changes are propagated, see UseFunctionStore.
+	functions *FunctionStore
+
+	// snapshotMu lets one dump run at a time, see walkSnapshot.
+	snapshotMu sync.Mutex
+}
+
+type storageShard struct {
 	mu   sync.RWMutex
-	data map[string]StorageItem
+	data map[string]*StorageItem
+	// expires indexes the keys of data that carry a TTL, so the active
+	// expiration cycle samples only volatile keys.
+	expires map[string]struct{}
+	// keys holds the keys of data in buckets SCAN can walk, see scanTable.
+	keys scanTable
+	// waiters queues the clients blocked on a key in arrival order.
+	waiters map[string][]*waiter
+	// gate lets a transaction on the shard run without other clients'
+	// operations interleaving, see Shared and Exclusive.
+	gate sync.RWMutex
+	// pending is the copy of the shard a dump in progress still has to
+	// take. Every write locks the shard with lock, which takes it first.
+	pending *shardCopy
 }
 
+// ValueType is the kind of value held by a key.
+type ValueType byte
+
+const (
+	TypeString ValueType = iota
+	TypeHash
+	TypeList
+	TypeSet
+	TypeZSet
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeHash:
+		return "hash"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	}
+	return "none"
+}
+
+// StorageItem holds a value of one of the ValueTypes. Strings are kept as
+// raw bytes in Value, hashes in Hash, lists in List, sets in Set and sorted
+// sets in ZSet. Byte slices handed out by Storage must be treated as
+// read-only, since they are shared with the map entry.
 type StorageItem struct {
-	Value     string
-	ExpiresAt time.Time
+	Type        ValueType
+	Value       []byte
+	ContentType string
+	Hash        map[string][]byte
+	List        *List
+	Set         map[string]struct{}
+	ZSet        *SortedSet
+	ExpiresAt   time.Time
+
+	// size is the memory accounted to the elements of an aggregate value.
+	size int64
+
+	// version counts the changes made to the item in place, so WATCH can
+	// tell it was modified.
+	version uint64
+
+	lastAccess atomic.Int64
+	lfuCounter atomic.Uint32
 }
 
-func NewStorage() *Storage {
-	return &Storage{
-		data: make(map[string]StorageItem),
+// NewStorage creates a storage split into cfg.ShardCount independently locked
+// partitions. The count is rounded up to the next power of two.
+func NewStorage(cfg *Config) *Storage {
+	n := 1
+	for n < cfg.ShardCount {
+		n <<= 1
+	}
+
+	shards := make([]*storageShard, n)
+	for i := range shards {
+		shards[i] = &storageShard{
+			data:    make(map[string]*StorageItem),
+			expires: make(map[string]struct{}),
+			waiters: make(map[string][]*waiter),
+		}
 	}
+
+	s := &Storage{
+		shards:    shards,
+		mask:      uint32(n - 1),
+		maxMemory: cfg.MaxMemory,
+		policy:    cfg.EvictionPolicy,
+	}
+	s.SetNotifyFlags(cfg.NotifyKeyspaceEvents)
+	return s
 }
 
-func (s *Storage) Get(key string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// shardFor picks the shard owning key using 32-bit FNV-1a.
+func (s *Storage) shardFor(key string) *storageShard {
+	return s.shards[s.shardIndex(key)]
+}
 
-	item, exists := s.data[key]
-	if !exists {
-		return "", false
+func (s *Storage) shardIndex(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
 	}
+	return hash & s.mask
+}
 
-	if checkIfExpired(&item.ExpiresAt, utcNow()) {
-		return "", false
+// lockPair write-locks the shards of two keys in index order, so commands
+// touching two keys cannot deadlock each other. It returns both shards and
+// the function releasing them.
+func (s *Storage) lockPair(a, b string) (*storageShard, *storageShard, func()) {
+	ia, ib := s.shardIndex(a), s.shardIndex(b)
+	sa, sb := s.shards[ia], s.shards[ib]
+
+	switch {
+	case ia == ib:
+		sa.lock()
+		return sa, sb, sa.mu.Unlock
+	case ia < ib:
+		sa.lock()
+		sb.lock()
+	default:
+		sb.lock()
+		sa.lock()
+	}
+
+	return sa, sb, func() {
+		sa.mu.Unlock()
+		sb.mu.Unlock()
 	}
-	return item.Value, exists
 }
 
-func (s *Storage) Set(key, val string, ttl time.Duration) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// shardIndexes returns the indexes of the shards of keys, sorted and each
+// once.
+func (s *Storage) shardIndexes(keys []string) []uint32 {
+	indexes := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, s.shardIndex(key))
+	}
+	slices.Sort(indexes)
+	return slices.Compact(indexes)
+}
 
-	var expiresAt time.Time
-	if ttl > 0 {
-		expiresAt = utcNow().Add(ttl)
+// lockKeys locks the shards of keys in index order, each once, for writing
+// or only for reading. It returns the function releasing them.
+func (s *Storage) lockKeys(keys []string, write bool) func() {
+	indexes := s.shardIndexes(keys)
+
+	for _, i := range indexes {
+		if write {
+			s.shards[i].lock()
+		} else {
+			s.shards[i].mu.RLock()
+		}
 	}
 
-	s.data[key] = StorageItem{
-		Value:     val,
-		ExpiresAt: expiresAt,
+	return func() {
+		for _, i := range indexes {
+			if write {
+				s.shards[i].mu.Unlock()
+			} else {
+				s.shards[i].mu.RUnlock()
+			}
+		}
 	}
 }
 
-func (s *Storage) Delete(key string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	_, exists := s.data[key]
-	delete(s.data, key)
-	return exists
+func (s *Storage) Get(key string) ([]byte, bool, error) {
+	val, _, exists, err := s.GetWithContentType(key)
+	return val, exists, err
 }
 
-func (s *Storage) Exists(key string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// GetWithContentType returns the string under key with its content type.
+// An expired key it comes across is removed on the way out.
+func (s *Storage) GetWithContentType(key string) ([]byte, string, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
 
-	item, exists := s.data[key]
-	if !exists {
-		return false
+	item, err := s.readableLocked(sh, key, TypeString)
+	if item == nil {
+		_, expired := sh.data[key]
+		sh.mu.RUnlock()
+
+		if expired {
+			s.removeExpired(sh, key)
+		}
+		return nil, "", false, err
 	}
 
-	return !checkIfExpired(&item.ExpiresAt, utcNow())
+	val, contentType := item.Value, item.ContentType
+	sh.mu.RUnlock()
+	return val, contentType, true, nil
 }
 
-func (s *Storage) GetTTL(key string) (time.Duration, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// SetCondition makes a Set depend on whether the key already exists.
+type SetCondition byte
 
-	now := utcNow()
+const (
+	SetAlways SetCondition = iota
+	// SetIfAbsent only writes missing keys, like NX.
+	SetIfAbsent
+	// SetIfPresent only overwrites existing keys, like XX.
+	SetIfPresent
+)
 
-	item, exists := s.data[key]
-	if !exists {
-		return 0, false
+// SetOptions are the modifiers of SET. A zero ExpiresAt means the key never
+// expires. KeepTTL retains the expiry of the value being replaced, falling
+// back to ExpiresAt when there is none. Get asks for the replaced value,
+// which must then be a string.
+type SetOptions struct {
+	Condition SetCondition
+	ExpiresAt time.Time
+	KeepTTL   bool
+	Get       bool
+}
+
+// Set stores val under key. A zero expiresAt means the key never expires.
+func (s *Storage) Set(key string, val []byte, contentType string, expiresAt time.Time) error {
+	_, _, _, err := s.SetWithOptions(key, val, contentType, SetOptions{ExpiresAt: expiresAt})
+	return err
+}
+
+// SetWithOptions stores val under key unless opts.Condition prevents it,
+// checking and writing under a single lock. It returns the previous value
+// when it was a string, whether there was one, and whether val was
+// written. With opts.Get a previous value of another type fails the call
+// with ErrWrongType and nothing is written.
+func (s *Storage) SetWithOptions(key string, val []byte, contentType string, opts SetOptions) ([]byte, bool, bool, error) {
+	item := &StorageItem{
+		Value:       val,
+		ContentType: contentType,
+		ExpiresAt:   opts.ExpiresAt,
 	}
-	if item.ExpiresAt.IsZero() {
-		return -1, false
+
+	sh := s.shardFor(key)
+	if s.maxMemory > 0 && (opts.Condition != SetAlways || opts.Get) {
+		// Making room evicts other keys, which is only worth it when the
+		// write goes ahead. The condition is checked again below, as it
+		// may change once the lock is released.
+		sh.mu.RLock()
+		prev, old, ok, err := setConditionLocked(sh, key, opts, utcNow())
+		sh.mu.RUnlock()
+		if !ok || err != nil {
+			return old, prev != nil, false, err
+		}
 	}
-	if checkIfExpired(&item.ExpiresAt, now) {
-		return 0, false
+
+	if err := s.reserve(key, itemSize(key, item)); err != nil {
+		return nil, false, false, err
+	}
+
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	prev, old, ok, err := setConditionLocked(sh, key, opts, now)
+	if !ok || err != nil {
+		return old, prev != nil, false, err
 	}
-	ttl := item.ExpiresAt.Sub(*now)
-	return ttl, true
+
+	if opts.KeepTTL && prev != nil {
+		item.ExpiresAt = prev.ExpiresAt
+	}
+
+	s.storeLocked(sh, key, item, now)
+	s.propagate(setCommand(key, item))
+	s.notify(NotifyString, "set", key)
+	return old, prev != nil, true, nil
 }
 
-func (s *Storage) SetTTL(key string, ttl time.Duration) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// setConditionLocked returns the live item under key, its value when it is
+// a string, and whether opts let SET overwrite it. The caller must hold
+// sh.mu.
+func setConditionLocked(sh *storageShard, key string, opts SetOptions, now *time.Time) (*StorageItem, []byte, bool, error) {
+	var old []byte
+	prev := sh.lookupLocked(key, now)
+	if prev != nil {
+		if prev.Type == TypeString {
+			old = prev.Value
+		} else if opts.Get {
+			return nil, nil, false, ErrWrongType
+		}
+	}
 
-	item, exists := s.data[key]
-	if !exists {
-		return false
+	if (opts.Condition == SetIfAbsent && prev != nil) || (opts.Condition == SetIfPresent && prev == nil) {
+		return prev, old, false, nil
+	}
+	return prev, old, true, nil
+}
+
+// lookupLocked returns the item stored under key, or nil when it is missing
+// or expired. The caller must hold sh.mu.
+func (sh *storageShard) lookupLocked(key string, now *time.Time) *StorageItem {
+	item, exists := sh.data[key]
+	if !exists || checkIfExpired(&item.ExpiresAt, now) {
+		return nil
 	}
+	return item
+}
 
+// readableLocked returns the live item under key, nil when it does not exist,
+// and fails with ErrWrongType when it holds another type. The caller must
+// hold sh.mu.
+func (s *Storage) readableLocked(sh *storageShard, key string, typ ValueType) (*StorageItem, error) {
 	now := utcNow()
+	item := sh.lookupLocked(key, now)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != typ {
+		return nil, ErrWrongType
+	}
+
+	item.touch(now)
+	return item, nil
+}
+
+// writableLocked returns the item under key for modification in place, or
+// nil when the key does not exist. An expired item is removed first, so the
+// caller can create a fresh one. The caller must hold sh.mu for writing.
+func (s *Storage) writableLocked(sh *storageShard, key string, typ ValueType, now *time.Time) (*StorageItem, error) {
+	item, exists := sh.data[key]
+	if !exists {
+		return nil, nil
+	}
 	if checkIfExpired(&item.ExpiresAt, now) {
-		return false
+		s.removeExpiredLocked(sh, key)
+		return nil, nil
 	}
+	if item.Type != typ {
+		return nil, ErrWrongType
+	}
+
+	item.touch(now)
+	return item, nil
+}
+
+// resizeLocked accounts delta bytes of elements added to or removed from an
+// aggregate item. The caller must hold the item's shard lock for writing.
+func (s *Storage) resizeLocked(item *StorageItem, delta int64) {
+	item.size += delta
+	item.version++
+	s.trackMemory(delta)
+	s.dirty.Add(1)
+}
 
-	if ttl > 0 {
-		item.ExpiresAt = now.Add(ttl)
+// storeLocked inserts item under key, replacing any previous value, and
+// updates the expiry index and memory accounting. The caller must hold
+// sh.mu for writing.
+func (s *Storage) storeLocked(sh *storageShard, key string, item *StorageItem, now *time.Time) {
+	item.lastAccess.Store(now.UnixNano())
+	item.lfuCounter.Store(lfuInitValue)
+
+	if old, exists := sh.data[key]; exists {
+		s.trackMemory(-itemSize(key, old))
 	} else {
-		item.ExpiresAt = time.Time{}
+		sh.keys.add(key)
 	}
+	sh.data[key] = item
+	sh.indexExpiry(key, item)
+	s.trackMemory(itemSize(key, item))
+	s.dirty.Add(1)
+}
 
-	s.data[key] = item
+func (s *Storage) Delete(key string) bool {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	if !s.removeLocked(sh, key) {
+		return false
+	}
+
+	s.propagate(command("DEL", key))
+	s.notify(NotifyGeneric, "del", key)
 	return true
 }
 
-func (s *Storage) Keys(pattern string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// Flush removes every key. Nothing is propagated, as it is only used to
+// drop a dataset that is about to be replaced.
+func (s *Storage) Flush() {
+	for _, sh := range s.shards {
+		sh.lock()
+		for key := range sh.data {
+			s.removeLocked(sh, key)
+		}
+		sh.mu.Unlock()
+	}
+}
 
-	keys := make([]string, 0)
-	now := utcNow()
+// UsedMemory returns the approximate number of bytes held by all keys.
+func (s *Storage) UsedMemory() int64 {
+	return s.usedMemory.Load()
+}
 
-	for key, item := range s.data {
-		if checkIfExpired(&item.ExpiresAt, now){
-			continue
-		}
+// MemoryUsage returns the approximate number of bytes accounted to key.
+func (s *Storage) MemoryUsage(key string) (int64, bool) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, exists := sh.data[key]
+	if !exists || checkIfExpired(&item.ExpiresAt, utcNow()) {
+		return 0, false
+	}
+	return itemSize(key, item), true
+}
+
+type MemoryStats struct {
+	UsedMemory     int64
+	PeakMemory     int64
+	MaxMemory      int64
+	DatasetBytes   int64
+	OverheadBytes  int64
+	KeyCount       int
+	EvictedKeys    int64
+	EvictionPolicy EvictionPolicy
+}
+
+// MemoryStats reports global memory accounting. Keys that expired but were
+// not yet removed are still counted, as they still occupy memory.
+func (s *Storage) MemoryStats() MemoryStats {
+	count := 0
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		count += len(sh.data)
+		sh.mu.RUnlock()
+	}
 
-		if pattern == "*" || matchPattern(pattern, key) {
-			keys = append(keys, key)
+	used := s.usedMemory.Load()
+	overhead := int64(count) * itemOverhead
+
+	return MemoryStats{
+		UsedMemory:     used,
+		PeakMemory:     s.peakMemory.Load(),
+		MaxMemory:      s.maxMemory,
+		DatasetBytes:   used - overhead,
+		OverheadBytes:  overhead,
+		KeyCount:       count,
+		EvictedKeys:    s.evictedKeys.Load(),
+		EvictionPolicy: s.policy,
+	}
+}
+
+// removeLocked deletes key from sh and releases its accounted memory.
+// The caller must hold sh.mu for writing.
+func (s *Storage) removeLocked(sh *storageShard, key string) bool {
+	item, exists := sh.data[key]
+	if !exists {
+		return false
+	}
+
+	delete(sh.data, key)
+	delete(sh.expires, key)
+	sh.keys.remove(key)
+	s.trackMemory(-itemSize(key, item))
+	s.dirty.Add(1)
+	return true
+}
+
+// length returns the number of elements of an aggregate, 1 for a string.
+func (item *StorageItem) length() int {
+	switch item.Type {
+	case TypeHash:
+		return len(item.Hash)
+	case TypeList:
+		return item.List.Len()
+	case TypeSet:
+		return len(item.Set)
+	case TypeZSet:
+		return item.ZSet.Len()
+	}
+	return 1
+}
+
+// dropIfEmptyLocked removes the aggregate under key once its last element
+// is gone, as Redis never keeps empty aggregates, and announces it once even
+// when called again for the same key. The caller must hold sh.mu for
+// writing.
+func (s *Storage) dropIfEmptyLocked(sh *storageShard, key string, item *StorageItem) {
+	if item.length() == 0 && s.removeLocked(sh, key) {
+		s.notify(NotifyGeneric, "del", key)
+	}
+}
+
+// trackMemory adjusts the used memory counter by delta and keeps the peak
+// up to date.
+func (s *Storage) trackMemory(delta int64) {
+	used := s.usedMemory.Add(delta)
+	for {
+		peak := s.peakMemory.Load()
+		if used <= peak || s.peakMemory.CompareAndSwap(peak, used) {
+			return
 		}
 	}
+}
 
-	return keys
+func (s *Storage) Exists(key string) bool {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, exists := sh.data[key]
+	if !exists {
+		return false
+	}
+
+	return !checkIfExpired(&item.ExpiresAt, utcNow())
 }
 
-func (s *Storage) CleanupExired() int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Type returns the type of the value stored under key.
+func (s *Storage) Type(key string) (ValueType, bool) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item := sh.lookupLocked(key, utcNow())
+	if item == nil {
+		return 0, false
+	}
+	return item.Type, true
+}
+
+// ExpireCondition restricts when Expire may change the expiry of a key,
+// like the NX, XX, GT and LT flags of EXPIRE, which can be combined. A key
+// without an expiry counts as expiring never.
+type ExpireCondition byte
+
+// ExpireAlways places no condition on Expire.
+const ExpireAlways ExpireCondition = 0
+
+const (
+	// ExpireIfNone only sets an expiry on keys without one.
+	ExpireIfNone ExpireCondition = 1 << iota
+	// ExpireIfSet only changes an existing expiry.
+	ExpireIfSet
+	// ExpireIfLater only moves the expiry later.
+	ExpireIfLater
+	// ExpireIfSooner only moves the expiry sooner.
+	ExpireIfSooner
+)
+
+// GetExpiresAt returns the absolute expiry of key, zero when it never
+// expires, and whether the key exists.
+func (s *Storage) GetExpiresAt(key string) (time.Time, bool) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item := sh.lookupLocked(key, utcNow())
+	if item == nil {
+		return time.Time{}, false
+	}
+	return item.ExpiresAt, true
+}
+
+// Expire sets the expiry of an existing key to expiresAt when cond allows
+// it. An expiresAt that is not in the future deletes the key right away, as
+// Redis does. It reports whether the key was changed.
+func (s *Storage) Expire(key string, expiresAt time.Time, cond ExpireCondition) bool {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
 
-	count := 0
 	now := utcNow()
-	for key, item := range s.data {
-		if checkIfExpired(&item.ExpiresAt, now) {
-			delete(s.data, key)
-			count++
-		}
+	item := sh.lookupLocked(key, now)
+	if item == nil {
+		return false
+	}
+
+	persistent := item.ExpiresAt.IsZero()
+	if (cond&ExpireIfNone != 0 && !persistent) ||
+		(cond&ExpireIfSet != 0 && persistent) ||
+		(cond&ExpireIfLater != 0 && (persistent || !expiresAt.After(item.ExpiresAt))) ||
+		(cond&ExpireIfSooner != 0 && !persistent && !expiresAt.Before(item.ExpiresAt)) {
+		return false
 	}
 
-	return count
+	s.expireLocked(sh, key, item, expiresAt, now)
+	return true
 }
 
-func matchPattern(pattern, key string) bool {
-	if pattern == "*" {
-		return true
+// expireLocked sets the expiry of item, deleting it when expiresAt is not
+// after now, and a zero expiresAt removes the expiry. The caller must hold
+// sh.mu for writing.
+func (s *Storage) expireLocked(sh *storageShard, key string, item *StorageItem, expiresAt time.Time, now *time.Time) {
+	if !expiresAt.IsZero() && !expiresAt.After(*now) {
+		s.removeLocked(sh, key)
+		s.propagate(command("DEL", key))
+		s.notify(NotifyGeneric, "del", key)
+		return
 	}
 
-	if len(pattern) == 0 {
-		return key == ""
+	item.ExpiresAt = expiresAt
+	item.version++
+	sh.indexExpiry(key, item)
+	s.dirty.Add(1)
+	if expiresAt.IsZero() {
+		s.propagate(command("PERSIST", key))
+		s.notify(NotifyGeneric, "persist", key)
+	} else {
+		s.propagate(command("PEXPIREAT", key, formatUnixMilli(expiresAt)))
+		s.notify(NotifyGeneric, "expire", key)
 	}
+}
 
-	if pattern[len(pattern)-1] == '*' && len(pattern) > 1 {
-		prefix := pattern[:len(pattern)-1]
-		return len(key) >= len(prefix) && key[:len(prefix)] == prefix
+// Persist removes the expiry of key. It reports false when the key does not
+// exist or has no expiry.
+func (s *Storage) Persist(key string) bool {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item := sh.lookupLocked(key, utcNow())
+	if item == nil || item.ExpiresAt.IsZero() {
+		return false
 	}
 
-	if pattern[0] == '*' && len(pattern) > 1 {
-		suffix := pattern[1:]
-		return len(key) >= len(suffix) && key[len(key) - len(suffix):] == suffix
+	s.expireLocked(sh, key, item, time.Time{}, nil)
+	return true
+}
+
+// Keys walks the shards one at a time, so a writer is only ever blocked
+// while its own shard is being scanned.
+func (s *Storage) Keys(pattern string) []string {
+	keys := make([]string, 0)
+	now := utcNow()
+
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for key, item := range sh.data {
+			if checkIfExpired(&item.ExpiresAt, now) {
+				continue
+			}
+
+			if pattern == "*" || matchPattern(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	return keys
+}
+
+// indexExpiry keeps sh.expires in sync with the TTL of item. The caller
+// must hold sh.mu for writing.
+func (sh *storageShard) indexExpiry(key string, item *StorageItem) {
+	if item.ExpiresAt.IsZero() {
+		delete(sh.expires, key)
+		return
 	}
+	sh.expires[key] = struct{}{}
+}
 
-	return key == pattern
+func itemSize(key string, item *StorageItem) int64 {
+	return int64(len(key)+len(item.Value)+len(item.ContentType)+itemOverhead) + item.size
 }
 
 func checkIfExpired(expiresAt *time.Time, now *time.Time) bool {
@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// NoWait as the timeout of a blocking pop makes it return at once when
+// there is nothing to pop, as inside a transaction. The pop then runs
+// entirely under the gate held by the caller.
+const NoWait time.Duration = -1
+
+// waiter is a client blocked until one of its keys receives an element of
+// typ. It is queued on every key it waits for and served at most once, by
+// whichever write reaches it first.
+type waiter struct {
+	typ ValueType
+	// left pops list heads, or the lowest scores of sorted sets.
+	left bool
+	// move waiters are only woken, and then move the element themselves
+	// with the locks of both lists held, see BlockingMove.
+	move bool
+	ch   chan delivery
+
+	mu   sync.Mutex
+	done bool
+}
+
+// delivery is an element popped for a waiter. The score is only set for
+// sorted sets.
+type delivery struct {
+	key   string
+	elem  []byte
+	score float64
+}
+
+func newWaiter(typ ValueType, left bool) *waiter {
+	return &waiter{typ: typ, left: left, ch: make(chan delivery, 1)}
+}
+
+// claim marks w as served, or reports false when it already was or gave up.
+func (w *waiter) claim() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done {
+		return false
+	}
+	w.done = true
+	return true
+}
+
+// blockingPop pops an element from the first non-empty aggregate of typ
+// among keys, waiting like BlockingPop when all of them are empty. Only the
+// first attempt holds the gates of keys, so a blocked client does not hold off
+// transactions.
+func (s *Storage) blockingPop(ctx context.Context, keys []string, typ ValueType, left bool, timeout time.Duration) (delivery, bool, error) {
+	w := newWaiter(typ, left)
+
+	var queued []string
+	defer func() {
+		s.unblock(w, queued)
+	}()
+
+	if timeout == NoWait {
+		d, popped, _, err := s.popOrQueue(w, keys, false)
+		return d, popped, err
+	}
+
+	unlock := s.Shared(keys...)
+	d, popped, queued, err := s.popOrQueue(w, keys, true)
+	unlock()
+	if popped || err != nil {
+		return d, popped, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case d := <-w.ch:
+		return d, true, nil
+	case <-expired:
+		if w.claim() {
+			return delivery{}, false, nil
+		}
+		return <-w.ch, true, nil
+	case <-ctx.Done():
+		if w.claim() {
+			return delivery{}, false, ctx.Err()
+		}
+		// The element was popped for a client that went away, so put it
+		// back where it came from.
+		s.undoPop(<-w.ch, typ, left)
+		return delivery{}, false, ctx.Err()
+	}
+}
+
+// popOrQueue pops for w from the first of keys holding an element, and
+// when queue is set puts w in the wait queue of every empty key before it.
+// It returns the keys w was queued on. Nothing is popped when w was served
+// through one of them in the meantime.
+func (s *Storage) popOrQueue(w *waiter, keys []string, queue bool) (delivery, bool, []string, error) {
+	var queued []string
+	for _, key := range keys {
+		sh := s.shardFor(key)
+		sh.lock()
+
+		item, err := s.writableLocked(sh, key, w.typ, utcNow())
+		if err != nil {
+			sh.mu.Unlock()
+			if w.claim() {
+				return delivery{}, false, queued, err
+			}
+			break
+		}
+
+		if item != nil {
+			if !w.claim() {
+				// Served by a key queued earlier in the meantime.
+				sh.mu.Unlock()
+				break
+			}
+
+			d := s.popForWaiterLocked(sh, key, item, w.left)
+			sh.mu.Unlock()
+			return d, true, queued, nil
+		}
+
+		if queue {
+			sh.waiters[key] = append(sh.waiters[key], w)
+			queued = append(queued, key)
+		}
+		sh.mu.Unlock()
+	}
+	return delivery{}, false, queued, nil
+}
+
+// popForWaiterLocked pops one element of item for a blocked client and
+// propagates the pop. The caller must hold sh.mu for writing.
+func (s *Storage) popForWaiterLocked(sh *storageShard, key string, item *StorageItem, left bool) delivery {
+	d := delivery{key: key}
+	switch item.Type {
+	case TypeList:
+		d.elem = s.popElemLocked(item, left)
+		s.propagate(popCommand(key, left, 1))
+	case TypeZSet:
+		m := s.zpopLocked(item, !left)
+		d.elem, d.score = []byte(m.Member), m.Score
+		s.propagate(command("ZREM", key, d.elem))
+	}
+
+	s.dropIfEmptyLocked(sh, key, item)
+	return d
+}
+
+// undoPop puts back an element popped for a client that went away.
+func (s *Storage) undoPop(d delivery, typ ValueType, left bool) {
+	unlock := s.Shared(d.key)
+	defer unlock()
+
+	switch typ {
+	case TypeList:
+		s.Push(d.key, [][]byte{d.elem}, left)
+	case TypeZSet:
+		s.ZAdd(d.key, []ZMember{{Member: string(d.elem), Score: d.score}}, ZAddOptions{})
+	}
+}
+
+// unblock removes w from the wait queues of keys.
+func (s *Storage) unblock(w *waiter, keys []string) {
+	for _, key := range keys {
+		sh := s.shardFor(key)
+		sh.lock()
+
+		queue := sh.waiters[key]
+		if i := slices.Index(queue, w); i >= 0 {
+			queue = slices.Delete(queue, i, i+1)
+		}
+		if len(queue) == 0 {
+			delete(sh.waiters, key)
+		} else {
+			sh.waiters[key] = queue
+		}
+
+		sh.mu.Unlock()
+	}
+}
+
+// serveWaiters hands an element a woken client did not take to the next
+// client blocked on key.
+func (s *Storage) serveWaiters(key string) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	if item, _ := s.writableLocked(sh, key, TypeList, utcNow()); item != nil {
+		s.serveWaitersLocked(sh, key, item)
+	}
+}
+
+// serveWaitersLocked hands elements of the aggregate under key to the
+// clients blocked on it, oldest first, right after a write added some.
+// Clients waiting for another type stay blocked. The caller must hold sh.mu
+// for writing.
+func (s *Storage) serveWaitersLocked(sh *storageShard, key string, item *StorageItem) {
+	queue := sh.waiters[key]
+	kept := queue[:0]
+	// reserved counts the elements left for the move waiters woken.
+	reserved := 0
+	for _, w := range queue {
+		if w.typ != item.Type || item.length() <= reserved {
+			kept = append(kept, w)
+			continue
+		}
+		if !w.claim() {
+			continue
+		}
+
+		if w.move {
+			reserved++
+			w.ch <- delivery{key: key}
+			continue
+		}
+		w.ch <- s.popForWaiterLocked(sh, key, item, w.left)
+	}
+
+	if len(kept) == 0 {
+		delete(sh.waiters, key)
+	} else {
+		sh.waiters[key] = kept
+	}
+	s.dropIfEmptyLocked(sh, key, item)
+}
@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+var (
+	ErrHashNotInteger = errors.New("hash value is not an integer")
+	ErrHashNotFloat   = errors.New("hash value is not a float")
+	ErrIncrOverflow   = errors.New("increment or decrement would overflow")
+	ErrIncrNaN        = errors.New("increment would produce NaN or Infinity")
+)
+
+// hashFieldOverhead approximates the bytes taken by a hash map entry on top
+// of the raw field and value.
+const hashFieldOverhead = 16
+
+type HashField struct {
+	Field string
+	Value []byte
+}
+
+// HSet stores fields in the hash under key, creating it when missing. With
+// onlyNew, fields that already exist are left untouched. It returns the
+// number of fields that were added.
+func (s *Storage) HSet(key string, fields []HashField, onlyNew bool) (int, error) {
+	size := int64(len(key) + itemOverhead)
+	for _, f := range fields {
+		size += hashFieldSize(f.Field, f.Value)
+	}
+	if err := s.reserve(key, size); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	item, err := s.writableLocked(sh, key, TypeHash, now)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		item = &StorageItem{Type: TypeHash, Hash: make(map[string][]byte, len(fields))}
+		s.storeLocked(sh, key, item, now)
+	}
+
+	added := 0
+	cmd := command("HSET", key)
+	for _, f := range fields {
+		old, exists := item.Hash[f.Field]
+		if exists && onlyNew {
+			continue
+		}
+		if !exists {
+			added++
+		}
+
+		delta := hashFieldSize(f.Field, f.Value)
+		if exists {
+			delta -= hashFieldSize(f.Field, old)
+		}
+
+		item.Hash[f.Field] = f.Value
+		s.resizeLocked(item, delta)
+		cmd = append(cmd, []byte(f.Field), f.Value)
+	}
+
+	if len(cmd) > 2 {
+		s.propagate(cmd)
+	}
+	return added, nil
+}
+
+func (s *Storage) HGet(key, field string) ([]byte, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeHash)
+	if item == nil {
+		return nil, false, err
+	}
+
+	val, exists := item.Hash[field]
+	return val, exists, nil
+}
+
+// HMGet returns the values of fields in order, nil for missing ones.
+func (s *Storage) HMGet(key string, fields []string) ([][]byte, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	values := make([][]byte, len(fields))
+	item, err := s.readableLocked(sh, key, TypeHash)
+	if item == nil {
+		return values, err
+	}
+
+	for i, field := range fields {
+		values[i] = item.Hash[field]
+	}
+	return values, nil
+}
+
+func (s *Storage) HLen(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeHash)
+	if item == nil {
+		return 0, err
+	}
+	return len(item.Hash), nil
+}
+
+func (s *Storage) HGetAll(key string) ([]HashField, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeHash)
+	if item == nil {
+		return nil, err
+	}
+
+	fields := make([]HashField, 0, len(item.Hash))
+	for field, val := range item.Hash {
+		fields = append(fields, HashField{Field: field, Value: val})
+	}
+	return fields, nil
+}
+
+// HDel removes fields from the hash under key and removes the key once the
+// hash is empty. It returns the number of fields removed.
+func (s *Storage) HDel(key string, fields []string) (int, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeHash, utcNow())
+	if item == nil {
+		return 0, err
+	}
+
+	cmd := command("HDEL", key)
+	for _, field := range fields {
+		val, exists := item.Hash[field]
+		if !exists {
+			continue
+		}
+
+		delete(item.Hash, field)
+		s.resizeLocked(item, -hashFieldSize(field, val))
+		cmd = append(cmd, []byte(field))
+	}
+
+	s.dropIfEmptyLocked(sh, key, item)
+	if len(cmd) > 2 {
+		s.propagate(cmd)
+	}
+	return len(cmd) - 2, nil
+}
+
+// HIncrBy adds delta to the integer stored in field, starting from 0 when
+// the field is missing.
+func (s *Storage) HIncrBy(key, field string, delta int64) (int64, error) {
+	var result int64
+	err := s.hashUpdate(key, field, func(old []byte, exists bool) ([]byte, error) {
+		var current int64
+		if exists {
+			n, err := strconv.ParseInt(string(old), 10, 64)
+			if err != nil {
+				return nil, ErrHashNotInteger
+			}
+			current = n
+		}
+
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return nil, ErrIncrOverflow
+		}
+
+		result = current + delta
+		return strconv.AppendInt(nil, result, 10), nil
+	})
+	return result, err
+}
+
+// HIncrByFloat adds delta to the number stored in field and returns the new
+// value as it was stored.
+func (s *Storage) HIncrByFloat(key, field string, delta float64) ([]byte, error) {
+	var result []byte
+	err := s.hashUpdate(key, field, func(old []byte, exists bool) ([]byte, error) {
+		var current float64
+		if exists {
+			n, err := strconv.ParseFloat(string(old), 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return nil, ErrHashNotFloat
+			}
+			current = n
+		}
+
+		sum := current + delta
+		if math.IsNaN(sum) || math.IsInf(sum, 0) {
+			return nil, ErrIncrNaN
+		}
+
+		result = strconv.AppendFloat(nil, sum, 'f', -1, 64)
+		return result, nil
+	})
+	return result, err
+}
+
+// hashUpdate replaces field with the value computed by fn from its current
+// value, creating the hash when missing. The new value is propagated as an
+// HSET, so replaying it does not depend on the previous value.
+func (s *Storage) hashUpdate(key, field string, fn func(old []byte, exists bool) ([]byte, error)) error {
+	// The new value is not known yet, but a formatted number is small.
+	if err := s.reserve(key, int64(len(key)+itemOverhead)+hashFieldSize(field, nil)+32); err != nil {
+		return err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	item, err := s.writableLocked(sh, key, TypeHash, now)
+	if err != nil {
+		return err
+	}
+
+	var old []byte
+	var exists bool
+	if item != nil {
+		old, exists = item.Hash[field]
+	}
+
+	val, err := fn(old, exists)
+	if err != nil {
+		return err
+	}
+
+	if item == nil {
+		item = &StorageItem{Type: TypeHash, Hash: make(map[string][]byte, 1)}
+		s.storeLocked(sh, key, item, now)
+	}
+
+	delta := hashFieldSize(field, val)
+	if exists {
+		delta -= hashFieldSize(field, old)
+	}
+
+	item.Hash[field] = val
+	s.resizeLocked(item, delta)
+	s.propagate(command("HSET", key, []byte(field), val))
+	return nil
+}
+
+// HScan returns a page of the fields of the hash under key, see scanPage for
+// the cursor semantics. Only fields matching pattern are returned, which may
+// leave a page empty while the cursor is not yet 0.
+func (s *Storage) HScan(key string, cursor uint64, pattern string, count int) ([]HashField, uint64, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeHash)
+	if item == nil {
+		return nil, 0, err
+	}
+
+	names := make([]string, 0, len(item.Hash))
+	for field := range item.Hash {
+		names = append(names, field)
+	}
+
+	page, next := scanPage(names, cursor, count)
+	fields := make([]HashField, 0, len(page))
+	for _, field := range page {
+		if pattern == "*" || matchPattern(pattern, field) {
+			fields = append(fields, HashField{Field: field, Value: item.Hash[field]})
+		}
+	}
+	return fields, next, nil
+}
+
+func hashFieldSize(field string, val []byte) int64 {
+	return int64(len(field) + len(val) + hashFieldOverhead)
+}
@@ -0,0 +1,456 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNoSuchKey       = errors.New("no such key")
+	ErrIndexOutOfRange = errors.New("index out of range")
+)
+
+// listElemOverhead approximates the bytes taken by a list slot on top of
+// the raw element.
+const listElemOverhead = 24
+
+// Push adds elems one by one to the head of the list under key, or to its
+// tail unless left, creating the list when missing. It returns the length
+// of the list after the push, before any blocked client is served.
+func (s *Storage) Push(key string, elems [][]byte, left bool) (int, error) {
+	size := int64(len(key) + itemOverhead)
+	for _, elem := range elems {
+		size += listElemSize(elem)
+	}
+	if err := s.reserve(key, size); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	item, err := s.writableLocked(sh, key, TypeList, now)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		item = &StorageItem{Type: TypeList, List: newList(nil)}
+		s.storeLocked(sh, key, item, now)
+	}
+
+	for _, elem := range elems {
+		s.pushElemLocked(item, elem, left)
+	}
+	length := item.List.Len()
+
+	if left {
+		s.propagate(command("LPUSH", key, elems...))
+	} else {
+		s.propagate(command("RPUSH", key, elems...))
+	}
+
+	s.serveWaitersLocked(sh, key, item)
+	return length, nil
+}
+
+// Pop removes up to count elements from the head of the list under key, or
+// from its tail unless left. It returns nil when the key does not exist.
+func (s *Storage) Pop(key string, left bool, count int) ([][]byte, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeList, utcNow())
+	if item == nil {
+		return nil, err
+	}
+
+	elems := make([][]byte, 0, min(count, item.List.Len()))
+	for len(elems) < count && item.List.Len() > 0 {
+		elems = append(elems, s.popElemLocked(item, left))
+	}
+
+	if len(elems) > 0 {
+		s.propagate(popCommand(key, left, len(elems)))
+	}
+	s.dropIfEmptyLocked(sh, key, item)
+	return elems, nil
+}
+
+func (s *Storage) LLen(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeList)
+	if item == nil {
+		return 0, err
+	}
+	return item.List.Len(), nil
+}
+
+// LRange returns the elements from start to stop inclusive, negative
+// indexes counting from the tail.
+func (s *Storage) LRange(key string, start, stop int) ([][]byte, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeList)
+	if item == nil {
+		return nil, err
+	}
+
+	start, stop, ok := normalizeRange(start, stop, item.List.Len())
+	if !ok {
+		return nil, nil
+	}
+	return item.List.slice(start, stop), nil
+}
+
+func (s *Storage) LIndex(key string, index int) ([]byte, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeList)
+	if item == nil {
+		return nil, false, err
+	}
+
+	index, ok := normalizeIndex(index, item.List.Len())
+	if !ok {
+		return nil, false, nil
+	}
+	return item.List.at(index), true, nil
+}
+
+func (s *Storage) LSet(key string, index int, elem []byte) error {
+	if err := s.reserve(key, listElemSize(elem)); err != nil {
+		return err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeList, utcNow())
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return ErrNoSuchKey
+	}
+
+	i, ok := normalizeIndex(index, item.List.Len())
+	if !ok {
+		return ErrIndexOutOfRange
+	}
+
+	s.resizeLocked(item, listElemSize(elem)-listElemSize(item.List.at(i)))
+	item.List.set(i, elem)
+	s.propagate(command("LSET", key, strconv.AppendInt(nil, int64(index), 10), elem))
+	return nil
+}
+
+// LRem removes the first count occurrences of elem, the last -count ones
+// when count is negative, or all of them when count is 0.
+func (s *Storage) LRem(key string, count int, elem []byte) (int, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeList, utcNow())
+	if item == nil {
+		return 0, err
+	}
+
+	elems := item.List.all()
+	limit := count
+	if count < 0 {
+		limit = -count
+		slices.Reverse(elems)
+	}
+
+	kept := elems[:0]
+	removed := 0
+	for _, e := range elems {
+		if (limit == 0 || removed < limit) && bytes.Equal(e, elem) {
+			s.resizeLocked(item, -listElemSize(e))
+			removed++
+			continue
+		}
+		kept = append(kept, e)
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if count < 0 {
+		slices.Reverse(kept)
+	}
+	item.List.replace(kept)
+	s.propagate(command("LREM", key, strconv.AppendInt(nil, int64(count), 10), elem))
+	s.dropIfEmptyLocked(sh, key, item)
+	return removed, nil
+}
+
+// LTrim keeps only the elements from start to stop inclusive.
+func (s *Storage) LTrim(key string, start, stop int) error {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeList, utcNow())
+	if item == nil {
+		return err
+	}
+
+	from, to, ok := normalizeRange(start, stop, item.List.Len())
+	if !ok {
+		from, to = item.List.Len(), item.List.Len()-1
+	}
+	for range from {
+		s.popElemLocked(item, true)
+	}
+	for item.List.Len() > to-from+1 {
+		s.popElemLocked(item, false)
+	}
+
+	s.propagate(command("LTRIM", key, strconv.AppendInt(nil, int64(start), 10), strconv.AppendInt(nil, int64(stop), 10)))
+	s.dropIfEmptyLocked(sh, key, item)
+	return nil
+}
+
+// LInsert inserts elem before or after the first occurrence of pivot. It
+// returns the new length, -1 when pivot was not found and 0 when the key
+// does not exist.
+func (s *Storage) LInsert(key string, before bool, pivot, elem []byte) (int, error) {
+	if err := s.reserve(key, listElemSize(elem)); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeList, utcNow())
+	if item == nil {
+		return 0, err
+	}
+
+	elems := item.List.all()
+	at := slices.IndexFunc(elems, func(e []byte) bool {
+		return bytes.Equal(e, pivot)
+	})
+	if at < 0 {
+		return -1, nil
+	}
+
+	where := "BEFORE"
+	if !before {
+		where = "AFTER"
+		at++
+	}
+
+	item.List.replace(slices.Insert(elems, at, elem))
+	s.resizeLocked(item, listElemSize(elem))
+	s.propagate(command("LINSERT", key, []byte(where), pivot, elem))
+	return item.List.Len(), nil
+}
+
+// LPos returns the positions of elem, see the LPOS command: rank selects
+// the first match to return, negative ranks searching from the tail, count
+// limits the matches, 0 meaning all, and maxLen the elements compared, 0
+// meaning all.
+func (s *Storage) LPos(key string, elem []byte, rank, count, maxLen int) ([]int, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeList)
+	if item == nil {
+		return nil, err
+	}
+
+	n := item.List.Len()
+	step, i := 1, 0
+	skip := rank - 1
+	if rank < 0 {
+		step, i = -1, n-1
+		skip = -rank - 1
+	}
+
+	var positions []int
+	for compared := 0; i >= 0 && i < n; i += step {
+		if maxLen > 0 && compared == maxLen {
+			break
+		}
+		compared++
+
+		if !bytes.Equal(item.List.at(i), elem) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		positions = append(positions, i)
+		if count > 0 && len(positions) == count {
+			break
+		}
+	}
+	return positions, nil
+}
+
+// LMove atomically pops an element from src and pushes it to dst, which may
+// be the same list. It reports false when src does not exist.
+func (s *Storage) LMove(src, dst string, srcLeft, dstLeft bool) ([]byte, bool, error) {
+	return s.moveOrQueue(nil, src, dst, srcLeft, dstLeft)
+}
+
+// moveOrQueue is LMove, which puts w in the wait queue of src when src does
+// not exist and w is not nil. The type of dst is checked before anything is
+// popped, and an element left in src then goes to the next blocked client.
+func (s *Storage) moveOrQueue(w *waiter, src, dst string, srcLeft, dstLeft bool) ([]byte, bool, error) {
+	srcShard, dstShard, unlock := s.lockPair(src, dst)
+	defer unlock()
+
+	now := utcNow()
+	srcItem, err := s.writableLocked(srcShard, src, TypeList, now)
+	if srcItem == nil {
+		if err == nil && w != nil {
+			srcShard.waiters[src] = append(srcShard.waiters[src], w)
+		}
+		return nil, false, err
+	}
+	dstItem, err := s.writableLocked(dstShard, dst, TypeList, now)
+	if err != nil {
+		s.serveWaitersLocked(srcShard, src, srcItem)
+		return nil, false, err
+	}
+
+	elem := s.popElemLocked(srcItem, srcLeft)
+	if dstItem == nil {
+		dstItem = &StorageItem{Type: TypeList, List: newList(nil)}
+		s.storeLocked(dstShard, dst, dstItem, now)
+	}
+	s.pushElemLocked(dstItem, elem, dstLeft)
+
+	s.propagate(command("LMOVE", src, []byte(dst), listEnd(srcLeft), listEnd(dstLeft)))
+	s.dropIfEmptyLocked(srcShard, src, srcItem)
+	s.serveWaitersLocked(dstShard, dst, dstItem)
+	return elem, true, nil
+}
+
+// BlockingPop pops an element from the first non-empty list of keys. When
+// all of them are empty it waits until an element is pushed to one of them,
+// timeout passes or ctx is done, timeout 0 meaning no limit. Clients are
+// served in the order they started waiting. It reports false on timeout.
+func (s *Storage) BlockingPop(ctx context.Context, keys []string, left bool, timeout time.Duration) (string, []byte, bool, error) {
+	d, ok, err := s.blockingPop(ctx, keys, TypeList, left, timeout)
+	return d.key, d.elem, ok, err
+}
+
+// BlockingMove is LMove waiting for src like BlockingPop. The client is
+// only woken when an element arrives, and then makes the whole move like
+// LMove, so it is atomic and propagated as a single LMOVE. Clients woken
+// together may move their elements in any order, and a client whose element
+// was taken by another one in the meantime waits again.
+func (s *Storage) BlockingMove(ctx context.Context, src, dst string, srcLeft, dstLeft bool, timeout time.Duration) ([]byte, bool, error) {
+	if timeout == NoWait {
+		return s.LMove(src, dst, srcLeft, dstLeft)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		w := newWaiter(TypeList, srcLeft)
+		w.move = true
+
+		unlock := s.Shared(src, dst)
+		elem, ok, err := s.moveOrQueue(w, src, dst, srcLeft, dstLeft)
+		unlock()
+		if ok || err != nil {
+			return elem, ok, err
+		}
+
+		select {
+		case <-w.ch:
+			continue
+		case <-expired:
+			err = nil
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		s.unblock(w, []string{src})
+		if !w.claim() {
+			// Woken at the same time, so the element left for this client
+			// goes to the next one.
+			s.serveWaiters(src)
+		}
+		return nil, false, err
+	}
+}
+
+func (s *Storage) pushElemLocked(item *StorageItem, elem []byte, left bool) {
+	if left {
+		item.List.pushFront(elem)
+	} else {
+		item.List.pushBack(elem)
+	}
+	s.resizeLocked(item, listElemSize(elem))
+}
+
+func (s *Storage) popElemLocked(item *StorageItem, left bool) []byte {
+	var elem []byte
+	if left {
+		elem = item.List.popFront()
+	} else {
+		elem = item.List.popBack()
+	}
+	s.resizeLocked(item, -listElemSize(elem))
+	return elem
+}
+
+func popCommand(key string, left bool, count int) [][]byte {
+	name := "RPOP"
+	if left {
+		name = "LPOP"
+	}
+	return command(name, key, strconv.AppendInt(nil, int64(count), 10))
+}
+
+func listEnd(left bool) []byte {
+	if left {
+		return []byte("LEFT")
+	}
+	return []byte("RIGHT")
+}
+
+func listElemSize(elem []byte) int64 {
+	return int64(len(elem) + listElemOverhead)
+}
+
+// normalizeIndex resolves a Redis style index, negative values counting
+// from the end, against a length of n.
+func normalizeIndex(index, n int) (int, bool) {
+	if index < 0 {
+		index += n
+	}
+	return index, index >= 0 && index < n
+}
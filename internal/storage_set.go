@@ -0,0 +1,389 @@
+package internal
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+)
+
+// setMemberOverhead approximates the bytes taken by a set map entry on top
+// of the raw member.
+const setMemberOverhead = 16
+
+// SetOp is an operation combining several sets into one.
+type SetOp byte
+
+const (
+	SetUnion SetOp = iota
+	SetInter
+	SetDiff
+)
+
+// String returns the name of the command performing op.
+func (op SetOp) String() string {
+	switch op {
+	case SetInter:
+		return "SINTER"
+	case SetDiff:
+		return "SDIFF"
+	}
+	return "SUNION"
+}
+
+// SAdd adds members to the set under key, creating it when missing, and
+// returns how many of them were not members yet.
+func (s *Storage) SAdd(key string, members []string) (int, error) {
+	size := int64(len(key) + itemOverhead)
+	for _, m := range members {
+		size += setMemberSize(m)
+	}
+	if err := s.reserve(key, size); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	item, err := s.writableLocked(sh, key, TypeSet, now)
+	if err != nil {
+		return 0, err
+	}
+	if item == nil {
+		item = &StorageItem{Type: TypeSet, Set: make(map[string]struct{}, len(members))}
+		s.storeLocked(sh, key, item, now)
+	}
+
+	cmd := command("SADD", key)
+	for _, m := range members {
+		if _, exists := item.Set[m]; exists {
+			continue
+		}
+
+		item.Set[m] = struct{}{}
+		s.resizeLocked(item, setMemberSize(m))
+		cmd = append(cmd, []byte(m))
+	}
+
+	if len(cmd) > 2 {
+		s.propagate(cmd)
+	}
+	s.dropIfEmptyLocked(sh, key, item)
+	return len(cmd) - 2, nil
+}
+
+// SRem removes members from the set under key and returns how many of
+// them were members. The key is removed with its last member.
+func (s *Storage) SRem(key string, members []string) (int, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeSet, utcNow())
+	if item == nil {
+		return 0, err
+	}
+
+	cmd := command("SREM", key)
+	for _, m := range members {
+		if _, exists := item.Set[m]; !exists {
+			continue
+		}
+
+		delete(item.Set, m)
+		s.resizeLocked(item, -setMemberSize(m))
+		cmd = append(cmd, []byte(m))
+	}
+
+	if len(cmd) > 2 {
+		s.propagate(cmd)
+	}
+	s.dropIfEmptyLocked(sh, key, item)
+	return len(cmd) - 2, nil
+}
+
+func (s *Storage) SMembers(key string) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeSet)
+	if item == nil {
+		return nil, err
+	}
+	return slices.Collect(maps.Keys(item.Set)), nil
+}
+
+// SMIsMember reports for each of members whether it belongs to the set
+// under key.
+func (s *Storage) SMIsMember(key string, members []string) ([]bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeSet)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]bool, len(members))
+	if item != nil {
+		for i, m := range members {
+			_, found[i] = item.Set[m]
+		}
+	}
+	return found, nil
+}
+
+func (s *Storage) SCard(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeSet)
+	if item == nil {
+		return 0, err
+	}
+	return len(item.Set), nil
+}
+
+// SPop removes and returns up to count random members. They follow map
+// iteration order, which Go randomizes, so the pick is cheap but not
+// uniform. The removal is propagated as SREM so replicas drop the same
+// members.
+func (s *Storage) SPop(key string, count int) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeSet, utcNow())
+	if item == nil {
+		return nil, err
+	}
+
+	popped := make([]string, 0, min(count, len(item.Set)))
+	for m := range item.Set {
+		if len(popped) == count {
+			break
+		}
+
+		delete(item.Set, m)
+		s.resizeLocked(item, -setMemberSize(m))
+		popped = append(popped, m)
+	}
+
+	if len(popped) > 0 {
+		s.propagate(command("SREM", key, stringsToBytes(popped)...))
+	}
+	s.dropIfEmptyLocked(sh, key, item)
+	return popped, nil
+}
+
+// SRandMember returns up to count distinct random members, or exactly
+// -count members possibly repeated when count is negative.
+func (s *Storage) SRandMember(key string, count int) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeSet)
+	if item == nil {
+		return nil, err
+	}
+
+	members := slices.Collect(maps.Keys(item.Set))
+	if count < 0 {
+		picked := make([]string, -count)
+		for i := range picked {
+			picked[i] = members[rand.IntN(len(members))]
+		}
+		return picked, nil
+	}
+
+	if count >= len(members) {
+		return members, nil
+	}
+
+	// A partial Fisher-Yates shuffle picks count distinct members.
+	for i := range count {
+		j := i + rand.IntN(len(members)-i)
+		members[i], members[j] = members[j], members[i]
+	}
+	return members[:count], nil
+}
+
+// SMove atomically moves member from the set under src to the one under
+// dst. It reports false when member does not belong to src.
+func (s *Storage) SMove(src, dst, member string) (bool, error) {
+	if err := s.reserve(dst, int64(len(dst)+itemOverhead)+setMemberSize(member)); err != nil {
+		return false, err
+	}
+
+	srcShard, dstShard, unlock := s.lockPair(src, dst)
+	defer unlock()
+
+	now := utcNow()
+	srcItem, err := s.writableLocked(srcShard, src, TypeSet, now)
+	if err != nil {
+		return false, err
+	}
+	dstItem, err := s.writableLocked(dstShard, dst, TypeSet, now)
+	if err != nil {
+		return false, err
+	}
+
+	if srcItem == nil {
+		return false, nil
+	}
+	if _, exists := srcItem.Set[member]; !exists {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+
+	delete(srcItem.Set, member)
+	s.resizeLocked(srcItem, -setMemberSize(member))
+	s.dropIfEmptyLocked(srcShard, src, srcItem)
+
+	if dstItem == nil {
+		dstItem = &StorageItem{Type: TypeSet, Set: make(map[string]struct{}, 1)}
+		s.storeLocked(dstShard, dst, dstItem, now)
+	}
+	if _, exists := dstItem.Set[member]; !exists {
+		dstItem.Set[member] = struct{}{}
+		s.resizeLocked(dstItem, setMemberSize(member))
+	}
+
+	s.propagate(command("SMOVE", src, []byte(dst), []byte(member)))
+	return true, nil
+}
+
+// SetOp combines the sets under keys, missing keys counting as empty sets.
+// SetDiff subtracts the other sets from the first one.
+func (s *Storage) SetOp(op SetOp, keys []string) ([]string, error) {
+	unlock := s.lockKeys(keys, false)
+	defer unlock()
+
+	result, err := s.setOpLocked(op, keys)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Collect(maps.Keys(result)), nil
+}
+
+// SetOpStore stores the result of SetOp under dst, replacing any value it
+// held, or removes dst when the result is empty. It returns the number of
+// members stored.
+func (s *Storage) SetOpStore(op SetOp, dst string, keys []string) (int, error) {
+	if err := s.reserve(dst, int64(len(dst)+itemOverhead)); err != nil {
+		return 0, err
+	}
+
+	unlock := s.lockKeys(append([]string{dst}, keys...), true)
+	defer unlock()
+
+	result, err := s.setOpLocked(op, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(dst)
+	if len(result) == 0 {
+		if s.removeLocked(sh, dst) {
+			s.notify(NotifyGeneric, "del", dst)
+		}
+	} else {
+		item := &StorageItem{Type: TypeSet, Set: result}
+		for m := range result {
+			item.size += setMemberSize(m)
+		}
+		s.storeLocked(sh, dst, item, utcNow())
+	}
+
+	s.propagate(command(op.String()+"STORE", dst, stringsToBytes(keys)...))
+	return len(result), nil
+}
+
+// setOpLocked computes op over the sets under keys into a new map. The
+// caller must hold the shards of all keys.
+func (s *Storage) setOpLocked(op SetOp, keys []string) (map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		item, err := s.readableLocked(s.shardFor(key), key, TypeSet)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			sets[i] = item.Set
+		}
+	}
+
+	result := make(map[string]struct{})
+	switch op {
+	case SetUnion:
+		for _, set := range sets {
+			maps.Copy(result, set)
+		}
+	case SetInter:
+		// Probe the other sets with the members of the smallest one.
+		smallest := slices.MinFunc(sets, func(a, b map[string]struct{}) int {
+			return len(a) - len(b)
+		})
+		for m := range smallest {
+			if inAll(sets, m) {
+				result[m] = struct{}{}
+			}
+		}
+	case SetDiff:
+		for m := range sets[0] {
+			if !inAny(sets[1:], m) {
+				result[m] = struct{}{}
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *Storage) SScan(key string, cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeSet)
+	if item == nil {
+		return nil, 0, err
+	}
+
+	page, next := scanPage(slices.Collect(maps.Keys(item.Set)), cursor, count)
+	members := page[:0]
+	for _, m := range page {
+		if pattern == "*" || matchPattern(pattern, m) {
+			members = append(members, m)
+		}
+	}
+	return members, next, nil
+}
+
+func inAll(sets []map[string]struct{}, member string) bool {
+	for _, set := range sets {
+		if _, exists := set[member]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+func inAny(sets []map[string]struct{}, member string) bool {
+	for _, set := range sets {
+		if _, exists := set[member]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+func setMemberSize(member string) int64 {
+	return int64(len(member) + setMemberOverhead)
+}
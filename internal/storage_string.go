@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotInteger    = errors.New("value is not an integer or out of range")
+	ErrNotFloat      = errors.New("value is not a valid float")
+	ErrStringTooLong = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// maxStringLen caps the strings grown by APPEND and SETRANGE, as
+// proto-max-bulk-len does in Redis.
+const maxStringLen = 512 << 20
+
+// IncrBy adds delta to the integer stored under key, starting from 0 when
+// the key is missing. A key it creates expires at expiresAt, an existing
+// key keeps its TTL.
+func (s *Storage) IncrBy(key string, delta int64, expiresAt time.Time) (int64, error) {
+	var result int64
+	err := s.stringUpdate(key, "incrby", 32, expiresAt, func(old []byte, exists bool) ([]byte, error) {
+		var current int64
+		if exists {
+			n, err := strconv.ParseInt(string(old), 10, 64)
+			if err != nil {
+				return nil, ErrNotInteger
+			}
+			current = n
+		}
+
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return nil, ErrIncrOverflow
+		}
+
+		result = current + delta
+		return strconv.AppendInt(nil, result, 10), nil
+	})
+	return result, err
+}
+
+// IncrByFloat adds delta to the number stored under key like IncrBy and
+// returns the new value as it was stored.
+func (s *Storage) IncrByFloat(key string, delta float64, expiresAt time.Time) ([]byte, error) {
+	var result []byte
+	err := s.stringUpdate(key, "incrbyfloat", 32, expiresAt, func(old []byte, exists bool) ([]byte, error) {
+		var current float64
+		if exists {
+			n, err := strconv.ParseFloat(string(old), 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return nil, ErrNotFloat
+			}
+			current = n
+		}
+
+		sum := current + delta
+		if math.IsNaN(sum) || math.IsInf(sum, 0) {
+			return nil, ErrIncrNaN
+		}
+
+		result = strconv.AppendFloat(nil, sum, 'f', -1, 64)
+		return result, nil
+	})
+	return result, err
+}
+
+// Append adds val to the end of the string under key, creating it like
+// IncrBy, and returns the new length.
+func (s *Storage) Append(key string, val []byte, expiresAt time.Time) (int, error) {
+	var length int
+	err := s.stringUpdate(key, "append", int64(len(val)), expiresAt, func(old []byte, _ bool) ([]byte, error) {
+		if len(old)+len(val) > maxStringLen {
+			return nil, ErrStringTooLong
+		}
+
+		// The old value may be shared with readers, or with the buffer it
+		// was read from, so never grow it in place.
+		updated := append(old[:len(old):len(old)], val...)
+		length = len(updated)
+		return updated, nil
+	})
+	return length, err
+}
+
+// SetRange overwrites the string under key from offset with val, padding it
+// with zero bytes when it is shorter than offset, and returns the new
+// length. A missing key is created like IncrBy unless val is empty.
+func (s *Storage) SetRange(key string, offset int, val []byte, expiresAt time.Time) (int, error) {
+	if len(val) == 0 {
+		// Nothing is written, but the key still has to be a string.
+		return s.StrLen(key)
+	}
+
+	// Written this way round, so a huge offset cannot overflow.
+	if offset > maxStringLen-len(val) {
+		return 0, ErrStringTooLong
+	}
+
+	var length int
+	err := s.stringUpdate(key, "setrange", int64(offset+len(val)), expiresAt, func(old []byte, _ bool) ([]byte, error) {
+		// The old value may be shared with readers, so write to a copy.
+		updated := make([]byte, max(len(old), offset+len(val)))
+		copy(updated, old)
+		copy(updated[offset:], val)
+		length = len(updated)
+		return updated, nil
+	})
+	return length, err
+}
+
+// StrLen returns the length of the string under key, 0 when it is missing.
+func (s *Storage) StrLen(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeString)
+	if item == nil {
+		return 0, err
+	}
+	return len(item.Value), nil
+}
+
+// GetRange returns the bytes of the string under key from start to end
+// inclusive. Negative offsets count from the end of the string.
+func (s *Storage) GetRange(key string, start, end int) ([]byte, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeString)
+	if item == nil {
+		return nil, err
+	}
+
+	start, end, ok := normalizeRange(start, end, len(item.Value))
+	if !ok {
+		return []byte{}, nil
+	}
+	return item.Value[start : end+1], nil
+}
+
+// stringUpdate replaces the string under key with the value computed by fn
+// from its current value, keeping its TTL and content type. A missing key
+// is created with expiresAt. The new value is propagated as a SET, so
+// replaying it does not depend on the previous value, and announced as
+// event. grow estimates the bytes the update may add.
+func (s *Storage) stringUpdate(key, event string, grow int64, expiresAt time.Time, fn func(old []byte, exists bool) ([]byte, error)) error {
+	if err := s.reserve(key, int64(len(key)+itemOverhead)+grow); err != nil {
+		return err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	item, err := s.writableLocked(sh, key, TypeString, now)
+	if err != nil {
+		return err
+	}
+
+	var old []byte
+	if item != nil {
+		old = item.Value
+	}
+
+	val, err := fn(old, item != nil)
+	if err != nil {
+		return err
+	}
+
+	if item == nil {
+		item = &StorageItem{Value: val, ExpiresAt: expiresAt}
+		s.storeLocked(sh, key, item, now)
+	} else {
+		item.Value = val
+		item.version++
+		s.trackMemory(int64(len(val) - len(old)))
+		s.dirty.Add(1)
+	}
+
+	s.propagate(setCommand(key, item))
+	s.notify(NotifyString, event, key)
+	return nil
+}
+
+// KeyValue is a string value with its key, as passed to MSet.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// MGet returns the values of keys in order, nil for keys that are missing
+// or hold another type, looking them all up under a single lock.
+func (s *Storage) MGet(keys []string) [][]byte {
+	unlock := s.lockKeys(keys, false)
+	defer unlock()
+
+	now := utcNow()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		item := s.shardFor(key).lookupLocked(key, now)
+		if item == nil || item.Type != TypeString {
+			continue
+		}
+
+		item.touch(now)
+		values[i] = item.Value
+	}
+	return values
+}
+
+// MSet stores every pair with the expiry expiresAt, all at once. With
+// onlyNew nothing is written when any of the keys exists, and it reports
+// whether the pairs were written.
+func (s *Storage) MSet(pairs []KeyValue, expiresAt time.Time, onlyNew bool) (bool, error) {
+	keys := make([]string, len(pairs))
+	items := make([]*StorageItem, len(pairs))
+	var size int64
+	for i, p := range pairs {
+		keys[i] = p.Key
+		items[i] = &StorageItem{Value: p.Value, ExpiresAt: expiresAt}
+		size += itemSize(p.Key, items[i])
+	}
+
+	if err := s.reserve(keys[0], size); err != nil {
+		return false, err
+	}
+
+	unlock := s.lockKeys(keys, true)
+	defer unlock()
+
+	now := utcNow()
+	if onlyNew {
+		for _, key := range keys {
+			if s.shardFor(key).lookupLocked(key, now) != nil {
+				return false, nil
+			}
+		}
+	}
+
+	for i, key := range keys {
+		s.storeLocked(s.shardFor(key), key, items[i], now)
+		s.propagate(setCommand(key, items[i]))
+		s.notify(NotifyString, "set", key)
+	}
+	return true, nil
+}
+
+// GetDel removes the string under key and returns its value.
+func (s *Storage) GetDel(key string) ([]byte, bool, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeString, utcNow())
+	if item == nil {
+		return nil, false, err
+	}
+
+	s.removeLocked(sh, key)
+	s.propagate(command("DEL", key))
+	s.notify(NotifyGeneric, "del", key)
+	return item.Value, true, nil
+}
+
+// GetEx returns the string under key and, with update, changes its expiry
+// to expiresAt like Expire, a zero expiresAt removing it.
+func (s *Storage) GetEx(key string, expiresAt time.Time, update bool) ([]byte, bool, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	now := utcNow()
+	item, err := s.writableLocked(sh, key, TypeString, now)
+	if item == nil {
+		return nil, false, err
+	}
+
+	if update && !(expiresAt.IsZero() && item.ExpiresAt.IsZero()) {
+		s.expireLocked(sh, key, item, expiresAt, now)
+	}
+	return item.Value, true, nil
+}
@@ -0,0 +1,501 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var ErrScoreNaN = errors.New("resulting score is not a number (NaN)")
+
+// zsetMemberOverhead approximates the bytes taken by the map entry and skip
+// list node of a sorted set member on top of the raw member.
+const zsetMemberOverhead = 48
+
+// ZAddOptions are the conditions of ZADD. NX only adds new members, XX only
+// updates existing ones, GT and LT only update when the score grows or
+// shrinks, and CH counts updated members along with added ones.
+type ZAddOptions struct {
+	NX, XX, GT, LT, CH bool
+}
+
+// ZRangeBy selects how a ZRangeSpec addresses members.
+type ZRangeBy byte
+
+const (
+	ZByRank ZRangeBy = iota
+	ZByScore
+	ZByLex
+)
+
+// ZRangeSpec describes a ZRANGE query. Start and Stop are the ranks used by
+// ZByRank, Score and Lex the ranges used by ZByScore and ZByLex. Rev walks
+// from the highest score, and Offset and Count, negative for all, limit
+// the score and lex ranges.
+type ZRangeSpec struct {
+	By          ZRangeBy
+	Start, Stop int
+	Score       ScoreRange
+	Lex         LexRange
+	Rev         bool
+	Offset      int
+	Count       int
+}
+
+// ZAggregate combines the scores of a member found in several sets.
+type ZAggregate byte
+
+const (
+	ZAggSum ZAggregate = iota
+	ZAggMin
+	ZAggMax
+)
+
+type zaddResult byte
+
+const (
+	zaddSkipped zaddResult = iota
+	zaddUnchanged
+	zaddUpdated
+	zaddAdded
+)
+
+// ZAdd adds members to the sorted set under key, or updates their scores,
+// as allowed by opts. It returns the number of members added, plus the
+// number updated with CH.
+func (s *Storage) ZAdd(key string, members []ZMember, opts ZAddOptions) (int, error) {
+	size := int64(len(key) + itemOverhead)
+	for _, m := range members {
+		size += zsetMemberSize(m.Member)
+	}
+	if err := s.reserve(key, size); err != nil {
+		return 0, err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.zsetForWriteLocked(sh, key, !opts.XX)
+	if item == nil {
+		return 0, err
+	}
+
+	count := 0
+	cmd := command("ZADD", key)
+	for _, m := range members {
+		score, result, err := s.zaddLocked(item, m.Member, m.Score, opts, false)
+		if err != nil {
+			return count, err
+		}
+
+		if result == zaddAdded || (opts.CH && result == zaddUpdated) {
+			count++
+		}
+		if result >= zaddUpdated {
+			cmd = append(cmd, formatScore(score), []byte(m.Member))
+		}
+	}
+
+	if len(cmd) > 2 {
+		s.propagate(cmd)
+	}
+	s.serveWaitersLocked(sh, key, item)
+	return count, nil
+}
+
+// ZAddIncr adds delta to the score of member, as allowed by opts, and
+// returns the new score. It reports false when opts prevented the update.
+func (s *Storage) ZAddIncr(key, member string, delta float64, opts ZAddOptions) (float64, bool, error) {
+	if err := s.reserve(key, int64(len(key)+itemOverhead)+zsetMemberSize(member)); err != nil {
+		return 0, false, err
+	}
+
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.zsetForWriteLocked(sh, key, !opts.XX)
+	if item == nil {
+		return 0, false, err
+	}
+
+	score, result, err := s.zaddLocked(item, member, delta, opts, true)
+	if err != nil || result == zaddSkipped {
+		s.dropIfEmptyLocked(sh, key, item)
+		return 0, false, err
+	}
+
+	if result >= zaddUpdated {
+		s.propagate(command("ZADD", key, formatScore(score), []byte(member)))
+	}
+	s.serveWaitersLocked(sh, key, item)
+	return score, true, nil
+}
+
+// zsetForWriteLocked returns the sorted set under key, creating it when
+// missing if create is set. The caller must hold sh.mu for writing.
+func (s *Storage) zsetForWriteLocked(sh *storageShard, key string, create bool) (*StorageItem, error) {
+	now := utcNow()
+	item, err := s.writableLocked(sh, key, TypeZSet, now)
+	if item != nil || err != nil || !create {
+		return item, err
+	}
+
+	item = &StorageItem{Type: TypeZSet, ZSet: newSortedSet()}
+	s.storeLocked(sh, key, item, now)
+	return item, nil
+}
+
+// zaddLocked sets the score of member, or increments it by score when incr,
+// unless opts prevent it, and returns the resulting score.
+func (s *Storage) zaddLocked(item *StorageItem, member string, score float64, opts ZAddOptions, incr bool) (float64, zaddResult, error) {
+	cur, exists := item.ZSet.score(member)
+	if !exists {
+		if opts.XX {
+			return 0, zaddSkipped, nil
+		}
+
+		item.ZSet.set(member, score)
+		s.resizeLocked(item, zsetMemberSize(member))
+		return score, zaddAdded, nil
+	}
+
+	if opts.NX {
+		return cur, zaddSkipped, nil
+	}
+	if incr {
+		score += cur
+		if math.IsNaN(score) {
+			return 0, zaddSkipped, ErrScoreNaN
+		}
+	}
+	if (opts.GT && score <= cur) || (opts.LT && score >= cur) {
+		return cur, zaddSkipped, nil
+	}
+	if score == cur {
+		return cur, zaddUnchanged, nil
+	}
+
+	item.ZSet.set(member, score)
+	item.version++
+	s.dirty.Add(1)
+	return score, zaddUpdated, nil
+}
+
+// ZRem removes members from the sorted set under key and returns how many
+// of them were members. The key is removed with its last member.
+func (s *Storage) ZRem(key string, members []string) (int, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeZSet, utcNow())
+	if item == nil {
+		return 0, err
+	}
+
+	cmd := command("ZREM", key)
+	for _, m := range members {
+		if !item.ZSet.remove(m) {
+			continue
+		}
+
+		s.resizeLocked(item, -zsetMemberSize(m))
+		cmd = append(cmd, []byte(m))
+	}
+
+	if len(cmd) > 2 {
+		s.propagate(cmd)
+	}
+	s.dropIfEmptyLocked(sh, key, item)
+	return len(cmd) - 2, nil
+}
+
+func (s *Storage) ZScore(key, member string) (float64, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeZSet)
+	if item == nil {
+		return 0, false, err
+	}
+
+	score, exists := item.ZSet.score(member)
+	return score, exists, nil
+}
+
+func (s *Storage) ZCard(key string) (int, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeZSet)
+	if item == nil {
+		return 0, err
+	}
+	return item.ZSet.Len(), nil
+}
+
+// ZRank returns the 0-based rank of member by ascending score, or by
+// descending score when rev.
+func (s *Storage) ZRank(key, member string, rev bool) (int, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeZSet)
+	if item == nil {
+		return 0, false, err
+	}
+
+	rank, exists := item.ZSet.rank(member, rev)
+	return rank, exists, nil
+}
+
+func (s *Storage) ZRange(key string, spec ZRangeSpec) ([]ZMember, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeZSet)
+	if item == nil {
+		return nil, err
+	}
+	return zrange(item.ZSet, spec), nil
+}
+
+// ZRangeStore stores the result of ZRange on src under dst, see
+// zstoreLocked, and returns its size.
+func (s *Storage) ZRangeStore(dst, src string, spec ZRangeSpec) (int, error) {
+	if err := s.reserve(dst, int64(len(dst)+itemOverhead)); err != nil {
+		return 0, err
+	}
+
+	srcShard, dstShard, unlock := s.lockPair(src, dst)
+	defer unlock()
+
+	item, err := s.readableLocked(srcShard, src, TypeZSet)
+	if err != nil {
+		return 0, err
+	}
+
+	var members []ZMember
+	if item != nil {
+		members = zrange(item.ZSet, spec)
+	}
+	return s.zstoreLocked(dstShard, dst, members), nil
+}
+
+// ZCount returns the number of members with a score in r.
+func (s *Storage) ZCount(key string, r ScoreRange) (int, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	item, err := s.readableLocked(sh, key, TypeZSet)
+	if item == nil {
+		return 0, err
+	}
+	return item.ZSet.countInRange(r), nil
+}
+
+// ZPop removes and returns up to count members with the lowest scores, or
+// the highest when max.
+func (s *Storage) ZPop(key string, max bool, count int) ([]ZMember, error) {
+	sh := s.shardFor(key)
+	sh.lock()
+	defer sh.mu.Unlock()
+
+	item, err := s.writableLocked(sh, key, TypeZSet, utcNow())
+	if item == nil {
+		return nil, err
+	}
+
+	popped := make([]ZMember, 0, min(count, item.ZSet.Len()))
+	cmd := command("ZREM", key)
+	for len(popped) < count && item.ZSet.Len() > 0 {
+		m := s.zpopLocked(item, max)
+		popped = append(popped, m)
+		cmd = append(cmd, []byte(m.Member))
+	}
+
+	if len(popped) > 0 {
+		s.propagate(cmd)
+	}
+	s.dropIfEmptyLocked(sh, key, item)
+	return popped, nil
+}
+
+// BlockingZPop pops the member with the lowest score, or the highest when
+// max, from the first non-empty sorted set of keys, waiting like
+// BlockingPop when all of them are empty.
+func (s *Storage) BlockingZPop(ctx context.Context, keys []string, max bool, timeout time.Duration) (string, ZMember, bool, error) {
+	d, ok, err := s.blockingPop(ctx, keys, TypeZSet, !max, timeout)
+	return d.key, ZMember{Member: string(d.elem), Score: d.score}, ok, err
+}
+
+// ZSetOpStore combines the sorted sets under keys into dst, see
+// zstoreLocked. Scores are multiplied by the matching weight, all 1 when
+// weights is nil, and a member in several sets gets the scores combined
+// by agg. Plain sets count as sorted sets with all scores 1. It returns
+// the size of the result.
+func (s *Storage) ZSetOpStore(op SetOp, dst string, keys []string, weights []float64, agg ZAggregate) (int, error) {
+	if err := s.reserve(dst, int64(len(dst)+itemOverhead)); err != nil {
+		return 0, err
+	}
+
+	unlock := s.lockKeys(append([]string{dst}, keys...), true)
+	defer unlock()
+
+	now := utcNow()
+	inputs := make([]func(string) (float64, bool), len(keys))
+	all := make([][]ZMember, len(keys))
+	for i, key := range keys {
+		inputs[i] = func(string) (float64, bool) { return 0, false }
+
+		item := s.shardFor(key).lookupLocked(key, now)
+		if item == nil {
+			continue
+		}
+
+		switch item.Type {
+		case TypeZSet:
+			inputs[i] = item.ZSet.score
+			all[i] = item.ZSet.all()
+		case TypeSet:
+			set := item.Set
+			inputs[i] = func(m string) (float64, bool) {
+				_, exists := set[m]
+				return 1, exists
+			}
+			for m := range set {
+				all[i] = append(all[i], ZMember{Member: m, Score: 1})
+			}
+		default:
+			return 0, ErrWrongType
+		}
+		item.touch(now)
+	}
+
+	weight := func(i int, score float64) float64 {
+		if weights == nil {
+			return score
+		}
+		// Redis treats 0 * Inf as 0 rather than NaN.
+		if w := score * weights[i]; !math.IsNaN(w) {
+			return w
+		}
+		return 0
+	}
+
+	scores := make(map[string]float64)
+	switch op {
+	case SetUnion:
+		for i, members := range all {
+			for _, m := range members {
+				score := weight(i, m.Score)
+				if acc, exists := scores[m.Member]; exists {
+					score = aggregate(agg, acc, score)
+				}
+				scores[m.Member] = score
+			}
+		}
+	case SetInter:
+		for _, m := range all[0] {
+			score := weight(0, m.Score)
+			found := true
+			for i := 1; i < len(inputs) && found; i++ {
+				var other float64
+				other, found = inputs[i](m.Member)
+				score = aggregate(agg, score, weight(i, other))
+			}
+			if found {
+				scores[m.Member] = score
+			}
+		}
+	}
+
+	members := make([]ZMember, 0, len(scores))
+	for m, score := range scores {
+		members = append(members, ZMember{Member: m, Score: score})
+	}
+	return s.zstoreLocked(s.shardFor(dst), dst, members), nil
+}
+
+// zstoreLocked replaces whatever dst held with a sorted set of members, or
+// removes it when members is empty, and returns the size stored. The write
+// is propagated as its result, so replicas do not evaluate the query
+// again. The caller must hold sh.mu for writing.
+func (s *Storage) zstoreLocked(sh *storageShard, dst string, members []ZMember) int {
+	existed := s.removeLocked(sh, dst)
+	s.propagate(command("DEL", dst))
+	if len(members) == 0 {
+		if existed {
+			s.notify(NotifyGeneric, "del", dst)
+		}
+		return 0
+	}
+
+	item := &StorageItem{Type: TypeZSet, ZSet: newSortedSet()}
+	args := make([][]byte, 0, 2*len(members))
+	for _, m := range members {
+		item.ZSet.set(m.Member, m.Score)
+		item.size += zsetMemberSize(m.Member)
+		args = append(args, formatScore(m.Score), []byte(m.Member))
+	}
+	s.storeLocked(sh, dst, item, utcNow())
+
+	for _, cmd := range chunkCommands("ZADD", dst, args, 2) {
+		s.propagate(cmd)
+	}
+	s.serveWaitersLocked(sh, dst, item)
+	return len(members)
+}
+
+func (s *Storage) zpopLocked(item *StorageItem, max bool) ZMember {
+	m := item.ZSet.pop(max)
+	s.resizeLocked(item, -zsetMemberSize(m.Member))
+	return m
+}
+
+func zrange(z *SortedSet, spec ZRangeSpec) []ZMember {
+	switch spec.By {
+	case ZByScore:
+		return z.rangeByScore(spec.Score, spec.Rev, spec.Offset, spec.Count)
+	case ZByLex:
+		return z.rangeByLex(spec.Lex, spec.Rev, spec.Offset, spec.Count)
+	}
+
+	start, stop, ok := normalizeRange(spec.Start, spec.Stop, z.Len())
+	if !ok {
+		return nil
+	}
+	return z.rangeByRank(start, stop, spec.Rev)
+}
+
+func aggregate(agg ZAggregate, a, b float64) float64 {
+	switch agg {
+	case ZAggMin:
+		return min(a, b)
+	case ZAggMax:
+		return max(a, b)
+	}
+	// Inf + -Inf is NaN, which Redis turns into 0.
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// formatScore formats a score so that parsing it back gives the same float.
+func formatScore(score float64) []byte {
+	return strconv.AppendFloat(nil, score, 'g', -1, 64)
+}
+
+func zsetMemberSize(member string) int64 {
+	return int64(len(member) + zsetMemberOverhead)
+}
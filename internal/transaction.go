@@ -0,0 +1,109 @@
+package internal
+
+// Shared marks the start of a client operation on keys, such as a RESP
+// command or an HTTP request, and returns the function marking its end.
+// Operations run concurrently with each other, but never while a
+// transaction holds Exclusive on the shard of one of their keys. Storage
+// methods do not take the gates themselves, except for the blocking pops
+// while they are not waiting.
+func (s *Storage) Shared(keys ...string) (unlock func()) {
+	if len(keys) == 1 {
+		// Most commands have a single key, which needs no sorting.
+		sh := s.shardFor(keys[0])
+		sh.gate.RLock()
+		return sh.gate.RUnlock
+	}
+	return s.gateShards(s.shardIndexes(keys), false)
+}
+
+// SharedAll is Shared for an operation on the whole keyspace, such as KEYS.
+func (s *Storage) SharedAll() (unlock func()) {
+	return s.gateShards(s.allShards(), false)
+}
+
+// Exclusive waits for the client operations in progress on the shards of
+// keys to end and holds off new ones until unlock is called, so the
+// commands of a transaction on keys run as a whole. Operations on the
+// other shards go on meanwhile.
+func (s *Storage) Exclusive(keys ...string) (unlock func()) {
+	return s.gateShards(s.shardIndexes(keys), true)
+}
+
+// ExclusiveAll is Exclusive for a transaction on the whole keyspace.
+func (s *Storage) ExclusiveAll() (unlock func()) {
+	return s.gateShards(s.allShards(), true)
+}
+
+// gateShards takes the gates of the shards at indexes, which must be sorted
+// so that gates are always taken in the same order.
+func (s *Storage) gateShards(indexes []uint32, exclusive bool) func() {
+	for _, i := range indexes {
+		if exclusive {
+			s.shards[i].gate.Lock()
+		} else {
+			s.shards[i].gate.RLock()
+		}
+	}
+
+	return func() {
+		for _, i := range indexes {
+			if exclusive {
+				s.shards[i].gate.Unlock()
+			} else {
+				s.shards[i].gate.RUnlock()
+			}
+		}
+	}
+}
+
+func (s *Storage) allShards() []uint32 {
+	indexes := make([]uint32, len(s.shards))
+	for i := range indexes {
+		indexes[i] = uint32(i)
+	}
+	return indexes
+}
+
+// WatchedKey remembers the state of a key for WATCH.
+type WatchedKey struct {
+	key     string
+	item    *StorageItem
+	version uint64
+}
+
+// Key returns the watched key.
+func (w WatchedKey) Key() string {
+	return w.key
+}
+
+// Watch returns the current state of key, to be checked by Modified.
+func (s *Storage) Watch(key string) WatchedKey {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	w := WatchedKey{key: key, item: sh.lookupLocked(key, utcNow())}
+	if w.item != nil {
+		w.version = w.item.version
+	}
+	return w
+}
+
+// Modified reports whether any of the watched keys was written, deleted or
+// expired since it was watched. A key that was missing throughout counts
+// as unmodified, even if it existed for a while in between.
+func (s *Storage) Modified(watched []WatchedKey) bool {
+	now := utcNow()
+	for _, w := range watched {
+		sh := s.shardFor(w.key)
+		sh.mu.RLock()
+		item := sh.lookupLocked(w.key, now)
+		modified := item != w.item || (item != nil && item.version != w.version)
+		sh.mu.RUnlock()
+
+		if modified {
+			return true
+		}
+	}
+	return false
+}
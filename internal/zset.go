@@ -0,0 +1,445 @@
+package internal
+
+import (
+	"math/rand/v2"
+)
+
+const (
+	zslMaxLevel = 32
+	// zslP is the chance of a node reaching each next level.
+	zslP = 0.25
+)
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreRange is an interval of scores, each end inclusive unless marked
+// exclusive.
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+func (r ScoreRange) aboveMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r ScoreRange) belowMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// LexBound is one end of a LexRange. Inf set to -1 or 1 stands for the
+// "-" and "+" bounds, below and above every member.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+// LexRange is an interval of members compared bytewise, meaningful when
+// all members share the same score.
+type LexRange struct {
+	Min, Max LexBound
+}
+
+func (r LexRange) aboveMin(member string) bool {
+	switch {
+	case r.Min.Inf != 0:
+		return r.Min.Inf < 0
+	case r.Min.Exclusive:
+		return member > r.Min.Value
+	}
+	return member >= r.Min.Value
+}
+
+func (r LexRange) belowMax(member string) bool {
+	switch {
+	case r.Max.Inf != 0:
+		return r.Max.Inf > 0
+	case r.Max.Exclusive:
+		return member < r.Max.Value
+	}
+	return member <= r.Max.Value
+}
+
+// SortedSet maps members to scores and keeps them ordered by score, then
+// member, in a skip list with spans, so lookups by member are constant
+// time and lookups by rank or score logarithmic.
+type SortedSet struct {
+	scores map[string]float64
+	zsl    *skiplist
+}
+
+func newSortedSet() *SortedSet {
+	return &SortedSet{
+		scores: make(map[string]float64),
+		zsl:    newSkiplist(),
+	}
+}
+
+func (z *SortedSet) Len() int {
+	return len(z.scores)
+}
+
+func (z *SortedSet) score(member string) (float64, bool) {
+	score, exists := z.scores[member]
+	return score, exists
+}
+
+// set adds member or moves it to score. It reports whether member is new.
+func (z *SortedSet) set(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.zsl.delete(old, member)
+	}
+
+	z.scores[member] = score
+	z.zsl.insert(score, member)
+	return !exists
+}
+
+func (z *SortedSet) remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+
+	delete(z.scores, member)
+	z.zsl.delete(score, member)
+	return true
+}
+
+// rank returns the 0-based position of member, counted from the highest
+// score when rev.
+func (z *SortedSet) rank(member string, rev bool) (int, bool) {
+	score, exists := z.scores[member]
+	if !exists {
+		return 0, false
+	}
+
+	rank := z.zsl.rank(score, member)
+	if rev {
+		return z.Len() - rank, true
+	}
+	return rank - 1, true
+}
+
+// rangeByRank returns the members from start to stop inclusive, which must
+// be valid positions, counted from the highest score when rev.
+func (z *SortedSet) rangeByRank(start, stop int, rev bool) []ZMember {
+	var x *zslNode
+	if rev {
+		x = z.zsl.byRank(z.Len() - start)
+	} else {
+		x = z.zsl.byRank(start + 1)
+	}
+
+	members := make([]ZMember, 0, stop-start+1)
+	for range stop - start + 1 {
+		members = append(members, ZMember{Member: x.member, Score: x.score})
+		x = x.next(rev)
+	}
+	return members
+}
+
+// rangeByScore returns the members with a score in r, from the highest
+// when rev, skipping offset of them and returning at most count, all when
+// count is negative.
+func (z *SortedSet) rangeByScore(r ScoreRange, rev bool, offset, count int) []ZMember {
+	var x *zslNode
+	if rev {
+		x = z.zsl.lastInRange(r.aboveMin, r.belowMax)
+	} else {
+		x = z.zsl.firstInRange(r.aboveMin, r.belowMax)
+	}
+	return collectRange(x, rev, offset, count, func(x *zslNode) bool {
+		return r.aboveMin(x.score) && r.belowMax(x.score)
+	})
+}
+
+// rangeByLex is rangeByScore for a range of members.
+func (z *SortedSet) rangeByLex(r LexRange, rev bool, offset, count int) []ZMember {
+	var x *zslNode
+	aboveMin := func(n *zslNode) bool { return r.aboveMin(n.member) }
+	belowMax := func(n *zslNode) bool { return r.belowMax(n.member) }
+	if rev {
+		x = z.zsl.lastInRangeNode(aboveMin, belowMax)
+	} else {
+		x = z.zsl.firstInRangeNode(aboveMin, belowMax)
+	}
+	return collectRange(x, rev, offset, count, func(x *zslNode) bool {
+		return aboveMin(x) && belowMax(x)
+	})
+}
+
+// countInRange returns the number of members with a score in r.
+func (z *SortedSet) countInRange(r ScoreRange) int {
+	first := z.zsl.firstInRange(r.aboveMin, r.belowMax)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.lastInRange(r.aboveMin, r.belowMax)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// pop removes the member with the lowest score, or the highest when max.
+// The set must not be empty.
+func (z *SortedSet) pop(max bool) ZMember {
+	x := z.zsl.header.level[0].forward
+	if max {
+		x = z.zsl.tail
+	}
+
+	m := ZMember{Member: x.member, Score: x.score}
+	z.remove(x.member)
+	return m
+}
+
+// all returns every member in score order.
+func (z *SortedSet) all() []ZMember {
+	members := make([]ZMember, 0, z.Len())
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		members = append(members, ZMember{Member: x.member, Score: x.score})
+	}
+	return members
+}
+
+func collectRange(x *zslNode, rev bool, offset, count int, in func(*zslNode) bool) []ZMember {
+	if offset < 0 {
+		return nil
+	}
+
+	for ; x != nil && offset > 0 && in(x); offset-- {
+		x = x.next(rev)
+	}
+
+	var members []ZMember
+	for ; x != nil && count != 0 && in(x); count-- {
+		members = append(members, ZMember{Member: x.member, Score: x.score})
+		x = x.next(rev)
+	}
+	return members
+}
+
+// skiplist is the ordered index of a SortedSet, after the one in Redis.
+// Each level link records its span, the number of nodes it skips, which
+// makes ranks computable while descending.
+type skiplist struct {
+	header *zslNode
+	tail   *zslNode
+	length int
+	level  int
+}
+
+type zslNode struct {
+	member   string
+	score    float64
+	backward *zslNode
+	level    []zslLevel
+}
+
+type zslLevel struct {
+	forward *zslNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &zslNode{level: make([]zslLevel, zslMaxLevel)},
+		level:  1,
+	}
+}
+
+func (x *zslNode) next(rev bool) *zslNode {
+	if rev {
+		return x.backward
+	}
+	return x.level[0].forward
+}
+
+// before reports whether x sorts before score and member.
+func (x *zslNode) before(score float64, member string) bool {
+	return x.score < score || (x.score == score && x.member < member)
+}
+
+func randomLevel() int {
+	level := 1
+	for level < zslMaxLevel && rand.Float64() < zslP {
+		level++
+	}
+	return level
+}
+
+func (zsl *skiplist) insert(score float64, member string) {
+	var update [zslMaxLevel]*zslNode
+	var rank [zslMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zslNode{member: member, score: score, level: make([]zslLevel, level)}
+	for i := range level {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+func (zsl *skiplist) delete(score float64, member string) {
+	var update [zslMaxLevel]*zslNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+
+	for i := range zsl.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// rank returns the 1-based rank of member, which must be in the list.
+func (zsl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !(score < x.level[i].forward.score ||
+			(score == x.level[i].forward.score && member < x.level[i].forward.member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank, or nil when out of range.
+func (zsl *skiplist) byRank(rank int) *zslNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+func (zsl *skiplist) firstInRange(aboveMin, belowMax func(float64) bool) *zslNode {
+	return zsl.firstInRangeNode(
+		func(x *zslNode) bool { return aboveMin(x.score) },
+		func(x *zslNode) bool { return belowMax(x.score) },
+	)
+}
+
+func (zsl *skiplist) lastInRange(aboveMin, belowMax func(float64) bool) *zslNode {
+	return zsl.lastInRangeNode(
+		func(x *zslNode) bool { return aboveMin(x.score) },
+		func(x *zslNode) bool { return belowMax(x.score) },
+	)
+}
+
+// firstInRangeNode returns the first node within a range given by its two
+// ends, or nil when no node is. Both ends must be monotonic in list order.
+func (zsl *skiplist) firstInRangeNode(aboveMin, belowMax func(*zslNode) bool) *zslNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !aboveMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !belowMax(x) {
+		return nil
+	}
+	return x
+}
+
+// lastInRangeNode is firstInRangeNode for the last node within the range.
+func (zsl *skiplist) lastInRangeNode(aboveMin, belowMax func(*zslNode) bool) *zslNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && belowMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+
+	if x == zsl.header || !aboveMin(x) {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,326 @@
+package internal
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// checkSkiplist verifies the links and spans of every level of z against
+// its scores, and returns its members in order.
+func checkSkiplist(t *testing.T, z *SortedSet) []ZMember {
+	t.Helper()
+
+	zsl := z.zsl
+	want := make([]ZMember, 0, len(z.scores))
+	for member, score := range z.scores {
+		want = append(want, ZMember{Member: member, Score: score})
+	}
+	slices.SortFunc(want, func(a, b ZMember) int {
+		return cmp.Or(cmp.Compare(a.Score, b.Score), cmp.Compare(a.Member, b.Member))
+	})
+
+	if zsl.length != len(want) {
+		t.Fatalf("length is %d, want %d", zsl.length, len(want))
+	}
+
+	got := z.all()
+	if len(got) == 0 {
+		got = nil
+	}
+	if len(want) == 0 {
+		want = nil
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("members are %v, want %v", got, want)
+	}
+
+	var prev *zslNode
+	for x := zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if x.backward != prev {
+			t.Fatalf("backward link of %q is wrong", x.member)
+		}
+		prev = x
+	}
+	if zsl.tail != prev {
+		t.Fatalf("tail is wrong")
+	}
+
+	for i := range zsl.level {
+		rank := 0
+		for x := zsl.header; ; x = x.level[i].forward {
+			next := x.level[i].forward
+			if next == nil {
+				if rank+x.level[i].span != zsl.length && x != zsl.header {
+					t.Fatalf("level %d: last span %d at rank %d, length %d", i, x.level[i].span, rank, zsl.length)
+				}
+				break
+			}
+
+			rank += x.level[i].span
+			if want[rank-1].Member != next.member {
+				t.Fatalf("level %d: %q found at rank %d, want %q", i, next.member, rank, want[rank-1].Member)
+			}
+		}
+	}
+	return want
+}
+
+func sortedSetOf(members ...ZMember) *SortedSet {
+	z := newSortedSet()
+	for _, m := range members {
+		z.set(m.Member, m.Score)
+	}
+	return z
+}
+
+func TestSortedSetSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		ops    []ZMember
+		added  []bool
+		result []ZMember
+	}{
+		{
+			name:   "ordered by score",
+			ops:    []ZMember{{"c", 3}, {"a", 1}, {"b", 2}},
+			added:  []bool{true, true, true},
+			result: []ZMember{{"a", 1}, {"b", 2}, {"c", 3}},
+		},
+		{
+			name:   "ties ordered by member",
+			ops:    []ZMember{{"b", 1}, {"c", 1}, {"a", 1}},
+			added:  []bool{true, true, true},
+			result: []ZMember{{"a", 1}, {"b", 1}, {"c", 1}},
+		},
+		{
+			name:   "update moves member",
+			ops:    []ZMember{{"a", 1}, {"b", 2}, {"c", 3}, {"a", 4}},
+			added:  []bool{true, true, true, false},
+			result: []ZMember{{"b", 2}, {"c", 3}, {"a", 4}},
+		},
+		{
+			name:   "update to the same score",
+			ops:    []ZMember{{"a", 1}, {"b", 2}, {"a", 1}},
+			added:  []bool{true, true, false},
+			result: []ZMember{{"a", 1}, {"b", 2}},
+		},
+		{
+			name:   "infinite scores",
+			ops:    []ZMember{{"z", math.Inf(1)}, {"m", 0}, {"a", math.Inf(-1)}},
+			added:  []bool{true, true, true},
+			result: []ZMember{{"a", math.Inf(-1)}, {"m", 0}, {"z", math.Inf(1)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := newSortedSet()
+			for i, op := range tt.ops {
+				if added := z.set(op.Member, op.Score); added != tt.added[i] {
+					t.Errorf("set(%q, %v) = %t, want %t", op.Member, op.Score, added, tt.added[i])
+				}
+			}
+
+			if got := checkSkiplist(t, z); !reflect.DeepEqual(got, tt.result) {
+				t.Errorf("got %v, want %v", got, tt.result)
+			}
+		})
+	}
+}
+
+func TestSortedSetRemove(t *testing.T) {
+	tests := []struct {
+		name    string
+		member  string
+		removed bool
+		result  []ZMember
+	}{
+		{"head", "a", true, []ZMember{{"b", 2}, {"c", 3}}},
+		{"middle", "b", true, []ZMember{{"a", 1}, {"c", 3}}},
+		{"tail", "c", true, []ZMember{{"a", 1}, {"b", 2}}},
+		{"missing", "d", false, []ZMember{{"a", 1}, {"b", 2}, {"c", 3}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := sortedSetOf(ZMember{"a", 1}, ZMember{"b", 2}, ZMember{"c", 3})
+			if removed := z.remove(tt.member); removed != tt.removed {
+				t.Errorf("remove(%q) = %t, want %t", tt.member, removed, tt.removed)
+			}
+
+			if got := checkSkiplist(t, z); !reflect.DeepEqual(got, tt.result) {
+				t.Errorf("got %v, want %v", got, tt.result)
+			}
+		})
+	}
+
+	t.Run("all", func(t *testing.T) {
+		z := sortedSetOf(ZMember{"a", 1}, ZMember{"b", 2})
+		z.remove("b")
+		z.remove("a")
+		checkSkiplist(t, z)
+		if z.zsl.tail != nil || z.zsl.level != 1 {
+			t.Errorf("tail %v and level %d left in an empty list", z.zsl.tail, z.zsl.level)
+		}
+	})
+}
+
+func TestSortedSetRank(t *testing.T) {
+	z := sortedSetOf(ZMember{"a", 1}, ZMember{"b", 2}, ZMember{"c", 2}, ZMember{"d", 4})
+
+	tests := []struct {
+		member string
+		rev    bool
+		rank   int
+		exists bool
+	}{
+		{"a", false, 0, true},
+		{"c", false, 2, true},
+		{"d", false, 3, true},
+		{"a", true, 3, true},
+		{"d", true, 0, true},
+		{"b", true, 2, true},
+		{"x", false, 0, false},
+	}
+
+	for _, tt := range tests {
+		rank, exists := z.rank(tt.member, tt.rev)
+		if rank != tt.rank || exists != tt.exists {
+			t.Errorf("rank(%q, %t) = %d, %t, want %d, %t", tt.member, tt.rev, rank, exists, tt.rank, tt.exists)
+		}
+	}
+}
+
+func TestSortedSetRangeByRank(t *testing.T) {
+	z := sortedSetOf(ZMember{"a", 1}, ZMember{"b", 2}, ZMember{"c", 3}, ZMember{"d", 4})
+
+	tests := []struct {
+		start, stop int
+		rev         bool
+		want        []ZMember
+	}{
+		{0, 3, false, []ZMember{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 4}}},
+		{0, 0, false, []ZMember{{"a", 1}}},
+		{3, 3, false, []ZMember{{"d", 4}}},
+		{1, 2, false, []ZMember{{"b", 2}, {"c", 3}}},
+		{0, 1, true, []ZMember{{"d", 4}, {"c", 3}}},
+		{3, 3, true, []ZMember{{"a", 1}}},
+	}
+
+	for _, tt := range tests {
+		if got := z.rangeByRank(tt.start, tt.stop, tt.rev); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rangeByRank(%d, %d, %t) = %v, want %v", tt.start, tt.stop, tt.rev, got, tt.want)
+		}
+	}
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	z := sortedSetOf(ZMember{"a", 1}, ZMember{"b", 2}, ZMember{"c", 2}, ZMember{"d", 3})
+	inf := math.Inf(1)
+
+	tests := []struct {
+		name          string
+		r             ScoreRange
+		rev           bool
+		offset, count int
+		want          []ZMember
+	}{
+		{"all", ScoreRange{Min: -inf, Max: inf}, false, 0, -1, []ZMember{{"a", 1}, {"b", 2}, {"c", 2}, {"d", 3}}},
+		{"inclusive", ScoreRange{Min: 2, Max: 2}, false, 0, -1, []ZMember{{"b", 2}, {"c", 2}}},
+		{"exclusive min", ScoreRange{Min: 1, Max: 3, MinEx: true}, false, 0, -1, []ZMember{{"b", 2}, {"c", 2}, {"d", 3}}},
+		{"exclusive max", ScoreRange{Min: 1, Max: 3, MaxEx: true}, false, 0, -1, []ZMember{{"a", 1}, {"b", 2}, {"c", 2}}},
+		{"exclusive both ends", ScoreRange{Min: 2, Max: 2, MinEx: true, MaxEx: true}, false, 0, -1, nil},
+		{"below every score", ScoreRange{Min: -inf, Max: 0}, false, 0, -1, nil},
+		{"above every score", ScoreRange{Min: 4, Max: inf}, false, 0, -1, nil},
+		{"empty range", ScoreRange{Min: 3, Max: 1}, false, 0, -1, nil},
+		{"rev", ScoreRange{Min: 2, Max: inf}, true, 0, -1, []ZMember{{"d", 3}, {"c", 2}, {"b", 2}}},
+		{"offset and count", ScoreRange{Min: -inf, Max: inf}, false, 1, 2, []ZMember{{"b", 2}, {"c", 2}}},
+		{"rev offset and count", ScoreRange{Min: -inf, Max: inf}, true, 1, 2, []ZMember{{"c", 2}, {"b", 2}}},
+		{"offset past the range", ScoreRange{Min: 1, Max: 2}, false, 3, -1, nil},
+		{"negative offset", ScoreRange{Min: -inf, Max: inf}, false, -1, -1, nil},
+		{"zero count", ScoreRange{Min: -inf, Max: inf}, false, 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := z.rangeByScore(tt.r, tt.rev, tt.offset, tt.count); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortedSetCountInRange(t *testing.T) {
+	z := sortedSetOf(ZMember{"a", 1}, ZMember{"b", 2}, ZMember{"c", 2}, ZMember{"d", 3})
+
+	tests := []struct {
+		r    ScoreRange
+		want int
+	}{
+		{ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 4},
+		{ScoreRange{Min: 2, Max: 2}, 2},
+		{ScoreRange{Min: 1, Max: 3, MinEx: true, MaxEx: true}, 2},
+		{ScoreRange{Min: 4, Max: 5}, 0},
+		{ScoreRange{Min: 3, Max: 1}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := z.countInRange(tt.r); got != tt.want {
+			t.Errorf("countInRange(%+v) = %d, want %d", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestSortedSetRangeByLex(t *testing.T) {
+	z := sortedSetOf(ZMember{"a", 0}, ZMember{"b", 0}, ZMember{"c", 0}, ZMember{"d", 0})
+	neg := LexBound{Inf: -1}
+	pos := LexBound{Inf: 1}
+
+	tests := []struct {
+		name string
+		r    LexRange
+		rev  bool
+		want []ZMember
+	}{
+		{"all", LexRange{neg, pos}, false, []ZMember{{"a", 0}, {"b", 0}, {"c", 0}, {"d", 0}}},
+		{"inclusive", LexRange{LexBound{Value: "b"}, LexBound{Value: "c"}}, false, []ZMember{{"b", 0}, {"c", 0}}},
+		{"exclusive", LexRange{LexBound{Value: "a", Exclusive: true}, LexBound{Value: "d", Exclusive: true}}, false, []ZMember{{"b", 0}, {"c", 0}}},
+		{"between members", LexRange{LexBound{Value: "bb"}, pos}, false, []ZMember{{"c", 0}, {"d", 0}}},
+		{"empty range", LexRange{LexBound{Value: "c"}, LexBound{Value: "b"}}, false, nil},
+		{"rev", LexRange{neg, LexBound{Value: "b"}}, true, []ZMember{{"b", 0}, {"a", 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := z.rangeByLex(tt.r, tt.rev, 0, -1); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSortedSetRandom checks the skip list after every step of a random
+// mix of inserts, score updates and removals.
+func TestSortedSetRandom(t *testing.T) {
+	z := newSortedSet()
+	for range 2000 {
+		member := strconv.Itoa(rand.IntN(200))
+		if rand.IntN(3) == 0 {
+			z.remove(member)
+		} else {
+			z.set(member, float64(rand.IntN(50)))
+		}
+
+		members := checkSkiplist(t, z)
+		for i, m := range members {
+			if rank, _ := z.rank(m.Member, false); rank != i {
+				t.Fatalf("rank of %q is %d, want %d", m.Member, rank, i)
+			}
+		}
+	}
+}